@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/acoshift/kv-test-perf/pkg/bench"
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	var xs stringList
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			xs = append(xs, f)
+		}
+	}
+	*l = xs
+	return nil
+}
+
+type intList []int
+
+func (l *intList) String() string {
+	xs := make([]string, len(*l))
+	for i, x := range *l {
+		xs[i] = strconv.Itoa(x)
+	}
+	return strings.Join(xs, ",")
+}
+
+func (l *intList) Set(v string) error {
+	var xs intList
+	for _, f := range strings.Split(v, ",") {
+		x, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return err
+		}
+		if x <= 0 {
+			return fmt.Errorf("invalid worker count: %d", x)
+		}
+		xs = append(xs, x)
+	}
+	*l = xs
+	return nil
+}
+
+// optionMap collects name=value pairs, comma-separated or over repeated
+// flags.
+type optionMap map[string]string
+
+func (m optionMap) String() string {
+	xs := make([]string, 0, len(m))
+	for k, v := range m {
+		xs = append(xs, k+"="+v)
+	}
+	sort.Strings(xs)
+	return strings.Join(xs, ",")
+}
+
+func (m optionMap) Set(v string) error {
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("invalid option: %s (want name=value)", f)
+		}
+		m[k] = v
+	}
+	return nil
+}
+
+// phaseOverrides collects -phase settings by phase name; settings for the
+// same phase over several flags add up.
+type phaseOverrides map[string]bench.PhaseOverride
+
+func (m phaseOverrides) String() string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (m phaseOverrides) Set(v string) error {
+	name, o, err := bench.ParsePhaseOverride(v)
+	if err != nil {
+		return err
+	}
+	cur := m[name]
+	if o.Workers > 0 {
+		cur.Workers = o.Workers
+	}
+	if o.Duration > 0 {
+		cur.Duration = o.Duration
+	}
+	if o.Rate > 0 {
+		cur.Rate = o.Rate
+	}
+	m[name] = cur
+	return nil
+}
+
+// tlsFlags registers -<prefix>-tls-ca, -tls-cert, -tls-key and
+// -tls-skip-verify, setting o.
+func tlsFlags(prefix string, o *kv.TLSOptions) {
+	flag.StringVar(&o.CA, prefix+"-tls-ca", "", prefix+" TLS: PEM file of the CAs to verify the server with, instead of the system's")
+	flag.StringVar(&o.Cert, prefix+"-tls-cert", "", prefix+" TLS: PEM client certificate file, for mutual TLS")
+	flag.StringVar(&o.Key, prefix+"-tls-key", "", prefix+" TLS: PEM client key file")
+	flag.BoolVar(&o.SkipVerify, prefix+"-tls-skip-verify", false, prefix+" TLS: don't verify the server's certificate")
+}
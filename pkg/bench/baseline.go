@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// ResultSummary is the serializable form of a phase Result.
+type ResultSummary struct {
+	Backend    string            `json:"backend"`
+	Workers    int               `json:"workers"`
+	Phase      string            `json:"phase"`
+	Run        int               `json:"run,omitempty"`
+	Step       int               `json:"step,omitempty"`
+	Total      uint64            `json:"total"`
+	OK         uint64            `json:"ok"`
+	Err        uint64            `json:"err"`
+	Anomalies  uint64            `json:"anomalies,omitempty"`
+	Errors     map[string]uint64 `json:"errors,omitempty"` // Err by class
+	Retried    uint64            `json:"retried,omitempty"`
+	Retries    uint64            `json:"retries,omitempty"`
+	Timeouts   uint64            `json:"timeouts,omitempty"`
+	Ops        float64           `json:"ops"`
+	IssueRate  float64           `json:"issue_rate"`
+	Durability string            `json:"durability,omitempty"`
+	Server     *kv.ServerInfo    `json:"server,omitempty"`
+	Mean       time.Duration     `json:"mean_ns"`
+	P50        time.Duration     `json:"p50_ns"`
+	P90        time.Duration     `json:"p90_ns"`
+	P99        time.Duration     `json:"p99_ns"`
+	P999       time.Duration     `json:"p999_ns"`
+	P9999      time.Duration     `json:"p9999_ns"`
+	Max        time.Duration     `json:"max_ns"`
+	MaxAt      time.Duration     `json:"max_at_ns"`
+}
+
+func (r Result) Summary() ResultSummary {
+	last := r.Samples[len(r.Samples)-1]
+	h := &r.Stats.latency
+	retried, retries := r.Stats.Retried()
+	return ResultSummary{
+		Backend:    r.Backend,
+		Workers:    r.Workers,
+		Phase:      r.Phase,
+		Run:        r.Run,
+		Step:       r.Step,
+		Total:      r.Total(),
+		OK:         last.OK,
+		Err:        last.Err,
+		Anomalies:  r.Stats.Anomalies(),
+		Errors:     r.Stats.Errors().Map(),
+		Retried:    retried,
+		Retries:    retries,
+		Timeouts:   r.Stats.Timeouts(),
+		Ops:        r.Ops(),
+		IssueRate:  r.IssueRate(),
+		Durability: r.Durability,
+		Server:     r.Server,
+		Mean:       h.Mean(),
+		P50:        h.Quantile(0.5),
+		P90:        h.Quantile(0.9),
+		P99:        h.Quantile(0.99),
+		P999:       h.Quantile(0.999),
+		P9999:      h.Quantile(0.9999),
+		Max:        h.Max(),
+		MaxAt:      r.Stats.MaxAt(),
+	}
+}
+
+func (r ResultSummary) key() string {
+	return fmt.Sprintf("%s/%d/%s/%d/%d", r.Backend, r.Workers, r.Phase, r.Step, r.Run)
+}
+
+type Baseline struct {
+	Created  time.Time       `json:"created"`
+	Metadata Metadata        `json:"metadata"`
+	Results  []ResultSummary `json:"results"`
+}
+
+func SaveBaseline(path string, md Metadata, results []Result) error {
+	b := Baseline{
+		Created:  time.Now().UTC(),
+		Metadata: md,
+	}
+	for _, r := range results {
+		b.Results = append(b.Results, r.Summary())
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	err = json.Unmarshal(data, &b)
+	if err != nil {
+		return nil, fmt.Errorf("baseline %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// Thresholds are the allowed regressions, in percent, before a comparison
+// fails.
+type Thresholds struct {
+	OpsDrop     float64
+	P99Increase float64
+}
+
+// CompareBaseline prints each matching phase's deltas against the baseline
+// and reports whether any exceeded the thresholds.
+func CompareBaseline(base *Baseline, results []Result, th Thresholds) (regressed bool) {
+	prev := make(map[string]ResultSummary, len(base.Results))
+	for _, r := range base.Results {
+		prev[r.key()] = r
+	}
+
+	fmt.Printf("==== baseline (%s) ====\n", base.Created.Format(time.RFC3339))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "workers\tphase\tbackend\tops\tΔops\tp99\tΔp99\t\t\n")
+	for _, res := range results {
+		cur := res.Summary()
+		old, ok := prev[cur.key()]
+		if !ok {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t-\t%s\t-\tnew\t\n", cur.Workers, cur.Phase, cur.Backend, cur.Ops, cur.P99)
+			continue
+		}
+
+		dOps := percentDelta(old.Ops, cur.Ops)
+		dP99 := percentDelta(float64(old.P99), float64(cur.P99))
+		status := "ok"
+		if -dOps > th.OpsDrop || dP99 > th.P99Increase {
+			status = "REGRESSION"
+			regressed = true
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%+.1f%%\t%s\t%+.1f%%\t%s\t\n", cur.Workers, cur.Phase, cur.Backend, cur.Ops, dOps, cur.P99, dP99, status)
+	}
+	w.Flush()
+	return regressed
+}
+
+func percentDelta(old, cur float64) float64 {
+	if old == 0 {
+		return 0
+	}
+	return (cur - old) / old * 100
+}
@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBaselineRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	results := []Result{
+		testResult("set", 200*time.Microsecond, 5000, 3),
+		testResult("get", 80*time.Microsecond, 9000, 0),
+	}
+	results[1].Run = 2
+	md := Metadata{GoVersion: "go1.21", NumCPU: 4, Seed: 42}
+
+	err := SaveBaseline(path, md, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Metadata.Seed != md.Seed || b.Metadata.NumCPU != md.NumCPU || b.Created.IsZero() {
+		t.Errorf("metadata = %+v, created %s", b.Metadata, b.Created)
+	}
+	for i, r := range results {
+		want := r.Summary()
+		if len(want.Errors) == 0 {
+			want.Errors = nil // omitted from the file
+		}
+		if !reflect.DeepEqual(b.Results[i], want) {
+			t.Errorf("result %d = %+v, want %+v", i, b.Results[i], want)
+		}
+	}
+
+	// a run compared against its own baseline hasn't regressed
+	if CompareBaseline(b, results, Thresholds{}) {
+		t.Error("identical results regressed")
+	}
+
+	_, err = LoadBaseline(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Error("loaded a missing baseline")
+	}
+}
+
+func TestCompareBaselineThresholds(t *testing.T) {
+	// ops and latencies a binary fraction apart, so the deltas are exact
+	base := &Baseline{Results: []ResultSummary{
+		testResult("get", 128, 1024, 0).Summary(),
+	}}
+	th := Thresholds{OpsDrop: 12.5, P99Increase: 25}
+
+	for _, tc := range []struct {
+		name      string
+		ops       uint64
+		p99       time.Duration
+		regressed bool
+	}{
+		{"unchanged", 1024, 128, false},
+		{"faster", 2048, 64, false},
+		{"ops drop at the threshold", 896, 128, false},
+		{"ops drop past it", 895, 128, true},
+		{"p99 rise at the threshold", 1024, 160, false},
+		{"p99 rise past it", 1024, 161, true},
+	} {
+		cur := []Result{testResult("get", tc.p99, tc.ops, 0)}
+		if got := CompareBaseline(base, cur, th); got != tc.regressed {
+			t.Errorf("%s: regressed = %t, want %t", tc.name, got, tc.regressed)
+		}
+	}
+
+	// results the baseline doesn't have are new, not regressions
+	cur := []Result{testResult("set", time.Second, 1, 0)}
+	if CompareBaseline(base, cur, th) {
+		t.Error("a phase missing from the baseline regressed")
+	}
+}
@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+type CapacityConfig struct {
+	Target    time.Duration // p99 read latency the dataset must stay within
+	Step      int           // keys inserted per step
+	Max       int           // stop growing at this many keys
+	ValueSize int
+	Workers   int
+	Seed      int64
+}
+
+// Capacity is the outcome of a capacity run on one backend.
+type Capacity struct {
+	Backend string
+	Keys    int           // largest dataset whose read p99 stayed within the target
+	P99     time.Duration // read p99 at Keys
+	Limit   string        // why growing stopped
+}
+
+// RunCapacity grows the dataset step by step, measuring uniform random reads
+// over the whole keyspace after each step, until read p99 exceeds the target
+// or the dataset reaches the maximum size. A cancelled ctx stops it early
+// with the last size measured in full.
+func RunCapacity(ctx context.Context, pr *PhaseRunner, store kv.KV, cc CapacityConfig) (Capacity, []Result, error) {
+	value := fillValue(cc.ValueSize)
+	c := Capacity{Backend: store.Name(), Limit: "max keys"}
+
+	fmt.Printf("capacity: %s, p99 target %s, %d keys per step\n", store.Name(), cc.Target, cc.Step)
+	var results []Result
+	for size := 0; size < cc.Max; {
+		next := size + cc.Step
+		if next > cc.Max {
+			next = cc.Max
+		}
+
+		fill := runPhase(ctx, store, fmt.Sprintf("fill-%d", next), PhaseConfig{Workers: cc.Workers}, runFill(size, next, cc.Workers, value))
+		if ctx.Err() != nil {
+			c.Limit = "stopped"
+			break
+		}
+		if last := fill.Samples[len(fill.Samples)-1]; last.Err > 0 {
+			return c, results, fmt.Errorf("capacity %s: %d errors filling to %d keys", store.Name(), last.Err, next)
+		}
+		size = next
+
+		r, err := pr.Run(ctx, store, Phase{Name: fmt.Sprintf("read-%d", size), Run: runReadRandom(size, cc.Seed)}, cc.Workers)
+		if err != nil {
+			return c, results, err
+		}
+		if ctx.Err() != nil {
+			// a read step cut short says nothing about this size
+			c.Limit = "stopped"
+			break
+		}
+		r.Backend = store.Name()
+		results = append(results, r)
+
+		p99 := r.Stats.latency.Quantile(0.99)
+		fmt.Printf("keys: %d ops: %.1f p99: %s\n", size, r.Ops(), p99)
+		if p99 > cc.Target {
+			c.Limit = fmt.Sprintf("p99 %s at %d keys", p99, size)
+			break
+		}
+		c.Keys, c.P99 = size, p99
+	}
+	return c, results, nil
+}
+
+// runFill inserts keys [from, to); worker i takes keys from+i, from+i+n, ...
+func runFill(from, to, n int, value string) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		for k := from + i; k < to; k += n {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+			err = store.Set(ctx, workerKey(k), value)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+// runReadRandom reads uniformly random keys from [0, size). Keys are
+// formatted per operation since the keyspace can be too large to keep.
+func runReadRandom(size int, seed int64) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		rnd := workerRand(seed, i)
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+			_, err = store.Get(ctx, workerKey(rnd.Intn(size)))
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func PrintCapacity(cs []Capacity) {
+	fmt.Printf("==== capacity ====\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "backend\tkeys\tp99\tstopped by\t\n")
+	for _, c := range cs {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t\n", c.Backend, c.Keys, c.P99, c.Limit)
+	}
+	w.Flush()
+}
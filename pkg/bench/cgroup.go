@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CgroupLimits are the CPU and memory limits of the cgroup the process runs
+// in. Zero means unlimited or undetected.
+type CgroupLimits struct {
+	CPU    float64 `json:"cpu,omitempty"`    // cores
+	Memory int64   `json:"memory,omitempty"` // bytes
+}
+
+func DetectCgroupLimits() CgroupLimits {
+	var l CgroupLimits
+
+	// cgroup v2
+	if b, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		f := strings.Fields(string(b))
+		if len(f) == 2 && f[0] != "max" {
+			quota, _ := strconv.ParseFloat(f[0], 64)
+			period, _ := strconv.ParseFloat(f[1], 64)
+			if period > 0 {
+				l.CPU = quota / period
+			}
+		}
+	} else {
+		// cgroup v1
+		quota := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+		period := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+		if quota > 0 && period > 0 {
+			l.CPU = float64(quota) / float64(period)
+		}
+	}
+
+	if m := readCgroupInt("/sys/fs/cgroup/memory.max"); m > 0 {
+		l.Memory = m
+	} else if m := readCgroupInt("/sys/fs/cgroup/memory/memory.limit_in_bytes"); m > 0 && m < math.MaxInt64/2 {
+		// v1 reports a huge page-aligned number when unlimited
+		l.Memory = m
+	}
+
+	return l
+}
+
+func readCgroupInt(path string) int64 {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// ScaleWorkers caps each worker count to perCPU workers per available core.
+func ScaleWorkers(workers []int, cpu float64, perCPU int) []int {
+	max := int(math.Ceil(cpu * float64(perCPU)))
+	if max < 1 {
+		max = 1
+	}
+
+	scaled := make([]int, len(workers))
+	for i, n := range workers {
+		if n > max {
+			n = max
+		}
+		scaled[i] = n
+	}
+	return scaled
+}
@@ -0,0 +1,95 @@
+package bench
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// clientSaturation is the share of GOMAXPROCS the benchmark's own CPU use
+// may reach in a phase before its results are flagged as possibly measuring
+// the load generator instead of the backend.
+const clientSaturation = 0.9
+
+// clientReading is a snapshot of the benchmark process's own resource use.
+type clientReading struct {
+	at         time.Time
+	cpu        time.Duration // user and system CPU time so far, 0 if unknown
+	goroutines int
+	heap       uint64 // bytes of live and not yet swept heap objects
+	gcs        uint32
+	gcPause    time.Duration // stop-the-world pause time so far
+}
+
+func readClient() clientReading {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	cpu, _ := processCPU()
+	return clientReading{
+		at:         time.Now(),
+		cpu:        cpu,
+		goroutines: runtime.NumGoroutine(),
+		heap:       ms.HeapAlloc,
+		gcs:        ms.NumGC,
+		gcPause:    time.Duration(ms.PauseTotalNs),
+	}
+}
+
+// cpuSince returns the cores the process kept busy on average since prev.
+func (c clientReading) cpuSince(prev clientReading) float64 {
+	wall := c.at.Sub(prev.at)
+	if wall <= 0 || c.cpu == 0 {
+		return 0
+	}
+	return float64(c.cpu-prev.cpu) / float64(wall)
+}
+
+// ClientStats is the benchmark process's resource use over a phase.
+type ClientStats struct {
+	CPU        float64 // mean cores busy, 0 if the platform doesn't report it
+	MaxCPU     float64 // of any sample interval
+	Procs      int     // GOMAXPROCS
+	Goroutines int     // most seen at a sample
+	MaxHeap    uint64
+	GCs        uint32
+	GCPause    time.Duration
+}
+
+// newClientStats summarizes a phase from its first and last readings and
+// the samples in between.
+func newClientStats(first, last clientReading, samples []Sample) *ClientStats {
+	c := &ClientStats{
+		CPU:     last.cpuSince(first),
+		Procs:   runtime.GOMAXPROCS(0),
+		GCs:     last.gcs - first.gcs,
+		GCPause: last.gcPause - first.gcPause,
+	}
+	for _, x := range samples {
+		c.MaxCPU = max(c.MaxCPU, x.ClientCPU)
+		c.Goroutines = max(c.Goroutines, x.Goroutines)
+		c.MaxHeap = max(c.MaxHeap, x.Heap)
+	}
+	return c
+}
+
+// Saturated reports whether the process used nearly all the CPU it may.
+func (c *ClientStats) Saturated() bool {
+	return c.MaxCPU >= clientSaturation*float64(c.Procs)
+}
+
+func (c *ClientStats) String() string {
+	cpu := "cpu=unknown"
+	if c.CPU > 0 {
+		cpu = fmt.Sprintf("cpu=%.2f (max %.2f) of %d procs", c.CPU, c.MaxCPU, c.Procs)
+	}
+	return fmt.Sprintf("%s goroutines=%d heap=%s gc=%d pause=%s", cpu, c.Goroutines, formatBytes(int64(c.MaxHeap)), c.GCs, c.GCPause)
+}
+
+func printClientStats(backend, phase string, c *ClientStats) {
+	fmt.Printf("client: %s\n", c)
+	if c.Saturated() {
+		slog.Warn("client CPU saturated, the results may measure the load generator rather than the backend",
+			"backend", backend, "phase", phase, "cpu", fmt.Sprintf("%.2f", c.MaxCPU), "procs", c.Procs)
+	}
+}
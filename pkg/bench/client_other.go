@@ -0,0 +1,10 @@
+//go:build !unix
+
+package bench
+
+import "time"
+
+// processCPU isn't available off Unix.
+func processCPU() (time.Duration, bool) {
+	return 0, false
+}
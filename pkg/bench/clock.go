@@ -0,0 +1,161 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstracts time for the runner so phase scheduling, pacing and
+// timeline bucketing can run against virtual time.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the real monotonic clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (systemClock) NewTimer(d time.Duration) Timer  { return systemTimer{time.NewTimer(d)} }
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
+
+// VirtualClock only moves when Advance is called, firing any timers and
+// tickers that come due in deadline order. Like the real ones, their
+// channels hold one pending tick and drop the rest.
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *VirtualClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *VirtualClock) NewTicker(d time.Duration) Ticker {
+	return virtualTicker{c.add(d, d)}
+}
+
+func (c *VirtualClock) add(d, period time.Duration) *virtualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &virtualTimer{
+		clock:  c,
+		ch:     make(chan time.Time, 1),
+		when:   c.now.Add(d),
+		period: period,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			break
+		}
+
+		t := c.timers[0]
+		c.now = t.when
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+	}
+	c.now = end
+}
+
+func (c *VirtualClock) remove(t *virtualTimer) bool {
+	for i, x := range c.timers {
+		if x == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type virtualTimer struct {
+	clock  *VirtualClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.when = t.clock.now.Add(d)
+	t.clock.timers = append(t.clock.timers, t)
+	return active
+}
+
+type virtualTicker struct{ *virtualTimer }
+
+func (t virtualTicker) Stop() { t.virtualTimer.Stop() }
@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// drive advances clock in small steps until done is closed, giving the
+// goroutines waiting on it a moment of real time to react to each step.
+func drive(clock *VirtualClock, step time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		clock.Advance(step)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// TestPhaseVirtualClock runs a paced phase through the latency decorator
+// for a minute of virtual time, which would take a minute of real time if
+// anything on the way used the system clock.
+func TestPhaseVirtualClock(t *testing.T) {
+	store, err := kv.NewMemoryKV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewVirtualClock(time.Unix(0, 0))
+	pr := &PhaseRunner{
+		Duration: time.Minute,
+		Rate:     1,
+		Clock:    clock,
+		Latency:  LatencyConfig{Delay: 500 * time.Millisecond},
+	}
+	ph := Phase{
+		Name: "paced",
+		Run: func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+			for {
+				start, err := p.Wait(ctx)
+				if err != nil {
+					return
+				}
+				err = store.Set(ctx, "k", "v")
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+				s.OK(p.Since(start))
+			}
+		},
+	}
+
+	var r Result
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r, err = pr.Run(context.Background(), store, ph, 1)
+	}()
+	realStart := time.Now()
+	drive(clock, 50*time.Millisecond, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(realStart); d > 30*time.Second {
+		t.Fatalf("phase took %s of real time", d)
+	}
+
+	ok := r.Samples[len(r.Samples)-1].OK
+	if ok < 50 || ok > 60 {
+		t.Errorf("ok = %d, want about 60 at 1 op/s for a minute", ok)
+	}
+	if len(r.Samples) < 59 {
+		t.Errorf("got %d samples, want one per virtual second", len(r.Samples))
+	}
+	if p50 := r.Stats.latency.Quantile(0.5); p50 < 500*time.Millisecond || p50 > 5*time.Second {
+		t.Errorf("p50 = %s, want the injected 500ms and little more", p50)
+	}
+}
+
+type flakySetupKV struct {
+	kv.KV
+	failures int
+}
+
+func (f *flakySetupKV) Setup(ctx context.Context) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+func TestWaitSetupVirtualClock(t *testing.T) {
+	store, err := kv.NewMemoryKV()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		failures int
+		timeout  time.Duration
+		attempts int
+		fail     bool
+	}{
+		{failures: 0, timeout: time.Second, attempts: 1},
+		{failures: 3, timeout: time.Minute, attempts: 4},
+		// backoffs of 100, 200 and 400ms leave no room for a fourth attempt
+		{failures: 5, timeout: time.Second, attempts: 4, fail: true},
+	} {
+		clock := NewVirtualClock(time.Unix(0, 0))
+		var (
+			attempts int
+			err      error
+		)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, attempts, err = waitSetup(context.Background(), clock, &flakySetupKV{KV: store, failures: tc.failures}, tc.timeout)
+		}()
+		drive(clock, 10*time.Millisecond, done)
+		if attempts != tc.attempts || (err != nil) != tc.fail {
+			t.Errorf("failures=%d timeout=%s: attempts=%d err=%v, want attempts=%d fail=%t",
+				tc.failures, tc.timeout, attempts, err, tc.attempts, tc.fail)
+		}
+	}
+}
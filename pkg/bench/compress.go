@@ -0,0 +1,277 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// CompressionConfig enables client-side value compression.
+type CompressionConfig struct {
+	Codec     string `json:"codec"`     // "snappy" or "zstd", empty disables
+	Threshold int    `json:"threshold"` // values shorter than this are stored as is
+}
+
+type codec struct {
+	encode func(dst, src []byte) []byte
+	decode func(dst, src []byte) ([]byte, error)
+}
+
+// zstd's encoder and decoder are safe for concurrent use and costly to set
+// up, so one of each is shared.
+var zstdCodec = sync.OnceValues(func() (codec, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return codec{}, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return codec{}, err
+	}
+	return codec{
+		encode: func(dst, src []byte) []byte { return enc.EncodeAll(src, dst) },
+		decode: func(dst, src []byte) ([]byte, error) { return dec.DecodeAll(src, dst) },
+	}, nil
+})
+
+func newCodec(name string) (codec, error) {
+	switch name {
+	case "snappy":
+		return codec{encode: snappy.Encode, decode: snappy.Decode}, nil
+	case "zstd":
+		return zstdCodec()
+	default:
+		return codec{}, fmt.Errorf("unknown compression codec: %s", name)
+	}
+}
+
+// CompressionStats counts the values a phase encoded and decoded, and the
+// CPU time compression took.
+type CompressionStats struct {
+	Codec          string        `json:"codec"`
+	Values         uint64        `json:"values"`     // values written
+	Compressed     uint64        `json:"compressed"` // of Values, those over the threshold
+	RawBytes       uint64        `json:"raw_bytes"`
+	StoredBytes    uint64        `json:"stored_bytes"`
+	CompressTime   time.Duration `json:"compress_time"`
+	Decompressed   uint64        `json:"decompressed"`
+	DecompressTime time.Duration `json:"decompress_time"`
+}
+
+func (c *CompressionStats) Add(o *CompressionStats) {
+	c.Codec = o.Codec
+	c.Values += o.Values
+	c.Compressed += o.Compressed
+	c.RawBytes += o.RawBytes
+	c.StoredBytes += o.StoredBytes
+	c.CompressTime += o.CompressTime
+	c.Decompressed += o.Decompressed
+	c.DecompressTime += o.DecompressTime
+}
+
+// Stored values start with a header byte saying whether the rest is
+// compressed.
+const (
+	valueRaw        = 0
+	valueCompressed = 1
+)
+
+var errNotEncoded = errors.New("value not written through compression")
+
+// textValued is implemented by backends whose string values must be valid
+// text, which compressed data isn't; compressKV only compresses their
+// binary values.
+type textValued interface {
+	textValues()
+}
+
+// compressKV compresses values of at least threshold bytes before they are
+// written and decompresses them as they are read, so the phase's latency
+// includes the CPU cost. Incr and Scan don't touch values and pass through.
+type compressKV struct {
+	next      kv.KV
+	codec     codec
+	name      string
+	threshold int
+	text      bool // only binary values are compressed
+
+	values, compressed, rawBytes, storedBytes atomic.Uint64
+	decompressed                              atomic.Uint64
+	compressNanos, decompressNanos            atomic.Int64
+}
+
+func NewCompressKV(next kv.KV, cfg CompressionConfig) (*compressKV, error) {
+	c, err := newCodec(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	_, text := next.(textValued)
+	return &compressKV{next: next, codec: c, name: cfg.Codec, threshold: cfg.Threshold, text: text}, nil
+}
+
+// Stats returns what the phase has compressed so far.
+func (c *compressKV) Stats() *CompressionStats {
+	return &CompressionStats{
+		Codec:          c.name,
+		Values:         c.values.Load(),
+		Compressed:     c.compressed.Load(),
+		RawBytes:       c.rawBytes.Load(),
+		StoredBytes:    c.storedBytes.Load(),
+		CompressTime:   time.Duration(c.compressNanos.Load()),
+		Decompressed:   c.decompressed.Load(),
+		DecompressTime: time.Duration(c.decompressNanos.Load()),
+	}
+}
+
+func (c *compressKV) encode(v []byte) []byte {
+	c.values.Add(1)
+	c.rawBytes.Add(uint64(len(v)))
+	var out []byte
+	if len(v) < c.threshold {
+		out = append(append(make([]byte, 0, len(v)+1), valueRaw), v...)
+	} else {
+		start := time.Now()
+		out = c.codec.encode(nil, v)
+		out = append(append(make([]byte, 0, len(out)+1), valueCompressed), out...)
+		c.compressNanos.Add(int64(time.Since(start)))
+		c.compressed.Add(1)
+	}
+	c.storedBytes.Add(uint64(len(out)))
+	return out
+}
+
+// decode reverses encode. An empty value is a missing key and stays empty.
+func (c *compressKV) decode(v []byte) ([]byte, error) {
+	if len(v) == 0 {
+		return v, nil
+	}
+	switch v[0] {
+	case valueRaw:
+		return v[1:], nil
+	case valueCompressed:
+		start := time.Now()
+		out, err := c.codec.decode(nil, v[1:])
+		c.decompressNanos.Add(int64(time.Since(start)))
+		c.decompressed.Add(1)
+		return out, err
+	default:
+		return nil, errNotEncoded
+	}
+}
+
+func (c *compressKV) encodeString(v string) string {
+	if c.text {
+		return v
+	}
+	return string(c.encode([]byte(v)))
+}
+
+func (c *compressKV) decodeString(v string) (string, error) {
+	if c.text {
+		return v, nil
+	}
+	b, err := c.decode([]byte(v))
+	return string(b), err
+}
+
+func (c *compressKV) Name() string {
+	return c.next.Name()
+}
+
+func (c *compressKV) Setup(ctx context.Context) error {
+	return c.next.Setup(ctx)
+}
+
+func (c *compressKV) Close(ctx context.Context) error {
+	return c.next.Close(ctx)
+}
+
+func (c *compressKV) Set(ctx context.Context, key, value string) error {
+	return c.next.Set(ctx, key, c.encodeString(value))
+}
+
+func (c *compressKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := c.next.Get(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return c.decodeString(v)
+}
+
+func (c *compressKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return c.next.SetBytes(ctx, key, c.encode(value))
+}
+
+func (c *compressKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.next.GetBytes(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return c.decode(v)
+}
+
+func (c *compressKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.next.SetTTL(ctx, key, c.encodeString(value), ttl)
+}
+
+func (c *compressKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return c.next.SetNX(ctx, key, c.encodeString(value))
+}
+
+// CompareAndSwap relies on both codecs encoding the same input to the same
+// output, so old matches what was stored.
+func (c *compressKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return c.next.CompareAndSwap(ctx, key, c.encodeString(old), c.encodeString(new))
+}
+
+func (c *compressKV) Incr(ctx context.Context, key string) (int64, error) {
+	return c.next.Incr(ctx, key)
+}
+
+func (c *compressKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return windowCounterOf(c.next).IncrWindow(ctx, key, ttl)
+}
+
+func (c *compressKV) Push(ctx context.Context, queue, value string) error {
+	return queueOf(c.next).Push(ctx, queue, c.encodeString(value))
+}
+
+func (c *compressKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	v, ok, err := queueOf(c.next).Pop(ctx, queue, timeout)
+	if err != nil || !ok {
+		return v, ok, err
+	}
+	v, err = c.decodeString(v)
+	return v, ok, err
+}
+
+func (c *compressKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return c.next.Scan(ctx, prefix, limit)
+}
+
+func (c *compressKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return c.next.TxnSet(ctx, c.encodeMap(kvs))
+}
+
+func (c *compressKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return c.next.BulkLoad(ctx, c.encodeMap(kvs))
+}
+
+func (c *compressKV) encodeMap(kvs map[string]string) map[string]string {
+	if c.text {
+		return kvs
+	}
+	m := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		m[k] = c.encodeString(v)
+	}
+	return m
+}
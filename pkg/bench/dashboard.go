@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Dashboard redraws a few lines of rolling stats in place on a terminal
+// while a phase runs.
+type Dashboard struct {
+	out      io.Writer
+	title    string
+	duration time.Duration
+	lines    int
+	prev     Sample
+}
+
+func NewDashboard(out io.Writer, title string, duration time.Duration) *Dashboard {
+	return &Dashboard{
+		out:      out,
+		title:    title,
+		duration: duration,
+	}
+}
+
+func (d *Dashboard) Update(x Sample) {
+	interval := (x.Elapsed - d.prev.Elapsed).Seconds()
+	ops := float64((x.OK+x.Err)-(d.prev.OK+d.prev.Err)) / interval
+	errs := x.Err - d.prev.Err
+	var errRate float64
+	if n := (x.OK + x.Err) - (d.prev.OK + d.prev.Err); n > 0 {
+		errRate = float64(errs) / float64(n) * 100
+	}
+	d.prev = x
+
+	d.draw(
+		fmt.Sprintf("%s %s", d.title, progressBar(x.Elapsed, d.duration, 30)),
+		fmt.Sprintf("ops/s: %.0f  errors: %d (%.2f%%)  total: %d", ops, errs, errRate, x.OK+x.Err),
+		fmt.Sprintf("p50: %s  p99: %s  max: %s", x.P50, x.P99, x.Max),
+	)
+}
+
+// Clear erases the dashboard so the final report prints in its place.
+func (d *Dashboard) Clear() {
+	d.rewind()
+	for i := 0; i < d.lines; i++ {
+		fmt.Fprint(d.out, "\033[K\n")
+	}
+	d.rewind()
+	d.lines = 0
+}
+
+func (d *Dashboard) rewind() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\033[%dA", d.lines)
+	}
+}
+
+func (d *Dashboard) draw(lines ...string) {
+	d.rewind()
+	for _, l := range lines {
+		fmt.Fprintf(d.out, "\033[K%s\n", l)
+	}
+	d.lines = len(lines)
+}
+
+func progressBar(elapsed, total time.Duration, width int) string {
+	if total <= 0 {
+		return elapsed.Round(time.Second).String()
+	}
+	frac := float64(elapsed) / float64(total)
+	if frac > 1 {
+		frac = 1
+	}
+	n := int(frac * float64(width))
+	return fmt.Sprintf("[%s%s] %s/%s", strings.Repeat("#", n), strings.Repeat(".", width-n), elapsed.Round(time.Second), total)
+}
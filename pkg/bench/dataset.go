@@ -0,0 +1,196 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// A dataset file is datasetMagic followed by one record per key: the key
+// and its value, each prefixed with its length as a uvarint. It holds
+// nothing backend-specific, so a dataset dumped from one backend restores
+// into any other.
+const datasetMagic = "kv-test-perf dataset 1\n"
+
+// datasetBatch is how many keys a restore loads per BulkLoad.
+const datasetBatch = 1000
+
+// DumpDataset writes every string key of store and its value to path,
+// reading them with workers concurrent Gets. Keys written with SetBytes
+// live apart and aren't included, nor are TTLs; keys that expire or are
+// deleted while the dump runs are left out.
+func DumpDataset(ctx context.Context, store kv.KV, path string, workers int) error {
+	start := time.Now()
+	keys, err := store.Scan(ctx, "", math.MaxInt32)
+	if err != nil {
+		return fmt.Errorf("dump %s: %w", store.Name(), err)
+	}
+
+	var (
+		n, size int64
+		mu      sync.Mutex
+	)
+	err = writeFile(path, func(w *bufio.Writer) error {
+		w.WriteString(datasetMagic)
+		return forEachConcurrently(ctx, keys, workers, func(key string) error {
+			v, err := store.Get(ctx, key)
+			if missingKey(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			n++
+			size += int64(len(key) + len(v))
+			return writeRecord(w, key, v)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("dump %s: %w", store.Name(), err)
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("dataset: dumped %d keys (%s) from %s to %s in %s (%.0f keys/s)\n",
+		n, formatBytes(size), store.Name(), path, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+	return nil
+}
+
+// forEachConcurrently calls f for each item on workers goroutines, and
+// returns the first error.
+func forEachConcurrently(ctx context.Context, items []string, workers int, f func(string) error) error {
+	ch := make(chan string)
+	errs := make([]error, max(workers, 1))
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for item := range ch {
+				if errs[i] == nil {
+					errs[i] = f(item)
+				}
+			}
+		}(i)
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		ch <- item
+	}
+	close(ch)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func writeRecord(w *bufio.Writer, key, value string) error {
+	var b [binary.MaxVarintLen64]byte
+	for _, s := range []string{key, value} {
+		w.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+		_, err := w.WriteString(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreDataset loads the dataset at path into store with BulkLoad, which
+// is how the backend loads data fastest, running workers batches at once.
+// store is expected to be freshly set up: BulkLoad may fail on keys that
+// exist already.
+func RestoreDataset(ctx context.Context, store kv.KV, path string, workers int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(datasetMagic))
+	_, err = io.ReadFull(r, magic)
+	if err != nil || string(magic) != datasetMagic {
+		return fmt.Errorf("%s: not a dataset file", path)
+	}
+
+	start := time.Now()
+	batches := make(chan map[string]string, workers)
+	errs := make([]error, max(workers, 1))
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for kvs := range batches {
+				if errs[i] == nil {
+					errs[i] = store.BulkLoad(ctx, kvs)
+				}
+			}
+		}(i)
+	}
+
+	var n, size int64
+	var readErr error
+	kvs := make(map[string]string, datasetBatch)
+	for ctx.Err() == nil {
+		key, err := readString(r)
+		if err == io.EOF {
+			break
+		}
+		var value string
+		if err == nil {
+			value, err = readString(r)
+		}
+		if err != nil {
+			readErr = fmt.Errorf("%s: record %d: %w", path, n+1, err)
+			break
+		}
+		n++
+		size += int64(len(key) + len(value))
+		kvs[key] = value
+		if len(kvs) == datasetBatch {
+			batches <- kvs
+			kvs = make(map[string]string, datasetBatch)
+		}
+	}
+	if len(kvs) > 0 && readErr == nil && ctx.Err() == nil {
+		batches <- kvs
+	}
+	close(batches)
+	wg.Wait()
+	if err := errors.Join(append(errs, readErr)...); err != nil {
+		return fmt.Errorf("restore %s: %w", store.Name(), err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("dataset: restored %d keys (%s) into %s in %s (%.0f keys/s)\n",
+		n, formatBytes(size), store.Name(), elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+	return nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(b), nil
+}
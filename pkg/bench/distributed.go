@@ -0,0 +1,508 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// The coordinator and agents talk gRPC with a JSON codec and a hand-written
+// service description, which keeps the wire types as plain Go structs
+// without a protoc step.
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type AgentSetupRequest struct {
+	Backend      string           `json:"backend"`
+	Config       kv.BackendConfig `json:"config"`
+	ReadyTimeout time.Duration    `json:"ready_timeout"`
+}
+
+type AgentSetupResponse struct {
+	Name       string         `json:"name"`
+	SetupTime  time.Duration  `json:"setup_time"`
+	Attempts   int            `json:"attempts"`
+	Durability string         `json:"durability,omitempty"`
+	Server     *kv.ServerInfo `json:"server,omitempty"`
+}
+
+type AgentPhaseRequest struct {
+	Backend  string           `json:"backend"`
+	Config   kv.BackendConfig `json:"config"`
+	Phases   PhaseOptions     `json:"phases"`
+	Phase    string           `json:"phase"`
+	Workers  int              `json:"workers"`
+	Duration time.Duration    `json:"duration"`
+	Rate     float64          `json:"rate"`
+	Retry    RetryPolicy      `json:"retry"`
+	Timeout  time.Duration    `json:"op_timeout,omitempty"`
+	Latency  LatencyConfig    `json:"latency"`
+	Fault    FaultConfig      `json:"fault"`
+
+	Compression CompressionConfig `json:"compression"`
+	Histograms  bool              `json:"histograms,omitempty"`
+}
+
+type AgentPhaseResponse struct {
+	Samples   []Sample      `json:"samples"`
+	Latency   HistogramData `json:"latency"`
+	Anomalies uint64        `json:"anomalies"`
+	Errors    ErrorCounts   `json:"errors"`
+	Retried   uint64        `json:"retried"`
+	Retries   uint64        `json:"retries"`
+	Timeouts  uint64        `json:"timeouts,omitempty"`
+	Issued    uint64        `json:"issued"`
+
+	Compression *CompressionStats `json:"compression,omitempty"`
+	Faults      *FaultStats       `json:"faults,omitempty"`
+	Lookups     *LookupData       `json:"lookups,omitempty"`
+	Queue       *QueueData        `json:"queue,omitempty"`
+	Timings     *kv.Timings       `json:"timings,omitempty"`
+	Shards      []kv.ShardStats   `json:"shards,omitempty"`
+	MaxAt       time.Duration     `json:"max_at"`
+}
+
+type agentService interface {
+	Setup(ctx context.Context, req *AgentSetupRequest) (*AgentSetupResponse, error)
+	RunPhase(ctx context.Context, req *AgentPhaseRequest) (*AgentPhaseResponse, error)
+}
+
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kvperf.Agent",
+	HandlerType: (*agentService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Setup",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(AgentSetupRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(agentService).Setup(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/kvperf.Agent/Setup"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(agentService).Setup(ctx, req.(*AgentSetupRequest))
+				})
+			},
+		},
+		{
+			MethodName: "RunPhase",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(AgentPhaseRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(agentService).RunPhase(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/kvperf.Agent/RunPhase"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(agentService).RunPhase(ctx, req.(*AgentPhaseRequest))
+				})
+			},
+		},
+	},
+}
+
+// agent runs phases on behalf of a coordinator, keeping the backend's
+// client open so connection pools are reused across phases. A coordinator
+// runs its backends one after another, so a request for another backend
+// closes the clients of the ones before it.
+type agent struct {
+	dataDir string // where requests may name files and directories, "" for nowhere
+
+	mu  sync.Mutex
+	kvs map[string]kv.KV
+}
+
+func (a *agent) kv(name string, cfg kv.BackendConfig) (kv.KV, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%+v", name, cfg)
+	if store, ok := a.kvs[key]; ok {
+		return store, nil
+	}
+	a.closeLocked()
+	store, err := kv.New(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.kvs[key] = store
+	return store, nil
+}
+
+// close closes every backend the agent has open.
+func (a *agent) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closeLocked()
+}
+
+func (a *agent) closeLocked() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for key, store := range a.kvs {
+		err := store.Close(ctx)
+		if err != nil {
+			slog.Warn("close backend", "backend", store.Name(), "err", err)
+		}
+		delete(a.kvs, key)
+	}
+}
+
+func (a *agent) Setup(ctx context.Context, req *AgentSetupRequest) (*AgentSetupResponse, error) {
+	err := restrictPaths(&req.Config, nil, a.dataDir)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	store, err := a.kv(req.Backend, req.Config)
+	if err != nil {
+		return nil, err
+	}
+	setupTime, attempts, err := WaitSetup(ctx, store, req.ReadyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentSetupResponse{
+		Name:       store.Name(),
+		SetupTime:  setupTime,
+		Attempts:   attempts,
+		Durability: kv.DurabilitySettings(ctx, store),
+		Server:     kv.DescribeServer(ctx, store),
+	}, nil
+}
+
+func (a *agent) RunPhase(ctx context.Context, req *AgentPhaseRequest) (*AgentPhaseResponse, error) {
+	err := restrictPaths(&req.Config, &req.Phases, a.dataDir)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	store, err := a.kv(req.Backend, req.Config)
+	if err != nil {
+		return nil, err
+	}
+	phases, err := req.Phases.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ph := range phases {
+		if ph.Name != req.Phase {
+			continue
+		}
+		runner := &PhaseRunner{Duration: req.Duration, Rate: req.Rate, Retry: req.Retry, OpTimeout: req.Timeout, Latency: req.Latency, Fault: req.Fault, Compression: req.Compression, Histograms: req.Histograms}
+		r, err := runner.Run(ctx, store, ph, req.Workers)
+		if errors.Is(err, ErrPhaseUnsupported) {
+			return nil, status.Error(codes.Unimplemented, err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp := &AgentPhaseResponse{
+			Samples:   r.Samples,
+			Latency:   r.Stats.latency.Export(),
+			Anomalies: r.Stats.Anomalies(),
+			Errors:    r.Stats.Errors(),
+			Retried:   r.Stats.retried,
+			Retries:   r.Stats.retries,
+			Timeouts:  r.Stats.timeouts,
+			Issued:    r.Stats.Issued(),
+
+			Compression: r.Stats.Compression(),
+			Faults:      r.Stats.Faults(),
+			Timings:     r.Timings,
+			Shards:      r.Shards,
+			MaxAt:       r.Stats.MaxAt(),
+		}
+		if l := r.Stats.Lookups(); l != nil {
+			resp.Lookups = l.Export()
+		}
+		if q := r.Stats.Queue(); q != nil {
+			resp.Queue = q.Export()
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unknown phase: %s", req.Phase)
+}
+
+// RunAgent implements the "agent" subcommand. The agent serves plain gRPC
+// without authentication and runs whatever a coordinator asks, so -listen
+// belongs on a private network the coordinator shares with it.
+func RunAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := fs.String("listen", ":9000", "address to accept coordinator connections on; unauthenticated, so only on a private network")
+	dataDir := fs.String("data-dir", "", "directory coordinators may name files and directories under (pebble dir, replay trace, TLS files, fdb cluster file); none may be named without it")
+	plugins := fs.String("plugins", "", "comma-separated Go plugins to load, which add backends with kv.Register")
+	fs.Parse(args)
+
+	if *dataDir != "" {
+		dir, err := filepath.Abs(*dataDir)
+		if err != nil {
+			return err
+		}
+		*dataDir = dir
+	}
+
+	if *plugins != "" {
+		err := kv.LoadPlugins(strings.Split(*plugins, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer()
+	a := &agent{dataDir: *dataDir, kvs: make(map[string]kv.KV)}
+	srv.RegisterService(&agentServiceDesc, a)
+	defer a.close()
+
+	// on a signal, finish the phases in flight and close the backends
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	slog.Info("agent listening", "addr", lis.Addr().String())
+	return srv.Serve(lis)
+}
+
+// Coordinator fans phases out to agents and merges their results, so load
+// can come from more client machines than one.
+type Coordinator struct {
+	addrs []string
+	conns []*grpc.ClientConn
+}
+
+func DialAgents(addrs []string) (*Coordinator, error) {
+	c := &Coordinator{addrs: addrs}
+	for _, addr := range addrs {
+		conn, err := grpc.Dial(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+		)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.conns = append(c.conns, conn)
+	}
+	return c, nil
+}
+
+func (c *Coordinator) Close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// Setup prepares the backend through the first agent only, since setup
+// resets shared state.
+func (c *Coordinator) Setup(ctx context.Context, req *AgentSetupRequest) (*AgentSetupResponse, error) {
+	resp := new(AgentSetupResponse)
+	err := c.conns[0].Invoke(ctx, "/kvperf.Agent/Setup", req, resp)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s: %w", c.addrs[0], err)
+	}
+	return resp, nil
+}
+
+// RunPhase runs the phase on every agent at once with req.Workers workers
+// each and merges the results into one.
+func (c *Coordinator) RunPhase(ctx context.Context, name string, req *AgentPhaseRequest) (Result, error) {
+	fmt.Printf("==== %s (%d agents) ====\n", req.Phase, len(c.conns))
+	resps := make([]*AgentPhaseResponse, len(c.conns))
+	errs := make([]error, len(c.conns))
+	var wg sync.WaitGroup
+	for i, conn := range c.conns {
+		i, conn := i, conn
+		wg.Add(1)
+		// agents always send their interval histograms, which the merge
+		// needs for the intervals' quantiles. Each gets a seed of its own, so
+		// seeded runs don't have every agent writing the same keys.
+		agentReq := *req
+		agentReq.Histograms = true
+		if agentReq.Phases.Seed != 0 {
+			agentReq.Phases.Seed += int64(i) << 32
+		}
+		go func() {
+			defer wg.Done()
+			resps[i] = new(AgentPhaseResponse)
+			errs[i] = conn.Invoke(ctx, "/kvperf.Agent/RunPhase", &agentReq, resps[i])
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if status.Code(err) == codes.Unimplemented {
+			return Result{}, ErrPhaseUnsupported
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("agent %s: %w", c.addrs[i], err)
+		}
+	}
+
+	return mergeAgentResults(name, req, resps), nil
+}
+
+// mergeAgentResults sums the agents' timelines interval by interval (they are
+// aligned to each agent's phase start) and merges their histograms.
+func mergeAgentResults(name string, req *AgentPhaseRequest, resps []*AgentPhaseResponse) Result {
+	s := &Stats{}
+	var worst time.Duration
+	var timings *kv.Timings
+	var shards []kv.ShardStats
+	for _, resp := range resps {
+		s.latency.Import(resp.Latency)
+		s.anomalies += resp.Anomalies
+		s.retried += resp.Retried
+		s.timeouts += resp.Timeouts
+		s.retries += resp.Retries
+		s.issued += resp.Issued
+		if resp.Compression != nil {
+			if s.compression == nil {
+				s.compression = &CompressionStats{}
+			}
+			s.compression.Add(resp.Compression)
+		}
+		if resp.Faults != nil {
+			if s.faults == nil {
+				s.faults = &FaultStats{}
+			}
+			s.faults.Add(resp.Faults)
+		}
+		if resp.Lookups != nil {
+			if s.lookups == nil {
+				s.lookups = &LookupStats{}
+			}
+			s.lookups.Import(resp.Lookups)
+		}
+		if resp.Queue != nil {
+			if s.queue == nil {
+				s.queue = &QueueStats{}
+			}
+			s.queue.Import(resp.Queue)
+		}
+		if resp.Timings != nil {
+			if timings == nil {
+				timings = &kv.Timings{}
+			}
+			*timings = timings.Add(*resp.Timings)
+		}
+		if shards == nil && resp.Shards != nil {
+			shards = make([]kv.ShardStats, len(resp.Shards))
+		}
+		for i, sh := range resp.Shards {
+			// agents share the servers, so they all read the same keys
+			shards[i].Addr, shards[i].Keys = sh.Addr, sh.Keys
+			shards[i].Ops += sh.Ops
+			shards[i].Errors += sh.Errors
+		}
+		for c, n := range resp.Errors {
+			s.errors[c] += n
+		}
+		if d := time.Duration(resp.Latency.Max); d > worst {
+			worst, s.maxAt = d, resp.MaxAt
+		}
+	}
+
+	n := 0
+	for _, resp := range resps {
+		n = max(n, len(resp.Samples))
+	}
+	samples := make([]Sample, n)
+	for i := range samples {
+		m := &samples[i]
+		var h Histogram
+		histograms := false
+		for _, resp := range resps {
+			if len(resp.Samples) == 0 {
+				continue
+			}
+			// the last tick races the end of the phase, so an agent may
+			// have a sample fewer than the others; its counts are
+			// cumulative, so its last sample stands in for the missing one
+			x := resp.Samples[min(i, len(resp.Samples)-1)]
+			m.OK += x.OK
+			m.Err += x.Err
+			if i >= len(resp.Samples) {
+				continue
+			}
+
+			m.At = x.At
+			m.Elapsed = maxDuration(m.Elapsed, x.Elapsed)
+			m.P50 = maxDuration(m.P50, x.P50)
+			m.P99 = maxDuration(m.P99, x.P99)
+			m.Max = maxDuration(m.Max, x.Max)
+			if x.Latency != nil {
+				h.Import(*x.Latency)
+				histograms = true
+			}
+			for _, e := range x.Events {
+				// agents sharing a server see the same events
+				if !containsString(m.Events, e) {
+					m.Events = append(m.Events, e)
+				}
+			}
+		}
+		// quantiles of the agents' merged operations rather than the worst
+		// agent's
+		if histograms {
+			m.P50, m.P99, m.Max = h.Quantile(0.5), h.Quantile(0.99), h.Max()
+			if req.Histograms {
+				d := h.Export()
+				m.Latency = &d
+			}
+		}
+	}
+
+	return Result{
+		Backend: name,
+		Phase:   req.Phase,
+		Workers: req.Workers * len(resps),
+		Samples: samples,
+		Stats:   s,
+		Timings: timings,
+		Shards:  shards,
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
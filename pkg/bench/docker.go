@@ -0,0 +1,260 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// ContainerConfig sets up the Docker containers `kv-test-perf up` runs the
+// backends in.
+type ContainerConfig struct {
+	Versions map[string]string // image tag per backend, overriding the default
+	CPUs     string            // docker run --cpus, empty for no limit
+	Memory   string            // docker run --memory, empty for no limit
+	Keep     bool              // leave the containers running after the run
+}
+
+// containerRecipe runs a backend's server: the image, the port it serves on
+// and how the backend's address is set once Docker has published the port.
+type containerRecipe struct {
+	image string
+	tag   string // default version
+	port  int
+	env   []string
+	flags []string // extra docker run flags
+	args  []string
+	apply func(cfg *kv.BackendConfig, addr string)
+}
+
+var containerRecipes = map[string]containerRecipe{
+	"postgres": {
+		image: "postgres", tag: "16", port: 5432,
+		env: []string{"POSTGRES_PASSWORD=postgres"},
+		apply: func(cfg *kv.BackendConfig, addr string) {
+			cfg.PostgresURL = "postgres://postgres:postgres@" + addr + "/postgres?sslmode=disable"
+		},
+	},
+	"cockroachdb": {
+		image: "cockroachdb/cockroach", tag: "v23.2.4", port: 26257,
+		args: []string{"start-single-node", "--insecure"},
+		apply: func(cfg *kv.BackendConfig, addr string) {
+			cfg.CockroachURL = "postgres://root@" + addr + "/defaultdb?sslmode=disable"
+		},
+	},
+	"yugabytedb": {
+		image: "yugabytedb/yugabyte", tag: "2.20.3.0-b68", port: 5433,
+		args: []string{"bin/yugabyted", "start", "--background=false"},
+		apply: func(cfg *kv.BackendConfig, addr string) {
+			cfg.YugabyteURL = "postgres://yugabyte@" + addr + "/yugabyte?sslmode=disable"
+		},
+	},
+	"redis": {
+		image: "redis", tag: "7.2", port: 6379,
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.RedisAddr = addr },
+	},
+	"dragonfly": {
+		image: "docker.dragonflydb.io/dragonflydb/dragonfly", tag: "v1.17.1", port: 6379,
+		flags: []string{"--ulimit", "memlock=-1"},
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.DragonflyAddr = addr },
+	},
+	"keydb": {
+		image: "eqalpha/keydb", tag: "x86_64_v6.3.4", port: 6379,
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.KeyDBAddr = addr },
+	},
+	"valkey": {
+		image: "valkey/valkey", tag: "7.2", port: 6379,
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.ValkeyAddr = addr },
+	},
+	"nats": {
+		image: "nats", tag: "2.10", port: 4222,
+		args:  []string{"-js"},
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.NATSURL = "nats://" + addr },
+	},
+	"consul": {
+		image: "hashicorp/consul", tag: "1.18", port: 8500,
+		args:  []string{"agent", "-dev", "-client", "0.0.0.0"},
+		apply: func(cfg *kv.BackendConfig, addr string) { cfg.ConsulAddr = addr },
+	},
+}
+
+// containerBackends maps a backend to the servers it runs against, so
+// variants such as redis-pipeline share one container.
+func containerBackends(name string) []string {
+	switch name {
+	case "postgresql":
+		return []string{"postgres"}
+	case "redis-pipeline", "redis-hash":
+		return []string{"redis"}
+	case "tiered":
+		return []string{"postgres", "redis"}
+	}
+	return []string{name}
+}
+
+// selfHosted reports whether a backend has no server for up to start: it
+// runs in the process, or against a service the user provides.
+func selfHosted(name string) bool {
+	switch name {
+	case "memory", "pebble", "remote":
+		return true
+	}
+	return slices.Contains(kv.Registered(), name)
+}
+
+type container struct {
+	backend string
+	id      string
+	image   string // name:tag as run
+	imageID string // the exact image, whatever the tag points at later
+	addr    string
+}
+
+// Environment is the containers started for a run, one per server.
+type Environment struct {
+	containers []container
+	keep       bool
+}
+
+// StartEnvironment runs a container for each server backends need and points
+// cfg at it. Readiness is left to setup's retries (-ready-timeout), since a
+// published port accepts connections before the server inside listens.
+func StartEnvironment(ctx context.Context, backends []string, cc ContainerConfig, cfg *kv.BackendConfig) (*Environment, error) {
+	for name := range cc.Versions {
+		for _, server := range containerBackends(name) {
+			if _, ok := containerRecipes[server]; !ok {
+				return nil, fmt.Errorf("no container for backend: %s", name)
+			}
+		}
+	}
+
+	env := &Environment{keep: cc.Keep}
+	started := make(map[string]bool)
+	for _, name := range backends {
+		servers := containerBackends(name)
+		for _, server := range servers {
+			if selfHosted(server) || started[server] {
+				continue
+			}
+			r, ok := containerRecipes[server]
+			if !ok {
+				env.Close()
+				return nil, fmt.Errorf("no container for backend %s; start it yourself and drop `up`", name)
+			}
+			tag := r.tag
+			if v := cc.Versions[server]; v != "" {
+				tag = v
+			} else if v := cc.Versions[name]; v != "" && len(servers) == 1 {
+				tag = v
+			}
+
+			c, err := runContainer(ctx, server, r, r.image+":"+tag, cc)
+			if err != nil {
+				env.Close()
+				return nil, err
+			}
+			env.containers = append(env.containers, c)
+			started[server] = true
+			r.apply(cfg, c.addr)
+		}
+	}
+	return env, nil
+}
+
+func runContainer(ctx context.Context, server string, r containerRecipe, image string, cc ContainerConfig) (container, error) {
+	args := []string{"run", "-d", "--label", "kv-test-perf", "-p", fmt.Sprintf("127.0.0.1::%d", r.port)}
+	if cc.CPUs != "" {
+		args = append(args, "--cpus", cc.CPUs)
+	}
+	if cc.Memory != "" {
+		args = append(args, "--memory", cc.Memory)
+	}
+	for _, e := range r.env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, r.flags...)
+	args = append(args, image)
+	args = append(args, r.args...)
+
+	id, err := docker(ctx, args...)
+	if err != nil {
+		return container{}, fmt.Errorf("start %s: %w", image, err)
+	}
+	c := container{backend: server, id: id, image: image}
+
+	// `docker port` lists an address per published IP family; the first is
+	// the 127.0.0.1 one asked for
+	ports, err := docker(ctx, "port", id, fmt.Sprintf("%d/tcp", r.port))
+	if err == nil {
+		c.addr, _, _ = strings.Cut(ports, "\n")
+		c.imageID, err = docker(ctx, "inspect", "--format", "{{.Image}}", id)
+	}
+	if err != nil {
+		docker(context.Background(), "rm", "-f", id)
+		return container{}, fmt.Errorf("inspect %s: %w", image, err)
+	}
+	return c, nil
+}
+
+// docker runs the docker CLI and returns its trimmed output.
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("docker %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("docker %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Print lists the containers with their exact images, so a run records the
+// server versions it measured.
+func (e *Environment) Print() {
+	for _, c := range e.containers {
+		id := strings.TrimPrefix(c.imageID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Printf("container: %s image=%s id=%s addr=%s\n", c.backend, c.image, id, c.addr)
+	}
+}
+
+// Close removes the containers, unless they are kept, in which case it
+// prints how to remove them later. It is safe to call more than once.
+func (e *Environment) Close() error {
+	if e == nil || len(e.containers) == 0 {
+		return nil
+	}
+	if e.keep {
+		ids := make([]string, len(e.containers))
+		for i, c := range e.containers {
+			ids[i] = c.id[:min(len(c.id), 12)]
+		}
+		fmt.Printf("containers kept running; remove them with: docker rm -f %s\n", strings.Join(ids, " "))
+		e.containers = nil
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var errs []error
+	for _, c := range e.containers {
+		_, err := docker(ctx, "rm", "-f", "-v", c.id)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	e.containers = nil
+	return errors.Join(errs...)
+}
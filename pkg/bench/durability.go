@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// DurabilityMode is one crash-safety configuration of a backend, such as
+// Redis with appendfsync always or Postgres with a logged table.
+type DurabilityMode struct {
+	Name   string
+	config func(cfg kv.BackendConfig) kv.BackendConfig
+}
+
+func (m DurabilityMode) Config(cfg kv.BackendConfig) kv.BackendConfig {
+	if m.config == nil {
+		return cfg
+	}
+	return m.config(cfg)
+}
+
+// Label names the backend in mode m, e.g. "redis+aof-always".
+func (m DurabilityMode) Label(backend string) string {
+	if m.Name == "" {
+		return backend
+	}
+	return backend + "+" + m.Name
+}
+
+// durabilityModes returns the configurations the durability matrix runs a
+// backend in, from the least to the most durable, or just the configured one
+// for backends without a choice.
+func durabilityModes(backend string) []DurabilityMode {
+	switch backend {
+	case "postgres", "postgresql", "tiered":
+		pg := func(name, table, syncCommit string) DurabilityMode {
+			return DurabilityMode{name, func(cfg kv.BackendConfig) kv.BackendConfig {
+				cfg.PostgresTable, cfg.PostgresSynchronousCommit = table, syncCommit
+				return cfg
+			}}
+		}
+		return []DurabilityMode{
+			pg("unlogged", "unlogged", "on"),
+			pg("logged-async", "logged", "off"),
+			pg("logged", "logged", "on"),
+		}
+	case "redis", "redis-pipeline", "redis-hash", "keydb", "valkey":
+		aof := func(mode string) DurabilityMode {
+			return DurabilityMode{"aof-" + mode, func(cfg kv.BackendConfig) kv.BackendConfig {
+				cfg.RedisAOF = mode
+				return cfg
+			}}
+		}
+		return []DurabilityMode{aof("off"), aof("everysec"), aof("always")}
+	case "nats":
+		storage := func(name string) DurabilityMode {
+			return DurabilityMode{name, func(cfg kv.BackendConfig) kv.BackendConfig {
+				cfg.NATSStorage = name
+				return cfg
+			}}
+		}
+		return []DurabilityMode{storage("memory"), storage("file")}
+	case "pebble":
+		sync := func(name string, sync bool) DurabilityMode {
+			return DurabilityMode{name, func(cfg kv.BackendConfig) kv.BackendConfig {
+				cfg.PebbleSync = sync
+				return cfg
+			}}
+		}
+		return []DurabilityMode{sync("nosync", false), sync("sync", true)}
+	default:
+		return []DurabilityMode{{}}
+	}
+}
+
+// PrintDurabilityMatrix compares every mode of a backend with its least
+// durable one, at the same workers and phase.
+func PrintDurabilityMatrix(results []Result) {
+	type key struct {
+		backend string // label without the mode
+		workers int
+		phase   string
+	}
+	keyOf := func(r *Result) (key, bool) {
+		name, rest, ok := strings.Cut(r.Backend, "+")
+		if !ok {
+			return key{}, false
+		}
+		if _, topology, ok := strings.Cut(rest, "@"); ok {
+			name += "@" + topology
+		}
+		return key{name, r.Workers, r.Label()}, true
+	}
+
+	// the modes run least durable first, so the first result of each key
+	// is the reference
+	first := make(map[key]*Result)
+	for i := range results {
+		r := &results[i]
+		if k, ok := keyOf(r); ok && first[k] == nil {
+			first[k] = r
+		}
+	}
+	if len(first) == 0 {
+		return
+	}
+
+	fmt.Printf("==== durability vs throughput ====\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "workers\tphase\tbackend\tsettings\tops\tΔops\tp99\tΔp99\t\n")
+	for i := range results {
+		r := &results[i]
+		k, ok := keyOf(r)
+		if !ok {
+			continue
+		}
+		ref := first[k]
+		p99, rp99 := r.Stats.latency.Quantile(0.99), ref.Stats.latency.Quantile(0.99)
+		settings := r.Durability
+		if settings == "" {
+			settings = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.0f\t%+.1f%%\t%s\t%s\t\n",
+			r.Workers,
+			r.Label(),
+			r.Backend,
+			settings,
+			r.Ops(),
+			percentDelta(ref.Ops(), r.Ops()),
+			p99,
+			signedDuration(p99-rp99),
+		)
+	}
+	w.Flush()
+}
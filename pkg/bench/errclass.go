@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrorClass is the category an operation error is counted under.
+type ErrorClass int
+
+const (
+	ErrTimeout       ErrorClass = iota // the operation or connection timed out
+	ErrConnection                      // refused, reset or closed connection
+	ErrSerialization                   // transaction conflict the client should retry
+	ErrDeadlock
+	ErrPool     // the client or server ran out of connections
+	ErrMismatch // the backend answered with a wrong value
+	ErrOther
+	numErrorClasses
+)
+
+var errorClassNames = [numErrorClasses]string{"timeout", "connection", "serialization", "deadlock", "pool", "mismatch", "other"}
+
+func (c ErrorClass) String() string {
+	return errorClassNames[c]
+}
+
+// ErrorCounts holds an error count per class.
+type ErrorCounts [numErrorClasses]uint64
+
+// String lists the non-zero classes, e.g. "timeout=3 connection=1".
+func (c ErrorCounts) String() string {
+	var parts []string
+	for i, n := range c {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", ErrorClass(i), n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Map returns the non-zero counts by class name.
+func (c ErrorCounts) Map() map[string]uint64 {
+	m := make(map[string]uint64)
+	for i, n := range c {
+		if n > 0 {
+			m[ErrorClass(i).String()] = n
+		}
+	}
+	return m
+}
+
+// mismatchError reports a value that doesn't match what the workload wrote.
+type mismatchError struct {
+	msg string
+}
+
+func (e *mismatchError) Error() string {
+	return e.msg
+}
+
+func mismatchf(format string, args ...any) error {
+	return &mismatchError{msg: fmt.Sprintf(format, args...)}
+}
+
+func classifyError(err error) ErrorClass {
+	var mismatch *mismatchError
+	if errors.As(err, &mismatch) {
+		return ErrMismatch
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001":
+			return ErrSerialization
+		case "40P01":
+			return ErrDeadlock
+		case "53300", "53400": // too_many_connections, configuration_limit_exceeded
+			return ErrPool
+		case "57014": // query_canceled, e.g. statement_timeout
+			return ErrTimeout
+		}
+		if pqErr.Code.Class() == "08" { // connection_exception
+			return ErrConnection
+		}
+		return ErrOther
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	if errors.Is(err, errOpTimeout) {
+		return ErrTimeout
+	}
+	if errors.Is(err, errFault) {
+		return ErrConnection
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, redis.ErrClosed) {
+		return ErrConnection
+	}
+	// go-redis doesn't export its pool timeout error
+	if s := err.Error(); strings.Contains(s, "connection pool timeout") || strings.Contains(s, "max number of clients") {
+		return ErrPool
+	}
+	return ErrOther
+}
@@ -0,0 +1,269 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// FaultConfig injects failures into a phase's operations, to see how the
+// workload, the retry policy and the client's timeouts cope with them.
+type FaultConfig struct {
+	ErrorRate float64       `json:"error_rate"` // fraction of operations failed without reaching the backend
+	StallRate float64       `json:"stall_rate"` // fraction of operations held up before they run
+	Stall     time.Duration `json:"stall"`      // how long a stalled operation is held up
+
+	// OutageEvery starts a total outage this often into the phase, during
+	// which every operation fails for Outage. 0 disables outages.
+	OutageEvery time.Duration `json:"outage_every"`
+	Outage      time.Duration `json:"outage"`
+}
+
+func (c FaultConfig) Enabled() bool {
+	return c.ErrorRate > 0 || (c.StallRate > 0 && c.Stall > 0) || (c.OutageEvery > 0 && c.Outage > 0)
+}
+
+// errFault fails injected operations; it counts as a connection error, so
+// the retry policy retries it.
+var (
+	errFault       = errors.New("injected fault")
+	errFaultOutage = fmt.Errorf("%w: outage", errFault)
+)
+
+// FaultStats counts what was injected into a phase and how long the
+// workload took to get an operation through after each outage ended.
+type FaultStats struct {
+	Failed  uint64 `json:"failed"` // operations failed, outages included
+	Stalled uint64 `json:"stalled"`
+	Outages uint64 `json:"outages"` // outages started during the phase
+
+	Recovered    uint64        `json:"recovered"`     // outages followed by a successful operation
+	RecoveryTime time.Duration `json:"recovery_time"` // summed over Recovered
+	MaxRecovery  time.Duration `json:"max_recovery"`
+}
+
+func (f *FaultStats) Add(o *FaultStats) {
+	f.Failed += o.Failed
+	f.Stalled += o.Stalled
+	f.Outages += o.Outages
+	f.Recovered += o.Recovered
+	f.RecoveryTime += o.RecoveryTime
+	f.MaxRecovery = max(f.MaxRecovery, o.MaxRecovery)
+}
+
+func (f *FaultStats) String() string {
+	parts := []string{fmt.Sprintf("failed=%d stalled=%d", f.Failed, f.Stalled)}
+	if f.Outages > 0 {
+		parts = append(parts, fmt.Sprintf("outages=%d recovered=%d", f.Outages, f.Recovered))
+	}
+	if f.Recovered > 0 {
+		parts = append(parts, fmt.Sprintf("recovery: mean=%s max=%s", f.RecoveryTime/time.Duration(f.Recovered), f.MaxRecovery))
+	}
+	return strings.Join(parts, " ")
+}
+
+// faultKV fails or stalls operations before passing them to next, and
+// fails all of them during outages. The faults are injected above the
+// client, so its connections stay up: recovery measures how soon the
+// workload and the retry policy get going again, not the driver's
+// reconnects.
+type faultKV struct {
+	next  kv.KV
+	cfg   FaultConfig
+	clock Clock
+	start time.Time
+
+	failed  atomic.Uint64
+	stalled atomic.Uint64
+
+	mu        sync.Mutex
+	recovered int // the last outage an operation succeeded after
+	stats     FaultStats
+}
+
+func NewFaultKV(next kv.KV, cfg FaultConfig, clock Clock) *faultKV {
+	return &faultKV{next: next, cfg: cfg, clock: clock, start: clock.Now()}
+}
+
+// outage returns the number of the outage in progress at offset t from the
+// start, counting from 1, or 0 if there is none, and the number of the last
+// one that ended.
+func (f *faultKV) outage(t time.Duration) (cur, ended int) {
+	if f.cfg.OutageEvery <= 0 || f.cfg.Outage <= 0 {
+		return 0, 0
+	}
+	n := int(t / f.cfg.OutageEvery)
+	if n > 0 && t-time.Duration(n)*f.cfg.OutageEvery < f.cfg.Outage {
+		return n, n - 1
+	}
+	return 0, n
+}
+
+func (f *faultKV) Stats() *FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.stats
+	s.Failed = f.failed.Load()
+	s.Stalled = f.stalled.Load()
+	if f.cfg.OutageEvery > 0 && f.cfg.Outage > 0 {
+		s.Outages = uint64(f.clock.Since(f.start) / f.cfg.OutageEvery)
+	}
+	return &s
+}
+
+// noteRecovery records the first success after the last outage that ended.
+func (f *faultKV) noteRecovery(t time.Duration, ended int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ended <= f.recovered {
+		return
+	}
+	f.recovered = ended
+	d := t - (time.Duration(ended)*f.cfg.OutageEvery + f.cfg.Outage)
+	f.stats.Recovered++
+	f.stats.RecoveryTime += d
+	f.stats.MaxRecovery = max(f.stats.MaxRecovery, d)
+}
+
+// faulty runs op unless a fault is injected instead.
+func faulty[T any](ctx context.Context, f *faultKV, op func() (T, error)) (T, error) {
+	var zero T
+	cur, _ := f.outage(f.clock.Since(f.start))
+	if cur > 0 {
+		f.failed.Add(1)
+		return zero, errFaultOutage
+	}
+	if f.cfg.ErrorRate > 0 && rand.Float64() < f.cfg.ErrorRate {
+		f.failed.Add(1)
+		return zero, errFault
+	}
+	if f.cfg.StallRate > 0 && f.cfg.Stall > 0 && rand.Float64() < f.cfg.StallRate {
+		f.stalled.Add(1)
+		t := f.clock.NewTimer(f.cfg.Stall)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return zero, ctx.Err()
+		}
+	}
+
+	v, err := op()
+	if err == nil {
+		t := f.clock.Since(f.start)
+		if _, ended := f.outage(t); ended > 0 {
+			f.noteRecovery(t, ended)
+		}
+	}
+	return v, err
+}
+
+func (f *faultKV) Name() string {
+	return f.next.Name()
+}
+
+func (f *faultKV) Setup(ctx context.Context) error {
+	return f.next.Setup(ctx)
+}
+
+func (f *faultKV) Close(ctx context.Context) error {
+	return f.next.Close(ctx)
+}
+
+func (f *faultKV) Set(ctx context.Context, key, value string) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, f.next.Set(ctx, key, value)
+	})
+	return err
+}
+
+func (f *faultKV) Get(ctx context.Context, key string) (string, error) {
+	return faulty(ctx, f, func() (string, error) {
+		return f.next.Get(ctx, key)
+	})
+}
+
+func (f *faultKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, f.next.SetBytes(ctx, key, value)
+	})
+	return err
+}
+
+func (f *faultKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return faulty(ctx, f, func() ([]byte, error) {
+		return f.next.GetBytes(ctx, key)
+	})
+}
+
+func (f *faultKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, f.next.SetTTL(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (f *faultKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return faulty(ctx, f, func() (bool, error) {
+		return f.next.SetNX(ctx, key, value)
+	})
+}
+
+func (f *faultKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return faulty(ctx, f, func() (bool, error) {
+		return f.next.CompareAndSwap(ctx, key, old, new)
+	})
+}
+
+func (f *faultKV) Incr(ctx context.Context, key string) (int64, error) {
+	return faulty(ctx, f, func() (int64, error) {
+		return f.next.Incr(ctx, key)
+	})
+}
+
+func (f *faultKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return faulty(ctx, f, func() (int64, error) {
+		return windowCounterOf(f.next).IncrWindow(ctx, key, ttl)
+	})
+}
+
+func (f *faultKV) Push(ctx context.Context, queue, value string) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, queueOf(f.next).Push(ctx, queue, value)
+	})
+	return err
+}
+
+func (f *faultKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	p, err := faulty(ctx, f, func() (popped, error) {
+		return pop(ctx, f.next, queue, timeout)
+	})
+	return p.value, p.ok, err
+}
+
+func (f *faultKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return faulty(ctx, f, func() ([]string, error) {
+		return f.next.Scan(ctx, prefix, limit)
+	})
+}
+
+func (f *faultKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, f.next.TxnSet(ctx, kvs)
+	})
+	return err
+}
+
+func (f *faultKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	_, err := faulty(ctx, f, func() (struct{}, error) {
+		return struct{}{}, f.next.BulkLoad(ctx, kvs)
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// growthRows is how many rows PrintGrowth splits a grow phase into.
+const growthRows = 10
+
+// newGrow returns a grow phase worker. Its workers share one counter and
+// insert a new key on every operation, never updating one, so the keyspace
+// grows for the whole phase. Keys are named per run, so a repeated phase
+// inserts too.
+func newGrow(seed int64) func() Worker {
+	return func() Worker {
+		prefix := runPrefix("grow", seed)
+		var next atomic.Int64
+		return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+			value := workerValue(i)
+			for {
+				start, err := p.Wait(ctx)
+				if err != nil {
+					return
+				}
+
+				err = store.Set(ctx, prefix+strconv.FormatInt(next.Add(1), 10), value)
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+				s.OK(p.Since(start))
+			}
+		}
+	}
+}
+
+// PrintGrowth reports a grow phase's insert rate against the number of
+// keys inserted so far, in growthRows spans of the phase, so a rate that
+// sags as the index deepens or memory fills shows against the size that
+// caused it.
+func PrintGrowth(r Result) {
+	if r.Phase != "grow" || len(r.Samples) == 0 {
+		return
+	}
+
+	// the last sample may be a sliver of the phase's drain, which would
+	// read as a collapse of the rate
+	samples := r.Samples
+	if n := len(samples); n > 2 && samples[n-1].Elapsed-samples[n-2].Elapsed < (samples[n-2].Elapsed-samples[n-3].Elapsed)/2 {
+		samples = samples[:n-1]
+	}
+
+	fmt.Printf("==== %s by keyspace size ====\n", r.Label())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "elapsed\tkeys\tops/s\tp99\t\n")
+	var prev Sample
+	var first, last float64
+	rows := min(growthRows, len(samples))
+	for row := 1; row <= rows; row++ {
+		// the samples in this span, and the worst p99 among them
+		end := samples[row*len(samples)/rows-1]
+		var p99 time.Duration
+		for _, x := range samples[(row-1)*len(samples)/rows : row*len(samples)/rows] {
+			p99 = max(p99, x.P99)
+		}
+		ops := float64(end.OK-prev.OK) / (end.Elapsed - prev.Elapsed).Seconds()
+		if row == 1 {
+			first = ops
+		}
+		last = ops
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%s\t\n", end.Elapsed.Round(time.Second), end.OK, ops, p99)
+		prev = end
+	}
+	w.Flush()
+	if first > 0 && rows > 1 {
+		fmt.Printf("insert rate: %+.1f%% from the first to the last span\n", 100*(last-first)/first)
+	}
+}
@@ -0,0 +1,190 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Histogram's buckets are laid out exactly like an HdrHistogram's counts
+// array with a lowest discernible value of 1 and 2 significant digits, so
+// histograms can be handed to HdrHistogram tooling bucket for bucket.
+const (
+	hdrSignificantDigits = 2
+	hdrCookie            = 0x1c849313 // V2 encoding
+	hdrCompressedCookie  = 0x1c849314 // V2 compressed encoding
+
+	// values are nanoseconds; logs and percentile files report milliseconds,
+	// the unit HdrHistogram's log processor and plotters expect
+	hdrUnitRatio = float64(time.Millisecond)
+)
+
+// WriteHdrLogs writes two files per phase into dir, named like the phase's
+// profiles: an HdrHistogram interval log (.hlog) of its per-second latency
+// histograms, for HistogramLogProcessor or merging across runs, and the
+// percentile distribution (.hgrm) of the whole phase, for hdr-plot and
+// wrk2-style percentile spectrum charts. Interval histograms are only
+// there if the phase ran with Histograms set.
+func WriteHdrLogs(dir string, results []Result) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		name := filepath.Join(dir, fmt.Sprintf("%s-%d-%s", r.Backend, r.Workers, r.Label()))
+		err = writeFile(name+".hlog", func(w *bufio.Writer) error {
+			return writeHdrIntervalLog(w, r)
+		})
+		if err != nil {
+			return err
+		}
+		err = writeFile(name+".hgrm", func(w *bufio.Writer) error {
+			writePercentiles(w, r.Stats.latency.Export())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeHdrIntervalLog writes r's samples in HdrHistogram log format 1.3,
+// timestamps in seconds from the phase start.
+func writeHdrIntervalLog(w *bufio.Writer, r Result) error {
+	fmt.Fprintf(w, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(w, "#[kv-test-perf: backend=%s workers=%d phase=%s]\n", r.Backend, r.Workers, r.Label())
+	fmt.Fprintf(w, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	var prev time.Duration
+	for _, x := range r.Samples {
+		start := prev
+		prev = x.Elapsed
+		if x.Latency == nil || x.Latency.Total == 0 {
+			continue
+		}
+		enc, err := encodeHdr(*x.Latency)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%.3f,%.3f,%.3f,%s\n",
+			start.Seconds(), (x.Elapsed - start).Seconds(), float64(x.Latency.Max)/hdrUnitRatio, enc)
+	}
+	return nil
+}
+
+// encodeHdr returns d as a base64, zlib-compressed HdrHistogram V2
+// encoding.
+func encodeHdr(d HistogramData) (string, error) {
+	var counts []byte
+	var next uint64 // next bucket to encode
+	for _, c := range d.Counts {
+		if zeros := c[0] - next; zeros == 1 {
+			counts = appendZigZag(counts, 0)
+		} else if zeros > 1 {
+			counts = appendZigZag(counts, -int64(zeros))
+		}
+		counts = appendZigZag(counts, int64(c[1]))
+		next = c[0] + 1
+	}
+
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.BigEndian, struct {
+		Cookie, PayloadLen, NormalizingOffset, Digits int32
+		Lowest, Highest                               int64
+		Ratio                                         float64
+	}{
+		Cookie:     hdrCookie,
+		PayloadLen: int32(len(counts)),
+		Digits:     hdrSignificantDigits,
+		Lowest:     1,
+		Highest:    int64(max(d.Max, 2)),
+		Ratio:      1,
+	})
+	raw.Write(counts)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(raw.Bytes())
+	err := zw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, [2]int32{hdrCompressedCookie, int32(compressed.Len())})
+	out.Write(compressed.Bytes())
+	return base64.StdEncoding.EncodeToString(out.Bytes()), nil
+}
+
+// appendZigZag appends v as a ZigZag LEB128 varint, as HdrHistogram
+// encodes counts; counts never come near the 2^56 where its encoding
+// parts from the standard one.
+func appendZigZag(b []byte, v int64) []byte {
+	return binary.AppendUvarint(b, uint64(v<<1^v>>63))
+}
+
+// writePercentiles writes d's percentile distribution the way HdrHistogram's
+// outputPercentileDistribution does: 5 reporting ticks per half distance to
+// 100%, values in milliseconds.
+func writePercentiles(w *bufio.Writer, d HistogramData) {
+	fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	if d.Total == 0 {
+		return
+	}
+
+	value := func(i uint64) float64 {
+		return float64(min(bucketValue(int(i)), d.Max)) / hdrUnitRatio
+	}
+	i, seen := 0, d.Counts[0][1]
+	for pct := 0.0; ; {
+		rank := uint64(math.Ceil(pct / 100 * float64(d.Total)))
+		for seen < max(rank, 1) {
+			i++
+			seen += d.Counts[i][1]
+		}
+		if i == len(d.Counts)-1 {
+			break
+		}
+		fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", value(d.Counts[i][0]), pct/100, seen, 1/(1-pct/100))
+		halvings := math.Floor(math.Log2(100/(100-pct))) + 1
+		pct += 100 / (5 * math.Exp2(halvings))
+	}
+	fmt.Fprintf(w, "%12.3f %2.12f %10d\n", value(d.Counts[len(d.Counts)-1][0]), 1.0, d.Total)
+
+	mean := float64(d.Sum) / float64(d.Total)
+	var variance float64
+	for _, c := range d.Counts {
+		dev := float64(min(bucketValue(int(c[0])), d.Max)) - mean
+		variance += dev * dev * float64(c[1])
+	}
+	variance /= float64(d.Total)
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean/hdrUnitRatio, math.Sqrt(variance)/hdrUnitRatio)
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", float64(d.Max)/hdrUnitRatio, d.Total)
+	fmt.Fprintf(w, "#[Buckets = %12d, SubBuckets     = %12d]\n", max(bits.Len64(d.Max)-subBits+1, 1), subCount)
+}
@@ -0,0 +1,163 @@
+package bench
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// histogram buckets are log-linear: values below 2^subBits are exact, above
+// that each power of two is split into 2^(subBits-1) buckets (<1% error).
+const (
+	subBits      = 8
+	subCount     = 1 << subBits
+	halfSubCount = subCount / 2
+	bucketCount  = subCount + (64-subBits)*halfSubCount
+)
+
+// Histogram records latencies in nanoseconds. The zero value is ready to use
+// and safe for concurrent Record calls.
+type Histogram struct {
+	counts [bucketCount]uint64
+	total  uint64
+	sum    uint64
+	max    uint64
+}
+
+func bucketIndex(v uint64) int {
+	if v < subCount {
+		return int(v)
+	}
+	e := bits.Len64(v) - subBits
+	m := v >> e
+	return subCount + (e-1)*halfSubCount + int(m-halfSubCount)
+}
+
+// bucketValue returns the highest value that maps to bucket i.
+func bucketValue(i int) uint64 {
+	if i < subCount {
+		return uint64(i)
+	}
+	i -= subCount
+	e := i/halfSubCount + 1
+	m := uint64(i%halfSubCount + halfSubCount)
+	return (m+1)<<e - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	v := uint64(d)
+	atomic.AddUint64(&h.counts[bucketIndex(v)], 1)
+	atomic.AddUint64(&h.total, 1)
+	atomic.AddUint64(&h.sum, v)
+	for {
+		m := atomic.LoadUint64(&h.max)
+		if v <= m || atomic.CompareAndSwapUint64(&h.max, m, v) {
+			break
+		}
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.total)
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadUint64(&h.max))
+}
+
+func (h *Histogram) Mean() time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&h.sum) / n)
+}
+
+// Quantile returns the latency at quantile q (0 < q <= 1).
+func (h *Histogram) Quantile(q float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(n))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i := range h.counts {
+		seen += atomic.LoadUint64(&h.counts[i])
+		if seen >= rank {
+			v := bucketValue(i)
+			if m := atomic.LoadUint64(&h.max); v > m {
+				v = m
+			}
+			return time.Duration(v)
+		}
+	}
+	return h.Max()
+}
+
+// Merge adds o's recorded values into h.
+func (h *Histogram) Merge(o *Histogram) {
+	for i := range o.counts {
+		if c := atomic.LoadUint64(&o.counts[i]); c > 0 {
+			atomic.AddUint64(&h.counts[i], c)
+		}
+	}
+	atomic.AddUint64(&h.total, atomic.LoadUint64(&o.total))
+	atomic.AddUint64(&h.sum, atomic.LoadUint64(&o.sum))
+	v := atomic.LoadUint64(&o.max)
+	for {
+		m := atomic.LoadUint64(&h.max)
+		if v <= m || atomic.CompareAndSwapUint64(&h.max, m, v) {
+			break
+		}
+	}
+}
+
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreUint64(&h.total, 0)
+	atomic.StoreUint64(&h.sum, 0)
+	atomic.StoreUint64(&h.max, 0)
+}
+
+// HistogramData is the serializable form of a Histogram; Counts holds
+// [bucket, count] pairs for the non-empty buckets only.
+type HistogramData struct {
+	Counts [][2]uint64 `json:"counts"`
+	Total  uint64      `json:"total"`
+	Sum    uint64      `json:"sum"`
+	Max    uint64      `json:"max"`
+}
+
+func (h *Histogram) Export() HistogramData {
+	d := HistogramData{
+		Total: atomic.LoadUint64(&h.total),
+		Sum:   atomic.LoadUint64(&h.sum),
+		Max:   atomic.LoadUint64(&h.max),
+	}
+	for i := range h.counts {
+		if c := atomic.LoadUint64(&h.counts[i]); c > 0 {
+			d.Counts = append(d.Counts, [2]uint64{uint64(i), c})
+		}
+	}
+	return d
+}
+
+// Import adds the values in d to h.
+func (h *Histogram) Import(d HistogramData) {
+	var o Histogram
+	for _, c := range d.Counts {
+		if c[0] < bucketCount {
+			o.counts[c[0]] = c[1]
+		}
+	}
+	o.total, o.sum, o.max = d.Total, d.Sum, d.Max
+	h.Merge(&o)
+}
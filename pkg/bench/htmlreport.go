@@ -0,0 +1,227 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// WriteHTMLReport renders a self-contained HTML file with throughput over
+// time, latency CDFs and a throughput comparison for every phase. Charts are
+// inline SVG so the file can be shared without any other assets.
+func WriteHTMLReport(path string, md Metadata, results []Result) error {
+	type section struct {
+		Title    string
+		Timeline template.HTML
+		CDF      template.HTML
+		Bars     template.HTML
+	}
+
+	groups := make(map[string][]Result)
+	var order []string
+	for _, r := range results {
+		k := fmt.Sprintf("%s (workers=%d)", r.Label(), r.Workers)
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+
+	var sections []section
+	for _, k := range order {
+		rs := groups[k]
+		var timeline, cdf []chartSeries
+		var bars []bar
+		for _, r := range rs {
+			timeline = append(timeline, chartSeries{Name: r.Backend, Points: opsPoints(r.Samples)})
+			cdf = append(cdf, chartSeries{Name: r.Backend, Points: cdfPoints(&r.Stats.latency)})
+			bars = append(bars, bar{Name: r.Backend, Value: r.Ops()})
+		}
+		sections = append(sections, section{
+			Title:    k,
+			Timeline: lineChart("throughput over time", "seconds", "ops/s", timeline, false),
+			CDF:      lineChart("latency CDF", "latency (µs, log)", "percentile", cdf, true),
+			Bars:     barChart("throughput by backend", "ops/s", bars),
+		})
+	}
+
+	var summaries []ResultSummary
+	for _, r := range results {
+		summaries = append(summaries, r.Summary())
+	}
+
+	var buf bytes.Buffer
+	err := htmlReportTemplate.Execute(&buf, map[string]any{
+		"Created":  time.Now().UTC().Format(time.RFC3339),
+		"Metadata": md,
+		"Results":  summaries,
+		"Sections": sections,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+type chartSeries struct {
+	Name   string
+	Points [][2]float64
+}
+
+type bar struct {
+	Name  string
+	Value float64
+}
+
+func opsPoints(samples []Sample) [][2]float64 {
+	var pts [][2]float64
+	var prev Sample
+	for _, x := range samples {
+		dt := (x.Elapsed - prev.Elapsed).Seconds()
+		if dt > 0 {
+			pts = append(pts, [2]float64{x.At.Seconds(), float64((x.OK+x.Err)-(prev.OK+prev.Err)) / dt})
+		}
+		prev = x
+	}
+	return pts
+}
+
+// cdfPoints samples the latency distribution at log-spaced percentiles so
+// the tail gets as much resolution as the body.
+func cdfPoints(h *Histogram) [][2]float64 {
+	if h.Count() == 0 {
+		return nil
+	}
+	var pts [][2]float64
+	for _, q := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.995, 0.999, 0.9999, 1} {
+		us := float64(h.Quantile(q)) / float64(time.Microsecond)
+		pts = append(pts, [2]float64{us, q * 100})
+	}
+	return pts
+}
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7"}
+
+const (
+	chartW   = 560.0
+	chartH   = 260.0
+	chartPad = 48.0
+)
+
+func lineChart(title, xLabel, yLabel string, series []chartSeries, logX bool) template.HTML {
+	minX, maxX, maxY := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range series {
+		for _, p := range s.Points {
+			x := p[0]
+			if logX {
+				x = math.Log10(math.Max(x, 0.001))
+			}
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			maxY = math.Max(maxY, p[1])
+		}
+	}
+	if math.IsInf(minX, 0) || maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+
+	var b bytes.Buffer
+	svgOpen(&b, title, xLabel, yLabel)
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="10">%s</text>`, chartPad, chartH-chartPad+14, formatAxis(minX, logX))
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="10" text-anchor="end">%s</text>`, chartW-chartPad, chartH-chartPad+14, formatAxis(maxX, logX))
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="10" text-anchor="end">%.0f</text>`, chartPad-4, chartPad+4, maxY)
+	for i, s := range series {
+		color := chartColors[i%len(chartColors)]
+		b.WriteString(`<polyline fill="none" stroke-width="1.5" stroke="` + color + `" points="`)
+		for _, p := range s.Points {
+			x := p[0]
+			if logX {
+				x = math.Log10(math.Max(x, 0.001))
+			}
+			px := chartPad + (x-minX)/(maxX-minX)*(chartW-2*chartPad)
+			py := chartH - chartPad - p[1]/maxY*(chartH-2*chartPad)
+			fmt.Fprintf(&b, "%.1f,%.1f ", px, py)
+		}
+		b.WriteString(`"/>`)
+		legend(&b, i, s.Name, color)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func barChart(title, yLabel string, bars []bar) template.HTML {
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Value > bars[j].Value })
+	maxY := 1.0
+	for _, x := range bars {
+		maxY = math.Max(maxY, x.Value)
+	}
+
+	var b bytes.Buffer
+	svgOpen(&b, title, "", yLabel)
+	w := (chartW - 2*chartPad) / float64(len(bars)+1)
+	for i, x := range bars {
+		h := x.Value / maxY * (chartH - 2*chartPad)
+		px := chartPad + w*float64(i) + w/2
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, px, chartH-chartPad-h, w*0.8, h, chartColors[i%len(chartColors)])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="middle">%s</text>`, px+w*0.4, chartH-chartPad+14, template.HTMLEscapeString(x.Name))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="middle">%.0f</text>`, px+w*0.4, chartH-chartPad-h-4, x.Value)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func svgOpen(b *bytes.Buffer, title, xLabel, yLabel string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif">`, chartW, chartH)
+	fmt.Fprintf(b, `<text x="%.0f" y="18" font-size="13" text-anchor="middle">%s</text>`, chartW/2, template.HTMLEscapeString(title))
+	fmt.Fprintf(b, `<line x1="%[1]f" y1="%[2]f" x2="%[3]f" y2="%[2]f" stroke="#999"/>`, chartPad, chartH-chartPad, chartW-chartPad)
+	fmt.Fprintf(b, `<line x1="%[1]f" y1="%[2]f" x2="%[1]f" y2="%[3]f" stroke="#999"/>`, chartPad, chartPad, chartH-chartPad)
+	fmt.Fprintf(b, `<text x="%.0f" y="%.0f" font-size="11" text-anchor="middle">%s</text>`, chartW/2, chartH-12, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(b, `<text x="14" y="%.0f" font-size="11" text-anchor="middle" transform="rotate(-90 14 %.0f)">%s</text>`, chartH/2, chartH/2, template.HTMLEscapeString(yLabel))
+}
+
+func legend(b *bytes.Buffer, i int, name, color string) {
+	y := chartPad + float64(i)*14
+	fmt.Fprintf(b, `<rect x="%.0f" y="%.0f" width="10" height="10" fill="%s"/>`, chartW-chartPad-90, y-9, color)
+	fmt.Fprintf(b, `<text x="%.0f" y="%.0f" font-size="11">%s</text>`, chartW-chartPad-76, y, template.HTMLEscapeString(name))
+}
+
+func formatAxis(v float64, log bool) string {
+	if log {
+		v = math.Pow(10, v)
+	}
+	return fmt.Sprintf("%.4g", v)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kv-test-perf report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: right; }
+th { background: #f4f4f4; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+</style>
+</head>
+<body>
+<h1>kv-test-perf report</h1>
+<p>{{.Created}} &middot; {{.Metadata.GoVersion}} &middot; {{.Metadata.NumCPU}} CPUs (GOMAXPROCS {{.Metadata.GOMAXPROCS}})</p>
+<table>
+<tr><th>backend</th><th>workers</th><th>phase</th><th>ops/s</th><th>p50</th><th>p99</th><th>p99.9</th><th>p99.99</th><th>max</th><th>errors</th></tr>
+{{range .Results}}<tr><td>{{.Backend}}</td><td>{{.Workers}}</td><td>{{.Phase}}{{if .Run}}#{{.Run}}{{end}}</td><td>{{.Ops}}</td><td>{{.P50}}</td><td>{{.P99}}</td><td>{{.P999}}</td><td>{{.P9999}}</td><td>{{.Max}} at {{.MaxAt}}</td><td>{{.Err}}</td></tr>
+{{end}}</table>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+<div class="charts">{{.Timeline}}{{.CDF}}{{.Bars}}</div>
+{{end}}
+</body>
+</html>
+`))
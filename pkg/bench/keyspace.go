@@ -0,0 +1,98 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Keyspace is a fixed set of preformatted keys shared by a phase's workers.
+type Keyspace struct {
+	keys []string
+}
+
+func NewKeyspace(n int) *Keyspace {
+	ks := &Keyspace{keys: make([]string, n)}
+	for i := range ks.keys {
+		ks.keys[i] = workerKey(i)
+	}
+	return ks
+}
+
+func (ks *Keyspace) Len() int {
+	return len(ks.keys)
+}
+
+// KeyChooser picks the next key index. Each worker owns its own chooser.
+type KeyChooser interface {
+	Next() int
+}
+
+func NewKeyChooser(distribution string, n, worker int, rnd *rand.Rand) (KeyChooser, error) {
+	switch distribution {
+	case "", "uniform":
+		return &uniformChooser{n: n, rnd: rnd}, nil
+	case "zipf":
+		return &zipfChooser{z: rand.NewZipf(rnd, 1.1, 1, uint64(n-1))}, nil
+	case "sequential":
+		return &sequentialChooser{n: n, next: worker % n}, nil
+	default:
+		return nil, fmt.Errorf("unknown key distribution: %s", distribution)
+	}
+}
+
+type uniformChooser struct {
+	n   int
+	rnd *rand.Rand
+}
+
+func (c *uniformChooser) Next() int {
+	return c.rnd.Intn(c.n)
+}
+
+// zipfChooser favors low key indexes, modeling a few hot keys and a long
+// cold tail.
+type zipfChooser struct {
+	z *rand.Zipf
+}
+
+func (c *zipfChooser) Next() int {
+	return int(c.z.Uint64())
+}
+
+// sequentialChooser walks the keyspace in order from a per-worker offset.
+type sequentialChooser struct {
+	n    int
+	next int
+}
+
+func (c *sequentialChooser) Next() int {
+	k := c.next
+	c.next = (c.next + 1) % c.n
+	return k
+}
+
+// hotChooser sends a fraction of the picks to the first few keys and
+// leaves the rest to next, concentrating writes on a handful of rows.
+type hotChooser struct {
+	next  KeyChooser
+	hot   int
+	ratio float64
+	rnd   *rand.Rand
+}
+
+func (c *hotChooser) Next() int {
+	if c.rnd.Float64() < c.ratio {
+		return c.rnd.Intn(c.hot)
+	}
+	return c.next.Next()
+}
+
+// fillValue returns a value of exactly size bytes.
+func fillValue(size int) string {
+	if size <= 0 {
+		return ""
+	}
+	const pattern = "0123456789abcdefghijklmnopqrstuvwxyz"
+	return strings.Repeat(pattern, size/len(pattern)+1)[:size]
+}
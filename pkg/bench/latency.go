@@ -0,0 +1,171 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// LatencyConfig adds an artificial round trip to every operation, to model
+// a server that is far away instead of on localhost.
+type LatencyConfig struct {
+	Delay  time.Duration `json:"delay"`  // added to every operation, 0 disables
+	Jitter time.Duration `json:"jitter"` // delay varies uniformly by up to this much either way
+}
+
+func (c LatencyConfig) String() string {
+	if c.Jitter == 0 {
+		return c.Delay.String()
+	}
+	return fmt.Sprintf("%s ±%s", c.Delay, c.Jitter)
+}
+
+// latencyKV waits out an injected delay before passing each operation to
+// next. The delay stands for the network round trip, so operations that are
+// one round trip on the wire, such as TxnSet, BulkLoad and a whole Scan, pay
+// it once. Everything else stays as fast as the real connection: the client
+// still opens connections and completes handshakes over it, and a pipelining
+// backend overlaps the delays of the operations it batches.
+type latencyKV struct {
+	next  kv.KV
+	cfg   LatencyConfig
+	clock Clock
+}
+
+func NewLatencyKV(next kv.KV, cfg LatencyConfig, clock Clock) *latencyKV {
+	return &latencyKV{next: next, cfg: cfg, clock: clock}
+}
+
+// delayed runs op after the injected delay.
+func delayed[T any](ctx context.Context, l *latencyKV, op func() (T, error)) (T, error) {
+	err := l.wait(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return op()
+}
+
+func (l *latencyKV) wait(ctx context.Context) error {
+	d := l.cfg.Delay
+	if l.cfg.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*l.cfg.Jitter)+1)) - l.cfg.Jitter
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := l.clock.NewTimer(d)
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	}
+}
+
+func (l *latencyKV) Name() string {
+	return l.next.Name()
+}
+
+func (l *latencyKV) Setup(ctx context.Context) error {
+	return l.next.Setup(ctx)
+}
+
+func (l *latencyKV) Close(ctx context.Context) error {
+	return l.next.Close(ctx)
+}
+
+func (l *latencyKV) Set(ctx context.Context, key, value string) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, l.next.Set(ctx, key, value)
+	})
+	return err
+}
+
+func (l *latencyKV) Get(ctx context.Context, key string) (string, error) {
+	return delayed(ctx, l, func() (string, error) {
+		return l.next.Get(ctx, key)
+	})
+}
+
+func (l *latencyKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, l.next.SetBytes(ctx, key, value)
+	})
+	return err
+}
+
+func (l *latencyKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return delayed(ctx, l, func() ([]byte, error) {
+		return l.next.GetBytes(ctx, key)
+	})
+}
+
+func (l *latencyKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, l.next.SetTTL(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (l *latencyKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return delayed(ctx, l, func() (bool, error) {
+		return l.next.SetNX(ctx, key, value)
+	})
+}
+
+func (l *latencyKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return delayed(ctx, l, func() (bool, error) {
+		return l.next.CompareAndSwap(ctx, key, old, new)
+	})
+}
+
+func (l *latencyKV) Incr(ctx context.Context, key string) (int64, error) {
+	return delayed(ctx, l, func() (int64, error) {
+		return l.next.Incr(ctx, key)
+	})
+}
+
+func (l *latencyKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return delayed(ctx, l, func() (int64, error) {
+		return windowCounterOf(l.next).IncrWindow(ctx, key, ttl)
+	})
+}
+
+func (l *latencyKV) Push(ctx context.Context, queue, value string) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, queueOf(l.next).Push(ctx, queue, value)
+	})
+	return err
+}
+
+func (l *latencyKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	p, err := delayed(ctx, l, func() (popped, error) {
+		return pop(ctx, l.next, queue, timeout)
+	})
+	return p.value, p.ok, err
+}
+
+func (l *latencyKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return delayed(ctx, l, func() ([]string, error) {
+		return l.next.Scan(ctx, prefix, limit)
+	})
+}
+
+func (l *latencyKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, l.next.TxnSet(ctx, kvs)
+	})
+	return err
+}
+
+func (l *latencyKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	_, err := delayed(ctx, l, func() (struct{}, error) {
+		return struct{}{}, l.next.BulkLoad(ctx, kvs)
+	})
+	return err
+}
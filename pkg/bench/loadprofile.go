@@ -0,0 +1,212 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// kneeEfficiency is the share of added load that must still turn into
+// added throughput for a step to count as scaling; below it, or when p99
+// more than doubles, the previous step is the knee.
+const kneeEfficiency = 0.5
+
+// LoadStep is one level of a load profile.
+type LoadStep struct {
+	Workers int     // 0 keeps the run's worker count
+	Rate    float64 // target ops/sec across all workers, 0 for the run's own
+}
+
+func (s LoadStep) String() string {
+	var parts []string
+	if s.Workers > 0 {
+		parts = append(parts, fmt.Sprintf("workers=%d", s.Workers))
+	}
+	if s.Rate > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%.0f", s.Rate))
+	}
+	return strings.Join(parts, " ")
+}
+
+// LoadProfile is the load levels every phase runs at in turn, each for the
+// run's duration and reported on its own, so throughput and latency can be
+// read against load.
+type LoadProfile struct {
+	Kind      string // "ramp", "step" or "spike"
+	Dimension string // "workers" or "rate"
+	Steps     []LoadStep
+}
+
+// ParseLoadProfile parses kind:dimension:levels, with dimension workers or
+// rate (total ops/sec):
+//
+//	ramp:workers:10-200:8     8 evenly spaced steps from 10 to 200 workers
+//	ramp:rate:50000-1000:5    5 steps down from 50000 to 1000 ops/sec
+//	step:rate:1000,5000,20000 the listed levels in order
+//	spike:workers:20,400      20, then 400, then 20 again
+func ParseLoadProfile(s string) (LoadProfile, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 {
+		return LoadProfile{}, fmt.Errorf("invalid load profile %q, want kind:dimension:levels", s)
+	}
+	p := LoadProfile{Kind: parts[0], Dimension: parts[1]}
+	if p.Dimension != "workers" && p.Dimension != "rate" {
+		return LoadProfile{}, fmt.Errorf("invalid load profile dimension: %s (want workers or rate)", p.Dimension)
+	}
+
+	var levels []float64
+	switch p.Kind {
+	case "ramp":
+		if len(parts) != 4 {
+			return LoadProfile{}, fmt.Errorf("invalid ramp profile %q, want ramp:%s:from-to:steps", s, p.Dimension)
+		}
+		from, to, ok := strings.Cut(parts[2], "-")
+		if !ok {
+			return LoadProfile{}, fmt.Errorf("invalid ramp range: %s", parts[2])
+		}
+		lo, err := parseLevel(from)
+		if err != nil {
+			return LoadProfile{}, err
+		}
+		hi, err := parseLevel(to)
+		if err != nil {
+			return LoadProfile{}, err
+		}
+		n, err := strconv.Atoi(parts[3])
+		if err != nil || n < 2 {
+			return LoadProfile{}, fmt.Errorf("invalid ramp steps: %s (want at least 2)", parts[3])
+		}
+		for i := 0; i < n; i++ {
+			levels = append(levels, lo+(hi-lo)*float64(i)/float64(n-1))
+		}
+	case "step", "spike":
+		if len(parts) != 3 {
+			return LoadProfile{}, fmt.Errorf("invalid %s profile %q, want %s:%s:levels", p.Kind, s, p.Kind, p.Dimension)
+		}
+		for _, f := range strings.Split(parts[2], ",") {
+			v, err := parseLevel(f)
+			if err != nil {
+				return LoadProfile{}, err
+			}
+			levels = append(levels, v)
+		}
+		if p.Kind == "spike" {
+			if len(levels) != 2 {
+				return LoadProfile{}, fmt.Errorf("invalid spike levels: %s (want base,peak)", parts[2])
+			}
+			levels = append(levels, levels[0])
+		}
+	default:
+		return LoadProfile{}, fmt.Errorf("invalid load profile kind: %s (want ramp, step or spike)", p.Kind)
+	}
+
+	for _, v := range levels {
+		if p.Dimension == "workers" {
+			p.Steps = append(p.Steps, LoadStep{Workers: int(math.Round(v))})
+		} else {
+			p.Steps = append(p.Steps, LoadStep{Rate: v})
+		}
+	}
+	return p, nil
+}
+
+func parseLevel(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid load level: %s", s)
+	}
+	return v, nil
+}
+
+// increasing reports whether every step offers more load than the one
+// before, the only shape a knee can be read off.
+func (p LoadProfile) increasing() bool {
+	for i := 1; i < len(p.Steps); i++ {
+		if p.load(i) <= p.load(i-1) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p LoadProfile) load(step int) float64 {
+	if p.Dimension == "workers" {
+		return float64(p.Steps[step].Workers)
+	}
+	return p.Steps[step].Rate
+}
+
+// PrintLoadProfile tabulates each backend's phases step by step and, for a
+// profile whose load only grows, names the knee: the last step where added
+// load still bought throughput without blowing up p99.
+func PrintLoadProfile(results []Result, p LoadProfile) {
+	type group struct{ backend, phase string }
+	var order []group
+	steps := make(map[group][]Result)
+	for _, r := range results {
+		if r.Step == 0 {
+			continue
+		}
+		g := group{r.Backend, r.Phase}
+		if _, ok := steps[g]; !ok {
+			order = append(order, g)
+		}
+		steps[g] = append(steps[g], r)
+	}
+
+	for _, g := range order {
+		rs := steps[g]
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].Step < rs[j].Step })
+
+		fmt.Printf("==== %s %s: %s profile ====\n", g.backend, g.phase, p.Kind)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "step\tworkers\ttarget\tops\tp50\tp99\terr\t\n")
+		for _, r := range rs {
+			target := "-"
+			if rate := p.Steps[r.Step-1].Rate; rate > 0 {
+				target = fmt.Sprintf("%.0f", rate)
+			}
+			last := r.Samples[len(r.Samples)-1]
+			fmt.Fprintf(w, "%d\t%d\t%s\t%.0f\t%s\t%s\t%d\t\n",
+				r.Step, r.Workers, target, r.Ops(),
+				r.Stats.latency.Quantile(0.5), r.Stats.latency.Quantile(0.99), last.Err)
+		}
+		w.Flush()
+
+		if !p.increasing() || len(rs) < 2 {
+			continue
+		}
+		knee, why := findKnee(rs, p)
+		if knee == nil {
+			fmt.Printf("knee: not reached, throughput still scales at step %d\n", rs[len(rs)-1].Step)
+			continue
+		}
+		fmt.Printf("knee: step %d (%s): %.0f ops/s at p99 %s; %s\n",
+			knee.Step, p.Steps[knee.Step-1], knee.Ops(), knee.Stats.latency.Quantile(0.99), why)
+	}
+}
+
+// findKnee returns the last step before throughput stopped following load,
+// with what gave out at the next step, or nil if it never did.
+func findKnee(rs []Result, p LoadProfile) (*Result, string) {
+	for i := 1; i < len(rs); i++ {
+		prev, cur := &rs[i-1], &rs[i]
+		if prev.Ops() == 0 {
+			continue
+		}
+		loadGain := p.load(cur.Step-1)/p.load(prev.Step-1) - 1
+		opsGain := cur.Ops()/prev.Ops() - 1
+		if eff := opsGain / loadGain; eff < kneeEfficiency {
+			return prev, fmt.Sprintf("%.0f%% more load gave %+.0f%% throughput", loadGain*100, opsGain*100)
+		}
+		p99, prevP99 := cur.Stats.latency.Quantile(0.99), prev.Stats.latency.Quantile(0.99)
+		if prevP99 > 0 && p99 > 2*prevP99 {
+			return prev, fmt.Sprintf("p99 rose %.1fx to %s", float64(p99)/float64(prevP99), p99)
+		}
+	}
+	return nil, ""
+}
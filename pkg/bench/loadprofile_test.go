@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+	workers := workersSteps
+	rates := func(rs ...float64) []LoadStep {
+		steps := make([]LoadStep, len(rs))
+		for i, r := range rs {
+			steps[i] = LoadStep{Rate: r}
+		}
+		return steps
+	}
+
+	for _, tc := range []struct {
+		in         string
+		want       []LoadStep
+		increasing bool
+	}{
+		{"ramp:workers:10-40:4", workers(10, 20, 30, 40), true},
+		{"ramp:workers:1-2:3", workers(1, 2, 2), false}, // 1.5 rounds to 2
+		{"ramp:rate:4000-1000:4", rates(4000, 3000, 2000, 1000), false},
+		{"step:rate:1000,5000,20000", rates(1000, 5000, 20000), true},
+		{"step:workers:8", workers(8), true},
+		{"spike:workers:20,400", workers(20, 400, 20), false},
+	} {
+		p, err := ParseLoadProfile(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if kind := strings.Split(tc.in, ":")[0]; p.Kind != kind {
+			t.Errorf("%s: kind = %s", tc.in, p.Kind)
+		}
+		if !reflect.DeepEqual(p.Steps, tc.want) {
+			t.Errorf("%s: steps = %v, want %v", tc.in, p.Steps, tc.want)
+		}
+		if p.increasing() != tc.increasing {
+			t.Errorf("%s: increasing = %t", tc.in, !tc.increasing)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"ramp:workers",
+		"ramp:threads:10-20:2",
+		"wave:workers:10,20",
+		"ramp:workers:10-20",
+		"ramp:workers:10:4",
+		"ramp:workers:10-20:1",
+		"ramp:workers:10-20:x",
+		"ramp:workers:0-20:2",
+		"ramp:rate:a-20:2",
+		"step:workers:10,-5",
+		"step:workers:10,,20",
+		"step:workers:10:20",
+		"spike:workers:20",
+		"spike:workers:20,400,20",
+	} {
+		if _, err := ParseLoadProfile(in); err == nil {
+			t.Errorf("%q: parsed, want an error", in)
+		}
+	}
+}
+
+func TestFindKnee(t *testing.T) {
+	p := LoadProfile{Kind: "step", Dimension: "workers", Steps: workersSteps(10, 20, 40)}
+	step := func(n int, ops uint64, p99 time.Duration) Result {
+		r := testResult("get", p99, ops, 0)
+		r.Step = n
+		return r
+	}
+
+	for _, tc := range []struct {
+		name string
+		rs   []Result
+		knee int // step, 0 for none
+		why  string
+	}{
+		{
+			name: "scales",
+			rs:   []Result{step(1, 1000, time.Millisecond), step(2, 2000, time.Millisecond), step(3, 4000, time.Millisecond)},
+		},
+		{
+			name: "just efficient enough",
+			// doubling the load buys exactly half again the throughput
+			rs: []Result{step(1, 1000, time.Millisecond), step(2, 1500, time.Millisecond), step(3, 2250, time.Millisecond)},
+		},
+		{
+			name: "throughput flattens",
+			rs:   []Result{step(1, 1000, time.Millisecond), step(2, 2000, time.Millisecond), step(3, 2100, time.Millisecond)},
+			knee: 2,
+			why:  "throughput",
+		},
+		{
+			name: "p99 blows up",
+			rs:   []Result{step(1, 1000, time.Millisecond), step(2, 2000, 3*time.Millisecond), step(3, 4000, 3*time.Millisecond)},
+			knee: 1,
+			why:  "p99 rose",
+		},
+		{
+			name: "nothing at first",
+			rs:   []Result{step(1, 0, time.Millisecond), step(2, 1000, time.Millisecond), step(3, 1100, time.Millisecond)},
+			knee: 2,
+			why:  "throughput",
+		},
+	} {
+		knee, why := findKnee(tc.rs, p)
+		got := 0
+		if knee != nil {
+			got = knee.Step
+		}
+		if got != tc.knee || !strings.Contains(why, tc.why) {
+			t.Errorf("%s: knee at step %d (%q), want %d (%q)", tc.name, got, why, tc.knee, tc.why)
+		}
+	}
+}
+
+func workersSteps(ns ...int) []LoadStep {
+	steps := make([]LoadStep, len(ns))
+	for i, n := range ns {
+		steps[i] = LoadStep{Workers: n}
+	}
+	return steps
+}
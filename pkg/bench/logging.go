@@ -0,0 +1,98 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SetupLogging sets the default logger. Logs go to stderr so stdout only
+// carries results.
+func SetupLogging(level, format string) error {
+	var lv slog.Level
+	err := lv.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("invalid -log-level: %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log-format: %s", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// DefaultErrorLogRate is how many errors per second a phase logs when
+// PhaseConfig.ErrorLogRate is zero.
+const DefaultErrorLogRate = 10
+
+// errorLog rate-limits the error and anomaly logs of a phase, which would
+// otherwise flood the output when a backend fails every operation. Past the
+// limit, messages are only counted, and the count is logged at the start of
+// the next second and when the phase ends.
+type errorLog struct {
+	logger *slog.Logger
+	limit  int // per second, negative for no limit
+
+	mu         sync.Mutex
+	window     time.Time
+	logged     int
+	suppressed int
+}
+
+func newErrorLog(logger *slog.Logger, limit int) *errorLog {
+	if limit == 0 {
+		limit = DefaultErrorLogRate
+	}
+	return &errorLog{logger: logger, limit: limit}
+}
+
+func (l *errorLog) Log(level slog.Level, msg string, err error) {
+	if l == nil {
+		slog.Log(context.Background(), level, msg, "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit >= 0 {
+		if now := time.Now(); now.Sub(l.window) >= time.Second {
+			l.flush()
+			l.window, l.logged = now, 0
+		}
+		if l.logged >= l.limit {
+			l.suppressed++
+			return
+		}
+		l.logged++
+	}
+	l.logger.Log(context.Background(), level, msg, "err", err)
+}
+
+// Flush logs the count of messages suppressed since the last one logged.
+func (l *errorLog) Flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.flush()
+	l.mu.Unlock()
+}
+
+func (l *errorLog) flush() {
+	if l.suppressed > 0 {
+		l.logger.Warn("errors suppressed", "count", l.suppressed)
+		l.suppressed = 0
+	}
+}
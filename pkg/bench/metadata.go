@@ -0,0 +1,71 @@
+package bench
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// Metadata describes the client environment a run was measured in.
+type Metadata struct {
+	GoVersion  string       `json:"go_version"`
+	NumCPU     int          `json:"num_cpu"`
+	GOMAXPROCS int          `json:"gomaxprocs"`
+	Cgroup     CgroupLimits `json:"cgroup"`
+	Seed       int64        `json:"seed"` // workload seed; rerun with -seed to repeat it
+}
+
+func CollectMetadata(cg CgroupLimits, seed int64) Metadata {
+	return Metadata{
+		GoVersion:  runtime.Version(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Cgroup:     cg,
+		Seed:       seed,
+	}
+}
+
+func (m Metadata) Print() {
+	fmt.Printf("go: %s cpus: %d gomaxprocs: %d seed: %d\n", m.GoVersion, m.NumCPU, m.GOMAXPROCS, m.Seed)
+	if m.Cgroup.CPU > 0 || m.Cgroup.Memory > 0 {
+		fmt.Printf("cgroup: cpu=%s memory=%s\n", formatCPU(m.Cgroup.CPU), formatBytes(m.Cgroup.Memory))
+	}
+}
+
+// PrintServer prints what a backend ran against, leaving out what it didn't
+// report.
+func PrintServer(s kv.ServerInfo) {
+	if s.Version != "" {
+		fmt.Printf("server: %s\n", s.Version)
+	}
+	if s.Config != "" {
+		fmt.Printf("server config: %s\n", s.Config)
+	}
+	if s.Driver != "" {
+		fmt.Printf("driver: %s\n", s.Driver)
+	}
+}
+
+func formatCPU(v float64) string {
+	if v == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+func formatBytes(v int64) string {
+	if v == 0 {
+		return "unlimited"
+	}
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%dB", v)
+	}
+	div, exp := int64(unit), 0
+	for n := v / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(v)/float64(div), "KMGTPE"[exp])
+}
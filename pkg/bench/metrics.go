@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposes live counters and latency histograms in Prometheus format
+// so long runs can be watched next to the backend's own metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+	ops      *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvperf_operations_total",
+			Help: "Completed operations by result.",
+		}, []string{"backend", "phase", "result"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvperf_operation_duration_seconds",
+			Help:    "Latency of successful operations.",
+			Buckets: prometheus.ExponentialBuckets(10e-6, 2, 20),
+		}, []string{"backend", "phase"}),
+	}
+	m.registry.MustRegister(m.ops, m.latency)
+	return m
+}
+
+// Serve listens on addr and serves in the background. A listen error is
+// returned rather than ending the process mid-run.
+func (m *Metrics) Serve(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go http.Serve(lis, mux)
+	return nil
+}
+
+// Phase returns the collectors for one phase. It is nil-safe so callers
+// don't need to check whether metrics are enabled.
+func (m *Metrics) Phase(backend, phase string) *phaseMetrics {
+	if m == nil {
+		return nil
+	}
+	return &phaseMetrics{
+		ok:      m.ops.WithLabelValues(backend, phase, "ok"),
+		err:     m.ops.WithLabelValues(backend, phase, "err"),
+		latency: m.latency.WithLabelValues(backend, phase),
+	}
+}
+
+type phaseMetrics struct {
+	ok      prometheus.Counter
+	err     prometheus.Counter
+	latency prometheus.Observer
+}
+
+func (m *phaseMetrics) OK(latency time.Duration) {
+	m.ok.Inc()
+	m.latency.Observe(latency.Seconds())
+}
+
+func (m *phaseMetrics) Err() {
+	m.err.Inc()
+}
@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+type MigrationConfig struct {
+	Source   string
+	Target   string
+	Keys     int
+	Workers  int
+	Populate bool // load Keys keys into the source first
+}
+
+// ParseMigration parses a "source,target" pair of backend names.
+func ParseMigration(v string) (src, dst string, err error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid -migrate, want source,target: %s", v)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// RunMigration measures copying a keyspace from one backend to another: an
+// optional populate phase on the source, a backfill phase that reads every
+// key from the source and writes it to the target, then a dual-read phase
+// that reads both and counts mismatches as anomalies.
+func RunMigration(ctx context.Context, mc MigrationConfig, cfg kv.BackendConfig, readyTimeout time.Duration) ([]Result, error) {
+	src, err := kv.New(mc.Source, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close(context.Background())
+	dst, err := kv.New(mc.Target, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close(context.Background())
+
+	if mc.Populate {
+		_, _, err = WaitSetup(ctx, src, readyTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, _, err = WaitSetup(ctx, dst, readyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("migration: %s -> %s (%d keys)\n", src.Name(), dst.Name(), mc.Keys)
+	ks := NewKeyspace(mc.Keys)
+	pc := PhaseConfig{Workers: mc.Workers}
+
+	var results []Result
+	if mc.Populate {
+		r := runPhase(ctx, src, "populate", pc, runPopulate(ks, mc.Workers))
+		r.Backend = src.Name()
+		Report(r)
+		results = append(results, r)
+	}
+
+	r := runPhase(ctx, dst, "backfill", pc, runBackfill(src, ks, mc.Workers))
+	r.Backend = dst.Name()
+	Report(r)
+	last := r.Samples[len(r.Samples)-1]
+	fmt.Printf("backfill: %d keys in %s (%.0f keys/s)\n", last.OK, last.Elapsed.Round(time.Millisecond), float64(last.OK)/last.Elapsed.Seconds())
+	results = append(results, r)
+
+	r = runPhase(ctx, dst, "dual-read", pc, runDualRead(src, ks, mc.Workers))
+	r.Backend = dst.Name()
+	Report(r)
+	fmt.Printf("dual-read mismatches: %d\n", r.Stats.Anomalies())
+	results = append(results, r)
+
+	return results, nil
+}
+
+// Each migration worker owns the keys i, i+n, i+2n, ... of the keyspace and
+// returns once it has visited all of them.
+
+func runPopulate(ks *Keyspace, n int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		for k := i; k < ks.Len(); k += n {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+			err = store.Set(ctx, ks.keys[k], workerValue(k))
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func runBackfill(src kv.KV, ks *Keyspace, n int) Worker {
+	return func(ctx context.Context, dst kv.KV, i int, s *WorkerStats, p *Pacer) {
+		for k := i; k < ks.Len(); k += n {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+			v, err := src.Get(ctx, ks.keys[k])
+			if missingKey(err) {
+				// nothing to copy
+				s.OK(p.Since(start))
+				continue
+			}
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			err = dst.Set(ctx, ks.keys[k], v)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func runDualRead(src kv.KV, ks *Keyspace, n int) Worker {
+	return func(ctx context.Context, dst kv.KV, i int, s *WorkerStats, p *Pacer) {
+		for k := i; k < ks.Len(); k += n {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+			a, err := missingAsEmpty(src.Get(ctx, ks.keys[k]))
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			b, err := missingAsEmpty(dst.Get(ctx, ks.keys[k]))
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+			if a != b {
+				s.Anomaly(fmt.Errorf("dual-read %s: source %q, target %q", ks.keys[k], a, b))
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// MirrorStats times a mirrored phase's operations on each backend, to see
+// what dual writes during a migration cost the client.
+type MirrorStats struct {
+	Primary   string
+	Secondary string
+
+	PrimaryLatency   Histogram // every operation, reads included
+	SecondaryLatency Histogram // mirrored writes only
+
+	Errors   uint64 // mirrored writes the secondary failed
+	Diverged uint64 // SetNX, CompareAndSwap or Incr results that differ
+}
+
+// printMirror reports each backend's latency, and the client's overhead:
+// how much longer an operation took than on the primary alone.
+func printMirror(m *MirrorStats, latency *Histogram) {
+	for _, x := range []struct {
+		name string
+		h    *Histogram
+	}{{m.Primary, &m.PrimaryLatency}, {m.Secondary, &m.SecondaryLatency}} {
+		if x.h.Count() == 0 {
+			continue
+		}
+		fmt.Printf("mirror %s: n=%d mean=%s p50=%s p99=%s max=%s\n",
+			x.name,
+			x.h.Count(),
+			x.h.Mean(),
+			x.h.Quantile(0.5),
+			x.h.Quantile(0.99),
+			x.h.Max(),
+		)
+	}
+	fmt.Printf("mirror overhead: mean=%s p99=%s errors=%d diverged=%d\n",
+		latency.Mean()-m.PrimaryLatency.Mean(),
+		latency.Quantile(0.99)-m.PrimaryLatency.Quantile(0.99),
+		atomic.LoadUint64(&m.Errors),
+		atomic.LoadUint64(&m.Diverged),
+	)
+}
+
+// mirrorKV writes through to a secondary backend as well as next, the way
+// an application dual-writes while it migrates. Both writes run at once and
+// the operation returns when both are done, with next's result: the
+// secondary's errors are counted rather than failing the operation. Reads
+// and scans only go to next. The secondary outlives the phase, so Setup and
+// Close leave it alone.
+type mirrorKV struct {
+	next      kv.KV
+	secondary kv.KV
+	clock     Clock
+	stats     *MirrorStats
+}
+
+func NewMirrorKV(next, secondary kv.KV, clock Clock) *mirrorKV {
+	return &mirrorKV{
+		next:      next,
+		secondary: secondary,
+		clock:     clock,
+		stats:     &MirrorStats{Primary: next.Name(), Secondary: secondary.Name()},
+	}
+}
+
+// Stats returns the phase's mirrored operations so far.
+func (m *mirrorKV) Stats() *MirrorStats {
+	return m.stats
+}
+
+// primary runs op on next alone.
+func primary[T any](m *mirrorKV, op func() (T, error)) (T, error) {
+	start := m.clock.Now()
+	v, err := op()
+	m.stats.PrimaryLatency.Record(m.clock.Since(start))
+	return v, err
+}
+
+// mirrored runs op on next and mirror on the secondary concurrently.
+func mirrored[T comparable](m *mirrorKV, op, mirror func() (T, error)) (T, error) {
+	var (
+		mv   T
+		merr error
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		start := m.clock.Now()
+		mv, merr = mirror()
+		m.stats.SecondaryLatency.Record(m.clock.Since(start))
+	}()
+	v, err := primary(m, op)
+	<-done
+	if merr != nil {
+		atomic.AddUint64(&m.stats.Errors, 1)
+	} else if err == nil && mv != v {
+		atomic.AddUint64(&m.stats.Diverged, 1)
+	}
+	return v, err
+}
+
+// written adapts a write that only returns an error to mirrored.
+func written(op func() error) func() (struct{}, error) {
+	return func() (struct{}, error) {
+		return struct{}{}, op()
+	}
+}
+
+func (m *mirrorKV) Name() string {
+	return m.next.Name()
+}
+
+func (m *mirrorKV) Setup(ctx context.Context) error {
+	return m.next.Setup(ctx)
+}
+
+func (m *mirrorKV) Close(ctx context.Context) error {
+	return m.next.Close(ctx)
+}
+
+func (m *mirrorKV) Set(ctx context.Context, key, value string) error {
+	_, err := mirrored(m, written(func() error {
+		return m.next.Set(ctx, key, value)
+	}), written(func() error {
+		return m.secondary.Set(ctx, key, value)
+	}))
+	return err
+}
+
+func (m *mirrorKV) Get(ctx context.Context, key string) (string, error) {
+	return primary(m, func() (string, error) {
+		return m.next.Get(ctx, key)
+	})
+}
+
+func (m *mirrorKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := mirrored(m, written(func() error {
+		return m.next.SetBytes(ctx, key, value)
+	}), written(func() error {
+		return m.secondary.SetBytes(ctx, key, value)
+	}))
+	return err
+}
+
+func (m *mirrorKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return primary(m, func() ([]byte, error) {
+		return m.next.GetBytes(ctx, key)
+	})
+}
+
+func (m *mirrorKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := mirrored(m, written(func() error {
+		return m.next.SetTTL(ctx, key, value, ttl)
+	}), written(func() error {
+		return m.secondary.SetTTL(ctx, key, value, ttl)
+	}))
+	return err
+}
+
+func (m *mirrorKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return mirrored(m, func() (bool, error) {
+		return m.next.SetNX(ctx, key, value)
+	}, func() (bool, error) {
+		return m.secondary.SetNX(ctx, key, value)
+	})
+}
+
+func (m *mirrorKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return mirrored(m, func() (bool, error) {
+		return m.next.CompareAndSwap(ctx, key, old, new)
+	}, func() (bool, error) {
+		return m.secondary.CompareAndSwap(ctx, key, old, new)
+	})
+}
+
+func (m *mirrorKV) Incr(ctx context.Context, key string) (int64, error) {
+	return mirrored(m, func() (int64, error) {
+		return m.next.Incr(ctx, key)
+	}, func() (int64, error) {
+		return m.secondary.Incr(ctx, key)
+	})
+}
+
+func (m *mirrorKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return mirrored(m, func() (int64, error) {
+		return windowCounterOf(m.next).IncrWindow(ctx, key, ttl)
+	}, func() (int64, error) {
+		return windowCounterOf(m.secondary).IncrWindow(ctx, key, ttl)
+	})
+}
+
+func (m *mirrorKV) Push(ctx context.Context, queue, value string) error {
+	_, err := mirrored(m, written(func() error {
+		return queueOf(m.next).Push(ctx, queue, value)
+	}), written(func() error {
+		return queueOf(m.secondary).Push(ctx, queue, value)
+	}))
+	return err
+}
+
+// Pop pops the secondary's copy of the queue too, so it doesn't grow
+// without bound.
+func (m *mirrorKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	p, err := mirrored(m, func() (popped, error) {
+		return pop(ctx, m.next, queue, timeout)
+	}, func() (popped, error) {
+		return pop(ctx, m.secondary, queue, timeout)
+	})
+	return p.value, p.ok, err
+}
+
+func (m *mirrorKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return primary(m, func() ([]string, error) {
+		return m.next.Scan(ctx, prefix, limit)
+	})
+}
+
+func (m *mirrorKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := mirrored(m, written(func() error {
+		return m.next.TxnSet(ctx, kvs)
+	}), written(func() error {
+		return m.secondary.TxnSet(ctx, kvs)
+	}))
+	return err
+}
+
+func (m *mirrorKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	_, err := mirrored(m, written(func() error {
+		return m.next.BulkLoad(ctx, kvs)
+	}), written(func() error {
+		return m.secondary.BulkLoad(ctx, kvs)
+	}))
+	return err
+}
@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// LookupStats splits a phase's read latencies by whether the key existed.
+// A negative lookup can cost very differently from a hit: Postgres still
+// probes the index, while Redis answers from its hash table without
+// touching a value.
+type LookupStats struct {
+	Hit  Histogram
+	Miss Histogram
+}
+
+// LookupData is the serializable form of LookupStats.
+type LookupData struct {
+	Hit  HistogramData `json:"hit"`
+	Miss HistogramData `json:"miss"`
+}
+
+func (l *LookupStats) Export() *LookupData {
+	return &LookupData{Hit: l.Hit.Export(), Miss: l.Miss.Export()}
+}
+
+func (l *LookupStats) Import(d *LookupData) {
+	l.Hit.Import(d.Hit)
+	l.Miss.Import(d.Miss)
+}
+
+func (l *LookupStats) Merge(o *LookupStats) {
+	l.Hit.Merge(&o.Hit)
+	l.Miss.Merge(&o.Miss)
+}
+
+func printLookups(l *LookupStats) {
+	for _, x := range []struct {
+		name string
+		h    *Histogram
+	}{{"hit", &l.Hit}, {"miss", &l.Miss}} {
+		if x.h.Count() == 0 {
+			continue
+		}
+		fmt.Printf("%s latency: n=%d mean=%s p50=%s p99=%s max=%s\n",
+			x.name,
+			x.h.Count(),
+			x.h.Mean(),
+			x.h.Quantile(0.5),
+			x.h.Quantile(0.99),
+			x.h.Max(),
+		)
+	}
+}
+
+// missingKey reports whether a Get failed because the key doesn't exist.
+func missingKey(err error) bool {
+	return errors.Is(err, kv.ErrNotFound)
+}
+
+// missingAsEmpty reads a missing key as an empty value, for checks that
+// report one as a lost write rather than a failed read.
+func missingAsEmpty(v string, err error) (string, error) {
+	if missingKey(err) {
+		return "", nil
+	}
+	return v, err
+}
+
+// runGetMiss reads the worker's key, or with probability ratio a key that
+// is never written, so every backend is asked for keys it doesn't have at
+// the same rate. Miss keys are unique per operation, so no layer can cache
+// the negative answer.
+func runGetMiss(ratio float64, seed int64) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		rnd := workerRand(seed, i)
+		key := workerKey(i)
+		value := workerValue(i)
+		changed := value + "#"
+		missPrefix := "miss_" + strconv.Itoa(i) + "_"
+
+		for seq := 0; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			if rnd.Float64() < ratio {
+				v, err := store.Get(ctx, missPrefix+strconv.Itoa(seq))
+				switch {
+				case missingKey(err):
+					s.OKLookup(p.Since(start), false)
+				case err != nil:
+					s.Err(err)
+				default:
+					s.Err(mismatchf("unexpected value for a missing key: %s", v))
+				}
+				continue
+			}
+
+			v, err := store.Get(ctx, key)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			if v != value && !strings.HasPrefix(v, changed) {
+				s.Err(mismatchf("unexpected value: %s", v))
+				continue
+			}
+			s.OKLookup(p.Since(start), true)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// OpEntry is one line of an operation trace, a JSON-lines file. A line with
+// Phase starts a segment, one run of a phase; the lines with Op after it are
+// the operations the segment issued. Values aren't kept, only their sizes,
+// so traces stay small and free of production data; replay writes filler
+// values of the same sizes.
+type OpEntry struct {
+	Phase   string `json:"phase,omitempty"`
+	Workers int    `json:"workers,omitempty"`
+
+	At      time.Duration `json:"at,omitempty"` // issue time from the segment's start
+	Op      string        `json:"op,omitempty"`
+	Key     string        `json:"key,omitempty"`  // the prefix for scan
+	Keys    []string      `json:"keys,omitempty"` // for txn_set and bulk_load
+	Size    int           `json:"size,omitempty"` // value bytes, the mean for Keys
+	OldSize int           `json:"old_size,omitempty"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+	Limit   int           `json:"limit,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"` // for pop
+}
+
+// OpRecorder writes the operations of every phase it wraps to a trace file.
+// Operations from all workers go through one lock, which costs some
+// throughput, so record with the load you want to replay rather than
+// measuring the recording run.
+type OpRecorder struct {
+	clock Clock
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func NewOpRecorder(path string, clock Clock) (*OpRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &OpRecorder{clock: clock, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (r *OpRecorder) write(e *OpEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// Wrap starts a segment for a run of phase with the given workers and
+// returns kv recording into it.
+func (r *OpRecorder) Wrap(store kv.KV, phase string, workers int) kv.KV {
+	r.write(&OpEntry{Phase: phase, Workers: workers})
+	return &recordingKV{next: store, rec: r, start: r.clock.Now()}
+}
+
+// Close flushes the trace and returns the first error writing it.
+func (r *OpRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// recordingKV appends each operation to the trace as it is issued, before
+// passing it to next.
+type recordingKV struct {
+	next  kv.KV
+	rec   *OpRecorder
+	start time.Time
+}
+
+func (k *recordingKV) record(e OpEntry) {
+	e.At = k.rec.clock.Since(k.start)
+	k.rec.write(&e)
+}
+
+func (k *recordingKV) Name() string {
+	return k.next.Name()
+}
+
+func (k *recordingKV) Setup(ctx context.Context) error {
+	return k.next.Setup(ctx)
+}
+
+func (k *recordingKV) Close(ctx context.Context) error {
+	return k.next.Close(ctx)
+}
+
+func (k *recordingKV) Set(ctx context.Context, key, value string) error {
+	k.record(OpEntry{Op: "set", Key: key, Size: len(value)})
+	return k.next.Set(ctx, key, value)
+}
+
+func (k *recordingKV) Get(ctx context.Context, key string) (string, error) {
+	k.record(OpEntry{Op: "get", Key: key})
+	return k.next.Get(ctx, key)
+}
+
+func (k *recordingKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	k.record(OpEntry{Op: "set_bytes", Key: key, Size: len(value)})
+	return k.next.SetBytes(ctx, key, value)
+}
+
+func (k *recordingKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	k.record(OpEntry{Op: "get_bytes", Key: key})
+	return k.next.GetBytes(ctx, key)
+}
+
+func (k *recordingKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	k.record(OpEntry{Op: "set_ttl", Key: key, Size: len(value), TTL: ttl})
+	return k.next.SetTTL(ctx, key, value, ttl)
+}
+
+func (k *recordingKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	k.record(OpEntry{Op: "setnx", Key: key, Size: len(value)})
+	return k.next.SetNX(ctx, key, value)
+}
+
+func (k *recordingKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	k.record(OpEntry{Op: "cas", Key: key, Size: len(new), OldSize: len(old)})
+	return k.next.CompareAndSwap(ctx, key, old, new)
+}
+
+func (k *recordingKV) Incr(ctx context.Context, key string) (int64, error) {
+	k.record(OpEntry{Op: "incr", Key: key})
+	return k.next.Incr(ctx, key)
+}
+
+func (k *recordingKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	k.record(OpEntry{Op: "incr_window", Key: key, TTL: ttl})
+	return windowCounterOf(k.next).IncrWindow(ctx, key, ttl)
+}
+
+func (k *recordingKV) Push(ctx context.Context, queue, value string) error {
+	k.record(OpEntry{Op: "push", Key: queue, Size: len(value)})
+	return queueOf(k.next).Push(ctx, queue, value)
+}
+
+func (k *recordingKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	k.record(OpEntry{Op: "pop", Key: queue, Timeout: timeout})
+	return queueOf(k.next).Pop(ctx, queue, timeout)
+}
+
+func (k *recordingKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	k.record(OpEntry{Op: "scan", Key: prefix, Limit: limit})
+	return k.next.Scan(ctx, prefix, limit)
+}
+
+func (k *recordingKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	k.record(batchEntry("txn_set", kvs))
+	return k.next.TxnSet(ctx, kvs)
+}
+
+func (k *recordingKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	k.record(batchEntry("bulk_load", kvs))
+	return k.next.BulkLoad(ctx, kvs)
+}
+
+func batchEntry(op string, kvs map[string]string) OpEntry {
+	e := OpEntry{Op: op, Keys: make([]string, 0, len(kvs))}
+	var size int
+	for key, v := range kvs {
+		e.Keys = append(e.Keys, key)
+		size += len(v)
+	}
+	sort.Strings(e.Keys)
+	if len(kvs) > 0 {
+		e.Size = size / len(kvs)
+	}
+	return e
+}
+
+// loadReplay builds a phase per segment of the trace at path, named
+// "replay-<phase>" and run with the recorded workers. Each worker replays
+// the operations on the keys hashed to it in their recorded order, so
+// operations on one key never overtake each other. With pace "original",
+// each operation is issued at its recorded offset from the phase start and
+// its latency measured from then; with "max", as fast as the backend
+// allows. A replay phase runs until its operations are done, regardless of
+// -duration.
+func loadReplay(path, pace string) ([]Phase, error) {
+	switch pace {
+	case "original", "max":
+	default:
+		return nil, fmt.Errorf("invalid replay pace: %s", pace)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		ps      []Phase
+		name    string
+		workers int
+		ops     []OpEntry
+	)
+	flush := func() {
+		if name == "" {
+			return
+		}
+		if workers <= 0 {
+			workers = 1
+		}
+		ps = append(ps, Phase{
+			Name:      "replay-" + name,
+			Run:       runReplay(partitionOps(ops, workers), pace == "original"),
+			workers:   workers,
+			unbounded: true,
+		})
+		ops = nil
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for line := 1; ; line++ {
+		var e OpEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trace %s: entry %d: %w", path, line, err)
+		}
+		switch {
+		case e.Phase != "":
+			flush()
+			name, workers = e.Phase, e.Workers
+		case name == "":
+			return nil, fmt.Errorf("trace %s: entry %d: operation outside a phase", path, line)
+		default:
+			ops = append(ops, e)
+		}
+	}
+	flush()
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("trace %s: no phases", path)
+	}
+	return ps, nil
+}
+
+// partitionOps splits ops into one list per worker by key.
+func partitionOps(ops []OpEntry, workers int) [][]OpEntry {
+	parts := make([][]OpEntry, workers)
+	for _, e := range ops {
+		key := e.Key
+		if key == "" && len(e.Keys) > 0 {
+			key = e.Keys[0]
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		i := int(h.Sum32() % uint32(workers))
+		parts[i] = append(parts[i], e)
+	}
+	return parts
+}
+
+func runReplay(parts [][]OpEntry, original bool) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		if i >= len(parts) {
+			return
+		}
+		values := make(map[int]string)
+		value := func(size int) string {
+			v, ok := values[size]
+			if !ok {
+				v = fillValue(size)
+				values[size] = v
+			}
+			return v
+		}
+
+		phaseStart := p.Now()
+		for _, e := range parts[i] {
+			var start time.Time
+			var err error
+			if original {
+				start, err = p.WaitUntil(ctx, phaseStart.Add(e.At))
+			} else {
+				start, err = p.Wait(ctx)
+			}
+			if err != nil {
+				return
+			}
+
+			err = replayOp(ctx, store, &e, value)
+			if err != nil && !missingKey(err) {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func replayOp(ctx context.Context, store kv.KV, e *OpEntry, value func(int) string) error {
+	var err error
+	switch e.Op {
+	case "set":
+		err = store.Set(ctx, e.Key, value(e.Size))
+	case "get":
+		_, err = store.Get(ctx, e.Key)
+	case "set_bytes":
+		err = store.SetBytes(ctx, e.Key, []byte(value(e.Size)))
+	case "get_bytes":
+		_, err = store.GetBytes(ctx, e.Key)
+	case "set_ttl":
+		err = store.SetTTL(ctx, e.Key, value(e.Size), e.TTL)
+	case "setnx":
+		_, err = store.SetNX(ctx, e.Key, value(e.Size))
+	case "cas":
+		_, err = store.CompareAndSwap(ctx, e.Key, value(e.OldSize), value(e.Size))
+	case "incr":
+		_, err = store.Incr(ctx, e.Key)
+	case "incr_window":
+		_, err = windowCounterOf(store).IncrWindow(ctx, e.Key, e.TTL)
+	case "push":
+		err = queueOf(store).Push(ctx, e.Key, value(e.Size))
+	case "pop":
+		_, _, err = queueOf(store).Pop(ctx, e.Key, e.Timeout)
+	case "scan":
+		_, err = store.Scan(ctx, e.Key, e.Limit)
+	case "txn_set", "bulk_load":
+		kvs := make(map[string]string, len(e.Keys))
+		for _, key := range e.Keys {
+			kvs[key] = value(e.Size)
+		}
+		if e.Op == "txn_set" {
+			err = store.TxnSet(ctx, kvs)
+		} else {
+			err = store.BulkLoad(ctx, kvs)
+		}
+	default:
+		err = fmt.Errorf("unknown traced operation: %s", e.Op)
+	}
+	return err
+}
@@ -0,0 +1,283 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// Worker is the loop of one of a phase's workers: it runs operations on
+// store, each after p.Wait, and records them in s until ctx is done or it
+// has nothing left to do. i numbers the worker within the phase.
+type Worker func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer)
+
+// Phase is one measured workload, run by PhaseRunner.Run.
+type Phase struct {
+	Name string
+	Run  Worker
+
+	// wrap, if set, adapts the backend for this phase; the phase is skipped
+	// when it reports the backend doesn't support it.
+	wrap func(store kv.KV) (kv.KV, bool)
+
+	// supports, if set, reports whether the backend has the interface the
+	// phase's workers use; the phase is skipped when it doesn't.
+	supports func(store kv.KV) bool
+
+	// newRun, if set, replaces Run with a worker built fresh for each run
+	// of the phase, for workers that share state within a run.
+	newRun func() Worker
+
+	// unbounded phases run until their workers return, however long the
+	// configured duration
+	unbounded bool
+
+	// Batch is the number of keys each operation writes, if more than one
+	Batch int
+
+	// per-phase overrides of the global settings; zero keeps the global value
+	workers  int
+	duration time.Duration
+	rate     float64
+}
+
+// PhaseOptions selects the phases to run. It is serializable so remote
+// agents can build the same phases as the coordinator.
+type PhaseOptions struct {
+	SetValues      string    `json:"set_values"`
+	DuplicateRatio float64   `json:"duplicate_ratio"`
+	Reconnect      bool      `json:"reconnect"`
+	TLSResumption  bool      `json:"tls_resumption"`
+	Atomic         bool      `json:"atomic"`
+	Counters       int       `json:"counters"`
+	RMWKeys        int       `json:"rmw_keys"`
+	RMWCAS         bool      `json:"rmw_cas"`
+	MissRatio      float64   `json:"miss_ratio"` // share of get-miss reads of absent keys
+	ScanKeys       int       `json:"scan_keys"`
+	TxnKeys        int       `json:"txn_keys"`
+	BulkKeys       int       `json:"bulk_keys"`
+	BinaryValues   int       `json:"binary_values"` // value size in bytes
+	StreamValues   int64     `json:"stream_values"` // streamed value size in bytes
+	StreamChunk    int       `json:"stream_chunk"`
+	RecordCodecs   []string  `json:"record_codecs"`
+	Verify         bool      `json:"verify"`
+	Queue          bool      `json:"queue"`
+	Grow           bool      `json:"grow"`
+	Seed           int64     `json:"seed"`               // seeds every worker's random choices
+	Scenario       *Scenario `json:"scenario,omitempty"` // replaces the built-in phases
+
+	// Replay, if set, is an operation trace to replay instead of the
+	// built-in phases, at ReplayPace "original" or "max". Agents read it
+	// from their own disk.
+	Replay     string `json:"replay,omitempty"`
+	ReplayPace string `json:"replay_pace,omitempty"`
+
+	// Overrides sets phases' own workers, duration and rate by phase name,
+	// over the run's and the scenario's.
+	Overrides map[string]PhaseOverride `json:"overrides,omitempty"`
+}
+
+// PhaseOverride is a phase's own settings; zero keeps the run's.
+type PhaseOverride struct {
+	Workers  int           `json:"workers,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Rate     float64       `json:"rate,omitempty"` // per worker, like -rate
+}
+
+// ParsePhaseOverride parses name:setting=value,..., e.g.
+// "get:workers=50,duration=10s,rate=1000".
+func ParsePhaseOverride(s string) (string, PhaseOverride, error) {
+	var o PhaseOverride
+	name, settings, ok := strings.Cut(s, ":")
+	if !ok || name == "" {
+		return "", o, fmt.Errorf("invalid phase settings %q, want name:setting=value,...", s)
+	}
+	for _, f := range strings.Split(settings, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok {
+			return "", o, fmt.Errorf("invalid phase setting: %s (want setting=value)", f)
+		}
+		var err error
+		switch k {
+		case "workers":
+			o.Workers, err = strconv.Atoi(v)
+			if err == nil && o.Workers <= 0 {
+				err = fmt.Errorf("want at least 1")
+			}
+		case "duration":
+			o.Duration, err = time.ParseDuration(v)
+			if err == nil && o.Duration <= 0 {
+				err = fmt.Errorf("want more than 0")
+			}
+		case "rate":
+			o.Rate, err = strconv.ParseFloat(v, 64)
+			if err == nil && o.Rate < 0 {
+				err = fmt.Errorf("want 0 or more")
+			}
+		default:
+			return "", o, fmt.Errorf("unknown phase setting: %s (want workers, duration or rate)", k)
+		}
+		if err != nil {
+			return "", o, fmt.Errorf("invalid phase %s %s: %s: %w", name, k, v, err)
+		}
+	}
+	return name, o, nil
+}
+
+func (o PhaseOptions) Build() ([]Phase, error) {
+	var ps []Phase
+	var err error
+	switch {
+	case o.Replay != "":
+		ps, err = loadReplay(o.Replay, o.ReplayPace)
+	case o.Scenario != nil:
+		ps, err = o.Scenario.buildPhases(o.Seed)
+	default:
+		ps, err = buildPhases(o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return applyOverrides(ps, o.Overrides)
+}
+
+func applyOverrides(ps []Phase, overrides map[string]PhaseOverride) ([]Phase, error) {
+	for name, o := range overrides {
+		found := false
+		for i := range ps {
+			if ps[i].Name != name {
+				continue
+			}
+			found = true
+			if o.Workers > 0 {
+				ps[i].workers = o.Workers
+			}
+			if o.Duration > 0 {
+				ps[i].duration = o.Duration
+			}
+			if o.Rate > 0 {
+				ps[i].rate = o.Rate
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no phase %s to apply settings to", name)
+		}
+	}
+	return ps, nil
+}
+
+// buildPhases returns the phases to run. Changing writes run before identical
+// ones so the keyspace ends up in the state the get phase verifies.
+func buildPhases(o PhaseOptions) ([]Phase, error) {
+	var ps []Phase
+	if o.DuplicateRatio > 0 {
+		ps = append(ps, Phase{Name: "set-duplicate", Run: runSetDuplicate(o.DuplicateRatio, o.Seed)})
+	}
+	switch o.SetValues {
+	case "same":
+		ps = append(ps, Phase{Name: "set", Run: runSet})
+	case "changing":
+		ps = append(ps, Phase{Name: "set-changing", Run: runSetChanging})
+	case "both":
+		ps = append(ps, Phase{Name: "set-changing", Run: runSetChanging}, Phase{Name: "set", Run: runSet})
+	default:
+		return nil, fmt.Errorf("invalid -set-values: %s", o.SetValues)
+	}
+	ps = append(ps, Phase{Name: "get", Run: runGet})
+	if o.MissRatio > 0 {
+		ps = append(ps, Phase{Name: "get-miss", Run: runGetMiss(o.MissRatio, o.Seed)})
+	}
+	if o.BinaryValues > 0 {
+		ps = append(ps,
+			Phase{Name: "set-bytes", Run: runSetBytes(o.BinaryValues)},
+			Phase{Name: "get-bytes", Run: runGetBytes(o.BinaryValues)},
+		)
+	}
+	if o.StreamValues > 0 {
+		if o.StreamChunk <= 0 {
+			return nil, fmt.Errorf("invalid -stream-chunk: %d", o.StreamChunk)
+		}
+		ps = append(ps,
+			Phase{Name: "set-stream", Run: runSetStream(o.StreamValues, o.StreamChunk)},
+			Phase{Name: "get-stream", Run: runGetStream(o.StreamValues, o.StreamChunk)},
+		)
+	}
+	for _, name := range o.RecordCodecs {
+		c, ok := recordCodecs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown record codec: %s", name)
+		}
+		ps = append(ps,
+			Phase{Name: "set-record-" + name, Run: runSetRecord(c, o.Seed)},
+			Phase{Name: "get-record-" + name, Run: runGetRecord(c, o.Seed)},
+		)
+	}
+	if o.Atomic {
+		ps = append(ps,
+			Phase{Name: "setnx", newRun: newSetNX(o.Seed)},
+			Phase{Name: "cas-lock", Run: runCASLock},
+		)
+	}
+	if o.Counters > 0 {
+		ps = append(ps, Phase{Name: "incr", Run: runIncr(o.Counters)})
+	}
+	if o.RMWKeys > 0 {
+		ps = append(ps, Phase{Name: "rmw", Run: runRMW(o.RMWKeys, false)})
+		if o.RMWCAS {
+			ps = append(ps, Phase{Name: "rmw-cas", Run: runRMW(o.RMWKeys, true)})
+		}
+	}
+	if o.ScanKeys > 0 {
+		ps = append(ps, Phase{Name: "scan", Run: runScan(o.ScanKeys)})
+	}
+	if o.TxnKeys > 0 {
+		ps = append(ps, Phase{Name: "txn-set", Run: runTxnSet(o.TxnKeys)})
+	}
+	if o.BulkKeys > 0 {
+		ps = append(ps, Phase{Name: "bulk-load", newRun: newBulkLoad(o.BulkKeys, o.Seed), Batch: o.BulkKeys})
+	}
+	if o.Verify {
+		ps = append(ps, Phase{Name: "verify", newRun: newVerify})
+	}
+	if o.Grow {
+		ps = append(ps, Phase{Name: "grow", newRun: newGrow(o.Seed)})
+	}
+	if o.Queue {
+		ps = append(ps, Phase{Name: "queue", newRun: newQueueRun(o.Seed), supports: supportsQueue})
+	}
+	if o.Reconnect {
+		ps = append(ps,
+			Phase{Name: "set-reconnect", Run: runSet, wrap: reconnect},
+			Phase{Name: "get-reconnect", Run: runGet, wrap: reconnect},
+		)
+	}
+	if o.TLSResumption {
+		ps = append(ps,
+			Phase{Name: "get-tls-full", Run: runGet, wrap: reconnectTLS(false)},
+			Phase{Name: "get-tls-resumed", Run: runGet, wrap: reconnectTLS(true)},
+		)
+	}
+	return ps, nil
+}
+
+func reconnect(store kv.KV) (kv.KV, bool) {
+	r, ok := store.(kv.Reconnector)
+	if !ok {
+		return nil, false
+	}
+	return r.Reconnect()
+}
+
+func reconnectTLS(resume bool) func(kv.KV) (kv.KV, bool) {
+	return func(store kv.KV) (kv.KV, bool) {
+		t, ok := store.(kv.TLSReconnector)
+		if !ok {
+			return nil, false
+		}
+		return t.ReconnectTLS(resume)
+	}
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePhaseOverride(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		name string
+		want PhaseOverride
+	}{
+		{"get:workers=50", "get", PhaseOverride{Workers: 50}},
+		{"set:duration=10s,rate=1000", "set", PhaseOverride{Duration: 10 * time.Second, Rate: 1000}},
+		{"get:workers=50, duration=1m, rate=0", "get", PhaseOverride{Workers: 50, Duration: time.Minute}},
+	} {
+		name, o, err := ParsePhaseOverride(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if name != tc.name || o != tc.want {
+			t.Errorf("%s: got %s %+v, want %s %+v", tc.in, name, o, tc.name, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		in, err string
+	}{
+		{"get", "want name:setting=value"},
+		{":workers=5", "want name:setting=value"},
+		{"get:workers", "want setting=value"},
+		{"get:", "want setting=value"},
+		{"get:threads=5", "unknown phase setting: threads"},
+		{"get:workers=0", "want at least 1"},
+		{"get:workers=-3", "want at least 1"},
+		{"get:workers=many", "invalid phase get workers"},
+		{"get:duration=0s", "want more than 0"},
+		{"get:duration=-1s", "want more than 0"},
+		{"get:duration=10", "invalid phase get duration"},
+		{"get:rate=-1", "want 0 or more"},
+	} {
+		_, _, err := ParsePhaseOverride(tc.in)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: err = %v, want one containing %q", tc.in, err, tc.err)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	o := PhaseOptions{
+		SetValues: "both",
+		Overrides: map[string]PhaseOverride{
+			"get": {Workers: 5, Duration: time.Second},
+			"set": {Rate: 100},
+		},
+	}
+	ps, err := o.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := 0
+	for _, ph := range ps {
+		got := PhaseOverride{Workers: ph.workers, Duration: ph.duration, Rate: ph.rate}
+		if got != o.Overrides[ph.Name] {
+			t.Errorf("%s: settings %+v, want %+v", ph.Name, got, o.Overrides[ph.Name])
+		}
+		if _, ok := o.Overrides[ph.Name]; ok {
+			seen++
+		}
+	}
+	if seen != len(o.Overrides) {
+		t.Errorf("overrides reached %d phases, want %d", seen, len(o.Overrides))
+	}
+
+	o.Overrides = map[string]PhaseOverride{"gte": {Workers: 5}}
+	_, err = o.Build()
+	if err == nil || !strings.Contains(err.Error(), "no phase gte") {
+		t.Errorf("override of an unknown phase: err = %v", err)
+	}
+}
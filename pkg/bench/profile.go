@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+)
+
+// ServePprof listens on addr and serves net/http/pprof in the background.
+func ServePprof(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go http.Serve(lis, nil)
+	return nil
+}
+
+// startPhaseProfile starts a CPU profile for one phase in dir. The returned
+// func stops it and writes a heap profile taken at the end of the phase.
+func startPhaseProfile(dir, name string) (func() error, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	cpu, err := os.Create(filepath.Join(dir, name+".cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	err = pprof.StartCPUProfile(cpu)
+	if err != nil {
+		cpu.Close()
+		return nil, err
+	}
+
+	return func() error {
+		pprof.StopCPUProfile()
+		err := cpu.Close()
+		if err != nil {
+			return err
+		}
+
+		heap, err := os.Create(filepath.Join(dir, name+".heap.pprof"))
+		if err != nil {
+			return err
+		}
+		defer heap.Close()
+		runtime.GC()
+		err = pprof.WriteHeapProfile(heap)
+		if err != nil {
+			return fmt.Errorf("write heap profile: %w", err)
+		}
+		return heap.Close()
+	}, nil
+}
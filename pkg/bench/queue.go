@@ -0,0 +1,168 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// queuePopTimeout bounds how long a pop waits on an empty queue, so
+// consumers notice the end of the phase.
+const queuePopTimeout = 100 * time.Millisecond
+
+// QueueStats splits a queue phase's operations into pushes and pops, and
+// times how long each value waited in the queue.
+type QueueStats struct {
+	Push  Histogram
+	Pop   Histogram
+	Lag   Histogram // from push to pop
+	Empty uint64    // pops that timed out on an empty queue
+}
+
+// QueueData is the serializable form of QueueStats.
+type QueueData struct {
+	Push  HistogramData `json:"push"`
+	Pop   HistogramData `json:"pop"`
+	Lag   HistogramData `json:"lag"`
+	Empty uint64        `json:"empty"`
+}
+
+func (q *QueueStats) Export() *QueueData {
+	return &QueueData{Push: q.Push.Export(), Pop: q.Pop.Export(), Lag: q.Lag.Export(), Empty: atomic.LoadUint64(&q.Empty)}
+}
+
+func (q *QueueStats) Import(d *QueueData) {
+	q.Push.Import(d.Push)
+	q.Pop.Import(d.Pop)
+	q.Lag.Import(d.Lag)
+	atomic.AddUint64(&q.Empty, d.Empty)
+}
+
+func (q *QueueStats) Merge(o *QueueStats) {
+	q.Push.Merge(&o.Push)
+	q.Pop.Merge(&o.Pop)
+	q.Lag.Merge(&o.Lag)
+	atomic.AddUint64(&q.Empty, atomic.LoadUint64(&o.Empty))
+}
+
+func printQueue(q *QueueStats) {
+	for _, x := range []struct {
+		name string
+		h    *Histogram
+	}{{"push", &q.Push}, {"pop", &q.Pop}, {"queue lag", &q.Lag}} {
+		if x.h.Count() == 0 {
+			continue
+		}
+		fmt.Printf("%s latency: n=%d mean=%s p50=%s p99=%s max=%s\n",
+			x.name,
+			x.h.Count(),
+			x.h.Mean(),
+			x.h.Quantile(0.5),
+			x.h.Quantile(0.99),
+			x.h.Max(),
+		)
+	}
+	if q.Empty > 0 {
+		fmt.Printf("empty pops: %d (timed out after %s)\n", q.Empty, queuePopTimeout)
+	}
+}
+
+var errNoQueue = errors.New("backend has no queue")
+
+// supportsQueue reports whether the backend can run the queue phase.
+func supportsQueue(store kv.KV) bool {
+	_, ok := store.(kv.Queue)
+	return ok
+}
+
+// queueOf returns kv's queue, or one failing with errNoQueue. The phase
+// decorators are queues too, passing Push and Pop on through it.
+func queueOf(store kv.KV) kv.Queue {
+	if q, ok := store.(kv.Queue); ok {
+		return q
+	}
+	return noQueue{}
+}
+
+type noQueue struct{}
+
+func (noQueue) Push(ctx context.Context, queue, value string) error {
+	return errNoQueue
+}
+
+func (noQueue) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	return "", false, errNoQueue
+}
+
+// popped is a Pop's result as one value, for the decorators' helpers that
+// return one.
+type popped struct {
+	value string
+	ok    bool
+}
+
+func pop(ctx context.Context, store kv.KV, queue string, timeout time.Duration) (popped, error) {
+	v, ok, err := queueOf(store).Pop(ctx, queue, timeout)
+	return popped{value: v, ok: ok}, err
+}
+
+// newQueueRun returns a queue phase worker with a queue of its own, so
+// values left over from an earlier run don't show up as lag. Every worker
+// pushes and pops in turn, keeping the queue short. The run remembers when
+// it pushed each value, so the queue is per run: agents each get their own
+// and lag is only measured on one clock.
+func newQueueRun(seed int64) func() Worker {
+	return func() Worker {
+		queue := strings.TrimSuffix(runPrefix("kvperf_queue", seed), "_")
+		var pushed sync.Map // value -> time.Time it was pushed
+		return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+			q, ok := store.(kv.Queue)
+			if !ok {
+				s.Err(errNoQueue)
+				return
+			}
+			values := newSeqString("v", i)
+			for seq := 0; ; seq++ {
+				start, err := p.Wait(ctx)
+				if err != nil {
+					return
+				}
+
+				if seq%2 == 0 {
+					v := values.Next(seq)
+					pushed.Store(v, time.Now())
+					err = q.Push(ctx, queue, v)
+					if err != nil {
+						pushed.Delete(v)
+						s.Err(err)
+						continue
+					}
+					s.OKPush(p.Since(start))
+					continue
+				}
+
+				v, ok, err := q.Pop(ctx, queue, queuePopTimeout)
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+				var lag time.Duration
+				if ok {
+					at, found := pushed.LoadAndDelete(strings.TrimSpace(v))
+					if !found {
+						s.Err(mismatchf("unexpected queue value: %s", v))
+						continue
+					}
+					lag = time.Since(at.(time.Time))
+				}
+				s.OKPop(p.Since(start), ok, lag)
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// SessionRecord is the structured value the record phases store: a web
+// session as a typical session store keeps it.
+type SessionRecord struct {
+	ID         string            `json:"id" msgpack:"id"`
+	UserID     int64             `json:"user_id" msgpack:"user_id"`
+	CreatedAt  int64             `json:"created_at" msgpack:"created_at"` // unix ms
+	ExpiresAt  int64             `json:"expires_at" msgpack:"expires_at"` // unix ms
+	IP         string            `json:"ip" msgpack:"ip"`
+	UserAgent  string            `json:"user_agent" msgpack:"user_agent"`
+	Roles      []string          `json:"roles" msgpack:"roles"`
+	Attributes map[string]string `json:"attributes" msgpack:"attributes"`
+	Cart       []CartItem        `json:"cart" msgpack:"cart"`
+}
+
+type CartItem struct {
+	SKU        string `json:"sku" msgpack:"sku"`
+	Quantity   int32  `json:"quantity" msgpack:"quantity"`
+	PriceCents int64  `json:"price_cents" msgpack:"price_cents"`
+}
+
+// sessionEpoch stands in for the current time in seeded runs, so their
+// records, and the bytes stored, don't depend on when the run started.
+var sessionEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sessionTime is the time a worker's seq'th write happens at as far as its
+// record is concerned: the wall clock, or with a seed one millisecond a
+// write after sessionEpoch.
+func sessionTime(seed int64, seq int) time.Time {
+	if seed == 0 {
+		return time.Now()
+	}
+	return sessionEpoch.Add(time.Duration(seq) * time.Millisecond)
+}
+
+func newSessionRecord(i int, at time.Time) *SessionRecord {
+	now := at.UnixMilli()
+	return &SessionRecord{
+		ID:        fmt.Sprintf("sess_%08d_%x", i, now),
+		UserID:    int64(100000 + i),
+		CreatedAt: now,
+		ExpiresAt: now + int64(30*time.Minute/time.Millisecond),
+		IP:        "10.0." + strconv.Itoa(i/256%256) + "." + strconv.Itoa(i%256),
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36",
+		Roles:     []string{"user", "beta"},
+		Attributes: map[string]string{
+			"locale":   "en-US",
+			"theme":    "dark",
+			"currency": "USD",
+			"csrf":     fmt.Sprintf("%016x", now*int64(i+1)),
+		},
+		Cart: []CartItem{
+			{SKU: "SKU-" + strconv.Itoa(1000+i%100), Quantity: 1, PriceCents: 1999},
+			{SKU: "SKU-" + strconv.Itoa(2000+i%50), Quantity: 2, PriceCents: 4500},
+			{SKU: "SKU-3000", Quantity: 1, PriceCents: 120},
+		},
+	}
+}
+
+// recordCodec encodes SessionRecords to bytes.
+type recordCodec struct {
+	marshal   func(r *SessionRecord) ([]byte, error)
+	unmarshal func(b []byte, r *SessionRecord) error
+}
+
+var recordCodecs = map[string]recordCodec{
+	"json": {
+		marshal:   func(r *SessionRecord) ([]byte, error) { return json.Marshal(r) },
+		unmarshal: func(b []byte, r *SessionRecord) error { return json.Unmarshal(b, r) },
+	},
+	"msgpack": {
+		marshal:   func(r *SessionRecord) ([]byte, error) { return msgpack.Marshal(r) },
+		unmarshal: func(b []byte, r *SessionRecord) error { return msgpack.Unmarshal(b, r) },
+	},
+	"protobuf": {
+		marshal:   func(r *SessionRecord) ([]byte, error) { return r.appendProto(nil), nil },
+		unmarshal: func(b []byte, r *SessionRecord) error { return r.parseProto(b) },
+	},
+}
+
+// The protobuf codec writes the wire format of this message by hand with
+// protowire, as generated code would, rather than pulling in protoc:
+//
+//	message SessionRecord {
+//	  string id = 1;
+//	  int64 user_id = 2;
+//	  int64 created_at = 3;
+//	  int64 expires_at = 4;
+//	  string ip = 5;
+//	  string user_agent = 6;
+//	  repeated string roles = 7;
+//	  map<string, string> attributes = 8;
+//	  repeated CartItem cart = 9;
+//	}
+//	message CartItem {
+//	  string sku = 1;
+//	  int32 quantity = 2;
+//	  int64 price_cents = 3;
+//	}
+func (r *SessionRecord) appendProto(b []byte) []byte {
+	b = appendProtoString(b, 1, r.ID)
+	b = appendProtoVarint(b, 2, uint64(r.UserID))
+	b = appendProtoVarint(b, 3, uint64(r.CreatedAt))
+	b = appendProtoVarint(b, 4, uint64(r.ExpiresAt))
+	b = appendProtoString(b, 5, r.IP)
+	b = appendProtoString(b, 6, r.UserAgent)
+	for _, role := range r.Roles {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendString(b, role)
+	}
+	for k, v := range r.Attributes {
+		var entry []byte
+		entry = appendProtoString(entry, 1, k)
+		entry = appendProtoString(entry, 2, v)
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	for _, item := range r.Cart {
+		var m []byte
+		m = appendProtoString(m, 1, item.SKU)
+		m = appendProtoVarint(m, 2, uint64(item.Quantity))
+		m = appendProtoVarint(m, 3, uint64(item.PriceCents))
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	return b
+}
+
+// appendProtoString and appendProtoVarint skip zero values, which proto3
+// doesn't encode.
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func (r *SessionRecord) parseProto(b []byte) error {
+	*r = SessionRecord{}
+	return parseProtoFields(b, func(num protowire.Number, v uint64, s []byte) error {
+		switch num {
+		case 1:
+			r.ID = string(s)
+		case 2:
+			r.UserID = int64(v)
+		case 3:
+			r.CreatedAt = int64(v)
+		case 4:
+			r.ExpiresAt = int64(v)
+		case 5:
+			r.IP = string(s)
+		case 6:
+			r.UserAgent = string(s)
+		case 7:
+			r.Roles = append(r.Roles, string(s))
+		case 8:
+			var k, val string
+			err := parseProtoFields(s, func(num protowire.Number, _ uint64, s []byte) error {
+				switch num {
+				case 1:
+					k = string(s)
+				case 2:
+					val = string(s)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if r.Attributes == nil {
+				r.Attributes = make(map[string]string)
+			}
+			r.Attributes[k] = val
+		case 9:
+			var item CartItem
+			err := parseProtoFields(s, func(num protowire.Number, v uint64, s []byte) error {
+				switch num {
+				case 1:
+					item.SKU = string(s)
+				case 2:
+					item.Quantity = int32(v)
+				case 3:
+					item.PriceCents = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			r.Cart = append(r.Cart, item)
+		}
+		return nil
+	})
+}
+
+// parseProtoFields calls field with each varint or length-delimited field
+// of b, skipping fields of other types.
+func parseProtoFields(b []byte, field func(num protowire.Number, v uint64, s []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var s []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				b = b[n:]
+				continue
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		err := field(num, v, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSetRecord encodes a worker's session record with c and stores it,
+// refreshing its expiry every time as a session store does on each request.
+func runSetRecord(c recordCodec, seed int64) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		key := "session_" + strconv.Itoa(i)
+		rec := newSessionRecord(i, sessionTime(seed, 0))
+
+		for seq := 1; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			rec.ExpiresAt = sessionTime(seed, seq).Add(30 * time.Minute).UnixMilli()
+			b, err := c.marshal(rec)
+			if err == nil {
+				err = store.SetBytes(ctx, key, b)
+			}
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+// runGetRecord reads and decodes a worker's session record, checking it
+// belongs to the worker.
+func runGetRecord(c recordCodec, seed int64) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		key := "session_" + strconv.Itoa(i)
+		want := newSessionRecord(i, sessionTime(seed, 0))
+
+		var rec SessionRecord
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			b, err := store.GetBytes(ctx, key)
+			if err == nil {
+				err = c.unmarshal(b, &rec)
+			}
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			if rec.UserID != want.UserID || len(rec.Cart) != len(want.Cart) || rec.Attributes["locale"] != want.Attributes["locale"] {
+				s.Err(mismatchf("get-record %s: got session of user %d", key, rec.UserID))
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// PrintRedisCompatComparison compares every Redis-compatible server's
+// results with Redis's at the same workers and phase, and in the same
+// durability mode and topology.
+func PrintRedisCompatComparison(results []Result) {
+	type key struct {
+		label   string // "redis" with the backend's mode and topology
+		workers int
+		phase   string
+	}
+	keyOf := func(r *Result) (key, string) {
+		i := strings.IndexAny(r.Backend, "+@")
+		if i < 0 {
+			i = len(r.Backend)
+		}
+		return key{"redis" + r.Backend[i:], r.Workers, r.Label()}, r.Backend[:i]
+	}
+
+	ref := make(map[key]*Result)
+	for i := range results {
+		r := &results[i]
+		if k, name := keyOf(r); name == "redis" {
+			ref[k] = r
+		}
+	}
+
+	var printed bool
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for i := range results {
+		r := &results[i]
+		k, name := keyOf(r)
+		if !kv.IsRedisCompat(name) {
+			continue
+		}
+		d := ref[k]
+		if d == nil {
+			continue
+		}
+		if !printed {
+			fmt.Printf("==== redis vs compatible servers ====\n")
+			fmt.Fprintf(w, "workers\tphase\tbackend\tops\tredis ops\tΔops\tp99\tredis p99\tΔp99\t\n")
+			printed = true
+		}
+		p99, dp99 := r.Stats.latency.Quantile(0.99), d.Stats.latency.Quantile(0.99)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%.0f\t%+.1f%%\t%s\t%s\t%s\t\n",
+			r.Workers,
+			r.Label(),
+			r.Backend,
+			r.Ops(),
+			d.Ops(),
+			percentDelta(d.Ops(), r.Ops()),
+			p99,
+			dp99,
+			signedDuration(p99-dp99),
+		)
+	}
+	w.Flush()
+}
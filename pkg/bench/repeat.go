@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Spread summarizes one metric across repeated runs of a phase.
+type Spread struct {
+	N      int
+	Mean   float64
+	Stddev float64 // sample standard deviation
+	Min    float64
+	Max    float64
+	CI95   float64 // half-width of the 95% confidence interval of the mean
+}
+
+func spreadOf(xs []float64) Spread {
+	s := Spread{N: len(xs), Min: math.Inf(1), Max: math.Inf(-1)}
+	if s.N == 0 {
+		return Spread{}
+	}
+	for _, x := range xs {
+		s.Mean += x
+		s.Min = math.Min(s.Min, x)
+		s.Max = math.Max(s.Max, x)
+	}
+	s.Mean /= float64(s.N)
+	if s.N < 2 {
+		return s
+	}
+	for _, x := range xs {
+		s.Stddev += (x - s.Mean) * (x - s.Mean)
+	}
+	s.Stddev = math.Sqrt(s.Stddev / float64(s.N-1))
+	s.CI95 = tCritical95(s.N-1) * s.Stddev / math.Sqrt(float64(s.N))
+	return s
+}
+
+// tCritical95 is the two-sided 95% Student's t critical value for df degrees
+// of freedom.
+func tCritical95(df int) float64 {
+	table := []float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	if df < 1 {
+		return math.NaN()
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+	return 1.960
+}
+
+// PrintRepeat reports the spread of throughput and latency across the
+// repeated runs of one phase.
+func PrintRepeat(runs []Result) {
+	ops := make([]float64, len(runs))
+	p50 := make([]float64, len(runs))
+	p99 := make([]float64, len(runs))
+	for i, r := range runs {
+		ops[i] = r.Ops()
+		p50[i] = float64(r.Stats.latency.Quantile(0.5))
+		p99[i] = float64(r.Stats.latency.Quantile(0.99))
+	}
+
+	fmt.Printf("==== %s x%d ====\n", runs[0].Phase, len(runs))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "metric\tmean\t±95%%\tstddev\tmin\tmax\t\n")
+	printSpread(w, "ops", spreadOf(ops), func(v float64) string { return fmt.Sprintf("%.0f", v) })
+	printSpread(w, "p50", spreadOf(p50), formatNanos)
+	printSpread(w, "p99", spreadOf(p99), formatNanos)
+	w.Flush()
+}
+
+func printSpread(w *tabwriter.Writer, name string, s Spread, format func(float64) string) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", name, format(s.Mean), format(s.CI95), format(s.Stddev), format(s.Min), format(s.Max))
+}
+
+func formatNanos(v float64) string {
+	return time.Duration(v).String()
+}
@@ -0,0 +1,326 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+func Report(r Result) {
+	s := r.Stats
+	last := r.Samples[len(r.Samples)-1]
+
+	fmt.Printf("total: %d\n", r.Total())
+	fmt.Printf("ops: %.1f/s (issued: %.1f/s)\n", r.Ops(), r.IssueRate())
+	if r.Batch > 1 {
+		fmt.Printf("keys: %.1f/s (%d per op)\n", r.KeyRate(), r.Batch)
+	}
+	fmt.Printf("ok: %d\n", last.OK)
+	fmt.Printf("err: %d\n", last.Err)
+	if last.Err > 0 {
+		fmt.Printf("errors: %s\n", s.Errors())
+	}
+	if ops, retries := s.Retried(); retries > 0 {
+		fmt.Printf("retried: %d ops succeeded after %d retries\n", ops, retries)
+	}
+	if t := s.Timeouts(); t > 0 {
+		fmt.Printf("op timeouts: %d\n", t)
+	}
+	if r.Footprint != nil {
+		printFootprint(*r.Footprint, r.FootprintBefore)
+	}
+	if r.Shards != nil {
+		printShards(r.Shards)
+	}
+	if r.Client != nil {
+		printClientStats(r.Backend, r.Label(), r.Client)
+	}
+	if r.Table != nil {
+		printTableStats(r.Table, r.TableBefore)
+	}
+	printTimings(r)
+	if c := s.Compression(); c != nil && (c.Values > 0 || c.Decompressed > 0) {
+		printCompression(c)
+	}
+	if f := s.Faults(); f != nil {
+		fmt.Printf("faults: %s\n", f)
+	}
+	if m := s.Mirror(); m != nil {
+		printMirror(m, &s.latency)
+	}
+	if a := s.Anomalies(); a > 0 {
+		fmt.Printf("anomalies: %d\n", a)
+	}
+	if l := s.Lookups(); l != nil {
+		printLookups(l)
+	}
+	if q := s.Queue(); q != nil {
+		printQueue(q)
+	}
+	fmt.Printf("latency: mean=%s p50=%s p90=%s p99=%s p99.9=%s p99.99=%s max=%s (at %s)\n",
+		s.latency.Mean(),
+		s.latency.Quantile(0.5),
+		s.latency.Quantile(0.9),
+		s.latency.Quantile(0.99),
+		s.latency.Quantile(0.999),
+		s.latency.Quantile(0.9999),
+		s.latency.Max(),
+		s.MaxAt().Round(time.Millisecond),
+	)
+
+	var prev Sample
+	for _, x := range r.Samples {
+		fmt.Printf("  %6s ops: %d err: %d p50: %s p99: %s max: %s", x.At, (x.OK+x.Err)-(prev.OK+prev.Err), x.Err-prev.Err, x.P50, x.P99, x.Max)
+		if len(x.Events) > 0 {
+			fmt.Printf(" [%s]", strings.Join(x.Events, ", "))
+		}
+		fmt.Println()
+		prev = x
+	}
+}
+
+// PrintSummary prints a side-by-side table of every backend, worker count and
+// phase that ran, grouped so the same workload on each backend is adjacent.
+func PrintSummary(results []Result) {
+	sorted := append([]Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Workers != sorted[j].Workers {
+			return sorted[i].Workers < sorted[j].Workers
+		}
+		return phaseIndex(results, sorted[i].Phase) < phaseIndex(results, sorted[j].Phase)
+	})
+
+	fmt.Printf("==== summary ====\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "workers\tphase\tbackend\tops\tp50\tp99\tp99.99\tmax\terr\t\n")
+	for _, r := range sorted {
+		last := r.Samples[len(r.Samples)-1]
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%s\t%s\t%s\t%s\t%d\t\n",
+			r.Workers,
+			r.Label(),
+			r.Backend,
+			r.Ops(),
+			r.Stats.latency.Quantile(0.5),
+			r.Stats.latency.Quantile(0.99),
+			r.Stats.latency.Quantile(0.9999),
+			r.Stats.latency.Max(),
+			last.Err,
+		)
+	}
+	w.Flush()
+}
+
+func phaseIndex(results []Result, name string) int {
+	for i, r := range results {
+		if r.Phase == name {
+			return i
+		}
+	}
+	return len(results)
+}
+
+// PrintTLSComparison reports how much session resumption saves per
+// connection, if both TLS phases ran for the backend at n workers.
+func PrintTLSComparison(results []Result, backend string, n int) {
+	var full, resumed *Result
+	for i := range results {
+		r := &results[i]
+		if r.Backend != backend || r.Workers != n {
+			continue
+		}
+		switch r.Phase {
+		case "get-tls-full":
+			full = r
+		case "get-tls-resumed":
+			resumed = r
+		}
+	}
+	if full == nil || resumed == nil {
+		return
+	}
+
+	saved := full.Stats.latency.Mean() - resumed.Stats.latency.Mean()
+	fmt.Printf("==== tls resumption ====\n")
+	fmt.Printf("full handshake: ops=%.1f mean=%s\n", full.Ops(), full.Stats.latency.Mean())
+	fmt.Printf("resumed: ops=%.1f mean=%s\n", resumed.Ops(), resumed.Stats.latency.Mean())
+	fmt.Printf("saved per connection: %s\n", saved)
+}
+
+// printFootprint reports the backend's memory and disk use, and what each
+// stored key costs in them, with their growth over the phase if the
+// footprint before it is known.
+func printFootprint(f kv.Footprint, before *kv.Footprint) {
+	var b kv.Footprint
+	if before != nil {
+		b = *before
+	}
+	// growth is the change over the phase, "" if the value before is
+	// unknown; formatBytes shows 0 as unlimited
+	growth := func(v, prev int64, format func(int64) string) string {
+		switch {
+		case before == nil || prev < 0:
+			return ""
+		case v < prev:
+			return " (-" + format(prev-v) + ")"
+		case v == prev:
+			return " (+0)"
+		}
+		return " (+" + format(v-prev) + ")"
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	var parts []string
+	for _, x := range []struct {
+		name        string
+		bytes, prev int64
+	}{{"memory", f.Memory, b.Memory}, {"disk", f.Disk, b.Disk}} {
+		if x.bytes <= 0 {
+			continue
+		}
+		part := fmt.Sprintf("%s=%s", x.name, formatBytes(x.bytes))
+		if f.Keys > 0 {
+			part += fmt.Sprintf(" (%.0fB/key)", float64(x.bytes)/float64(f.Keys))
+		}
+		if x.prev > 0 {
+			part += growth(x.bytes, x.prev, formatBytes)
+		}
+		parts = append(parts, part)
+	}
+	if f.Keys >= 0 {
+		parts = append(parts, fmt.Sprintf("keys=%d", f.Keys)+growth(f.Keys, b.Keys, count))
+	}
+	if len(parts) > 0 {
+		fmt.Printf("footprint: %s\n", strings.Join(parts, " "))
+	}
+}
+
+// printShards reports each shard's share of the operations and the keys,
+// and how much busier the busiest shard was than an even spread.
+func printShards(shards []kv.ShardStats) {
+	var ops, keys, hottest uint64
+	for _, s := range shards {
+		ops += s.Ops
+		keys += uint64(max(s.Keys, 0))
+		hottest = max(hottest, s.Ops)
+	}
+	share := func(n, total uint64) float64 { return 100 * float64(n) / float64(max(total, 1)) }
+	for _, s := range shards {
+		fmt.Printf("shard %s: ops=%d (%.1f%%) errors=%d keys=%d (%.1f%%)\n",
+			s.Addr, s.Ops, share(s.Ops, ops), s.Errors, s.Keys, share(uint64(max(s.Keys, 0)), keys))
+	}
+	if ops > 0 {
+		fmt.Printf("hottest shard: %.2fx an even share of the ops\n", float64(hottest)*float64(len(shards))/float64(ops))
+	}
+}
+
+// printTableStats reports the table's size and its growth over the phase,
+// its dead rows, and the autovacuum runs the phase saw.
+func printTableStats(t, before *kv.TableStats) {
+	// formatBytes shows 0 as unlimited
+	size := func(v int64) string {
+		if v == 0 {
+			return "0B"
+		}
+		return formatBytes(v)
+	}
+	growth := "+" + size(t.Bytes-before.Bytes)
+	if t.Bytes < before.Bytes {
+		growth = "-" + size(before.Bytes-t.Bytes)
+	}
+	dead := 100 * float64(t.DeadTuples) / math.Max(1, float64(t.LiveTuples+t.DeadTuples))
+	fmt.Printf("table: %s (%s) live=%d dead=%d (%.1f%%) autovacuum=%d autoanalyze=%d",
+		size(t.Bytes),
+		growth,
+		t.LiveTuples,
+		t.DeadTuples,
+		dead,
+		t.Autovacuums-before.Autovacuums,
+		t.Autoanalyzes-before.Autoanalyzes,
+	)
+	if t.FreeBytes >= 0 {
+		fmt.Printf(" free=%s (%.1f%%)", size(t.FreeBytes), 100*float64(t.FreeBytes)/math.Max(1, float64(t.Bytes)))
+	}
+	fmt.Println()
+}
+
+// printCompression reports the stored size relative to the raw values and
+// the mean CPU time per compressed and decompressed value.
+func printCompression(c *CompressionStats) {
+	per := func(d time.Duration, n uint64) time.Duration {
+		if n == 0 {
+			return 0
+		}
+		return d / time.Duration(n)
+	}
+	var parts []string
+	if c.Values > 0 {
+		parts = append(parts, fmt.Sprintf("%s -> %s (%.1f%%), %d of %d values compressed at %s each",
+			formatBytes(int64(c.RawBytes)),
+			formatBytes(int64(c.StoredBytes)),
+			100*float64(c.StoredBytes)/math.Max(1, float64(c.RawBytes)),
+			c.Compressed,
+			c.Values,
+			per(c.CompressTime, c.Compressed),
+		))
+	}
+	if c.Decompressed > 0 {
+		parts = append(parts, fmt.Sprintf("%d values decompressed at %s each", c.Decompressed, per(c.DecompressTime, c.Decompressed)))
+	}
+	fmt.Printf("compression: %s %s\n", c.Codec, strings.Join(parts, ", "))
+}
+
+// PrintWorkers breaks a phase down by worker, flagging workers that did far
+// less work or were far slower than the median, such as one stuck on a bad
+// connection.
+func PrintWorkers(r Result) {
+	ws := r.Stats.Workers()
+	if len(ws) == 0 {
+		return
+	}
+
+	ops := make([]float64, len(ws))
+	means := make([]float64, len(ws))
+	for i, w := range ws {
+		ops[i] = float64(w.OK + w.Err)
+		means[i] = float64(w.Mean)
+	}
+	medOps, medMean := median(ops), median(means)
+	sp := spreadOf(ops)
+
+	fmt.Printf("==== %s per worker ====\n", r.Label())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "worker\tops\terr\tmean\tp99\tmax\tnote\t\n")
+	for i, x := range ws {
+		var flag string
+		switch {
+		case ops[i] < medOps/3:
+			flag = "starved"
+		case means[i] > 3*medMean:
+			flag = "slow"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\t%s\t\n", i, x.OK+x.Err, x.Err, x.Mean, x.P99, x.Max, flag)
+	}
+	w.Flush()
+	if sp.Mean > 0 {
+		fmt.Printf("fairness: ops min=%.0f max=%.0f cv=%.1f%%\n", sp.Min, sp.Max, sp.Stddev/sp.Mean*100)
+	}
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if len(s) == 0 {
+		return 0
+	}
+	if len(s)%2 == 0 {
+		return (s[len(s)/2-1] + s[len(s)/2]) / 2
+	}
+	return s[len(s)/2]
+}
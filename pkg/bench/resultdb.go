@@ -0,0 +1,366 @@
+package bench
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// ResultDB keeps every run's results in Postgres or SQLite, so trends can be
+// followed across runs with `kv-test-perf history`.
+type ResultDB struct {
+	db *sql.DB
+}
+
+// RunInfo identifies a run in the results database.
+type RunInfo struct {
+	Created  time.Time
+	GitSHA   string
+	Hostname string
+	Labels   map[string]string
+}
+
+// NewRunInfo describes the current run, with the git commit of the working
+// directory, or else of the binary's build.
+func NewRunInfo(labels map[string]string) RunInfo {
+	host, _ := os.Hostname()
+	return RunInfo{
+		Created:  time.Now().UTC(),
+		GitSHA:   gitSHA(),
+		Hostname: host,
+		Labels:   labels,
+	}
+}
+
+func gitSHA() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// OpenResultDB opens the results database at dsn, a postgres:// URL or
+// sqlite:<path>, creating its tables if they don't exist.
+func OpenResultDB(ctx context.Context, dsn string) (*ResultDB, error) {
+	driver, source := "postgres", dsn
+	idType := "bigserial"
+	if path, ok := strings.CutPrefix(dsn, "sqlite:"); ok {
+		if !sqliteBuilt {
+			return nil, errors.New("results db: sqlite not built in, rebuild with -tags sqlite")
+		}
+		driver, source = "sqlite3", path
+		idType = "integer"
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range []string{
+		`create table if not exists runs (
+			id ` + idType + ` primary key,
+			created timestamp not null,
+			git_sha text not null,
+			hostname text not null,
+			labels text not null,
+			metadata text not null
+		)`,
+		`create table if not exists run_results (
+			run_id bigint not null references runs (id),
+			backend text not null,
+			workers int not null,
+			phase text not null,
+			step int not null,
+			repetition int not null,
+			ops double precision not null,
+			p50_ns bigint not null,
+			p99_ns bigint not null,
+			max_ns bigint not null,
+			err bigint not null,
+			summary text not null
+		)`,
+		`create index if not exists run_results_run_id on run_results (run_id)`,
+	} {
+		_, err = db.ExecContext(ctx, q)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("results db: %w", err)
+		}
+	}
+	return &ResultDB{db: db}, nil
+}
+
+func (d *ResultDB) Close() error {
+	return d.db.Close()
+}
+
+// Save stores a run and its results in one transaction.
+func (d *ResultDB) Save(ctx context.Context, run RunInfo, md Metadata, results []Result) error {
+	labels, err := json.Marshal(run.Labels)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		`insert into runs (created, git_sha, hostname, labels, metadata) values ($1, $2, $3, $4, $5) returning id`,
+		run.Created, run.GitSHA, run.Hostname, string(labels), string(meta),
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		s := r.Summary()
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`insert into run_results (run_id, backend, workers, phase, step, repetition, ops, p50_ns, p99_ns, max_ns, err, summary)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			id, s.Backend, s.Workers, s.Phase, s.Step, s.Run, s.Ops, int64(s.P50), int64(s.P99), int64(s.Max), int64(s.Err), string(data),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// HistoryRow is one phase result of a stored run.
+type HistoryRow struct {
+	RunID    int64
+	Created  time.Time
+	GitSHA   string
+	Hostname string
+	Labels   map[string]string
+	Backend  string
+	Workers  int
+	Phase    string
+	Ops      float64
+	P50      time.Duration
+	P99      time.Duration
+	Err      int64
+}
+
+// HistoryFilter selects the results History returns; empty fields match
+// everything.
+type HistoryFilter struct {
+	Backend string
+	Phase   string
+	Workers int
+	Labels  map[string]string // every one must match
+	Runs    int               // most recent runs to return, 0 for all
+}
+
+// History returns the results of the stored runs matching f, oldest first.
+// Only single-run, non-profiled results are returned, so every row of a
+// series measures the same thing.
+func (d *ResultDB) History(ctx context.Context, f HistoryFilter) ([]HistoryRow, error) {
+	q := `select r.id, r.created, r.git_sha, r.hostname, r.labels, x.backend, x.workers, x.phase, x.ops, x.p50_ns, x.p99_ns, x.err
+		from runs r join run_results x on x.run_id = r.id
+		where x.step = 0 and x.repetition = 0`
+	var args []any
+	if f.Backend != "" {
+		args = append(args, f.Backend)
+		q += fmt.Sprintf(" and x.backend = $%d", len(args))
+	}
+	if f.Phase != "" {
+		args = append(args, f.Phase)
+		q += fmt.Sprintf(" and x.phase = $%d", len(args))
+	}
+	if f.Workers > 0 {
+		args = append(args, f.Workers)
+		q += fmt.Sprintf(" and x.workers = $%d", len(args))
+	}
+	q += ` order by r.created, r.id`
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hs []HistoryRow
+	for rows.Next() {
+		var h HistoryRow
+		var labels string
+		var p50, p99 int64
+		err = rows.Scan(&h.RunID, &h.Created, &h.GitSHA, &h.Hostname, &labels, &h.Backend, &h.Workers, &h.Phase, &h.Ops, &p50, &p99, &h.Err)
+		if err != nil {
+			return nil, err
+		}
+		h.P50, h.P99 = time.Duration(p50), time.Duration(p99)
+		err = json.Unmarshal([]byte(labels), &h.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("run %d labels: %w", h.RunID, err)
+		}
+		if matchLabels(h.Labels, f.Labels) {
+			hs = append(hs, h)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lastRuns(hs, f.Runs), nil
+}
+
+func matchLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// lastRuns keeps the rows of the n most recent runs, all of them if n is 0.
+func lastRuns(hs []HistoryRow, n int) []HistoryRow {
+	if n <= 0 {
+		return hs
+	}
+	seen := make(map[int64]bool)
+	i := len(hs)
+	for i > 0 && (len(seen) < n || seen[hs[i-1].RunID]) {
+		seen[hs[i-1].RunID] = true
+		i--
+	}
+	return hs[i:]
+}
+
+// PrintHistory prints each backend, worker count and phase as a series
+// across runs, with the change in throughput and p99 from the run before.
+func PrintHistory(hs []HistoryRow) {
+	type series struct {
+		backend string
+		workers int
+		phase   string
+	}
+	var order []series
+	bySeries := make(map[series][]HistoryRow)
+	for _, h := range hs {
+		s := series{h.Backend, h.Workers, h.Phase}
+		if _, ok := bySeries[s]; !ok {
+			order = append(order, s)
+		}
+		bySeries[s] = append(bySeries[s], h)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].backend != order[j].backend {
+			return order[i].backend < order[j].backend
+		}
+		return order[i].workers < order[j].workers
+	})
+
+	for _, s := range order {
+		fmt.Printf("==== %s %s workers=%d ====\n", s.backend, s.phase, s.workers)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "run\tcreated\tgit\thost\tops\tΔops\tp50\tp99\tΔp99\terr\tlabels\t\n")
+		var prev *HistoryRow
+		for i, h := range bySeries[s] {
+			dOps, dP99 := "-", "-"
+			if prev != nil {
+				dOps = fmt.Sprintf("%+.1f%%", percentDelta(prev.Ops, h.Ops))
+				dP99 = fmt.Sprintf("%+.1f%%", percentDelta(float64(prev.P99), float64(h.P99)))
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.0f\t%s\t%s\t%s\t%s\t%d\t%s\t\n",
+				h.RunID, h.Created.Local().Format("2006-01-02 15:04"), shortSHA(h.GitSHA), h.Hostname,
+				h.Ops, dOps, h.P50, h.P99, dP99, h.Err, formatLabels(h.Labels))
+			prev = &bySeries[s][i]
+		}
+		w.Flush()
+	}
+}
+
+func shortSHA(sha string) string {
+	if sha == "" {
+		return "-"
+	}
+	return sha[:min(len(sha), 8)]
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	xs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		xs = append(xs, k+"="+v)
+	}
+	sort.Strings(xs)
+	return strings.Join(xs, ",")
+}
+
+// RunHistory implements the "history" subcommand.
+func RunHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dsn := fs.String("results-db", "", "results database written with -results-db: a postgres:// URL or sqlite:<path>")
+	var f HistoryFilter
+	fs.StringVar(&f.Backend, "backend", "", "only this backend")
+	fs.StringVar(&f.Phase, "phase", "", "only this phase")
+	fs.IntVar(&f.Workers, "workers", 0, "only this worker count, 0 for all")
+	fs.IntVar(&f.Runs, "runs", 20, "most recent runs to show, 0 for all")
+	labels := fs.String("labels", "", "only runs with these comma-separated name=value labels")
+	fs.Parse(args)
+
+	if *dsn == "" {
+		return errors.New("history: -results-db is required")
+	}
+	if *labels != "" {
+		f.Labels = make(map[string]string)
+		for _, l := range strings.Split(*labels, ",") {
+			k, v, ok := strings.Cut(l, "=")
+			if !ok {
+				return fmt.Errorf("invalid label: %s (want name=value)", l)
+			}
+			f.Labels[k] = v
+		}
+	}
+
+	ctx := context.Background()
+	db, err := OpenResultDB(ctx, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	hs, err := db.History(ctx, f)
+	if err != nil {
+		return err
+	}
+	if len(hs) == 0 {
+		fmt.Println("no results")
+		return nil
+	}
+	PrintHistory(hs)
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !sqlite
+
+package bench
+
+// sqliteBuilt is false in builds without the sqlite tag: the driver needs
+// cgo, so it's left out by default. Build with -tags sqlite to include it.
+const sqliteBuilt = false
@@ -0,0 +1,9 @@
+//go:build sqlite
+
+package bench
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqliteBuilt reports whether the SQLite driver, which needs cgo, is in
+// the build.
+const sqliteBuilt = true
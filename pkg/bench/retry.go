@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// RetryPolicy retries operations that fail with a transient error.
+type RetryPolicy struct {
+	Attempts   int           `json:"attempts"` // retries after the first try, 0 disables
+	Backoff    time.Duration `json:"backoff"`  // wait before the first retry, doubled on each next one
+	MaxBackoff time.Duration `json:"max_backoff"`
+}
+
+// transient reports whether an operation failing with err is worth retrying.
+func transient(err error) bool {
+	switch classifyError(err) {
+	case ErrTimeout, ErrConnection, ErrSerialization, ErrDeadlock, ErrPool:
+		return true
+	}
+	return false
+}
+
+// retryKV retries the idempotent operations of next per its policy.
+// SetNX, CompareAndSwap and Incr aren't retried: after a timeout the first
+// try may have applied, and retrying would turn that into a false anomaly.
+type retryKV struct {
+	next   kv.KV
+	policy RetryPolicy
+	clock  Clock
+
+	retried  atomic.Uint64 // operations that succeeded after a retry
+	attempts atomic.Uint64 // retries made
+}
+
+func NewRetryKV(next kv.KV, policy RetryPolicy, clock Clock) *retryKV {
+	return &retryKV{next: next, policy: policy, clock: clock}
+}
+
+// Retried returns how many operations succeeded only after retrying, and how
+// many retries were made in total.
+func (r *retryKV) Retried() (ops, attempts uint64) {
+	return r.retried.Load(), r.attempts.Load()
+}
+
+func retry[T any](ctx context.Context, r *retryKV, op func() (T, error)) (T, error) {
+	v, err := op()
+	backoff := r.policy.Backoff
+	for i := 0; i < r.policy.Attempts && err != nil && transient(err); i++ {
+		t := r.clock.NewTimer(backoff)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return v, ctx.Err()
+		}
+		if backoff *= 2; r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+
+		r.attempts.Add(1)
+		v, err = op()
+		if err == nil {
+			r.retried.Add(1)
+		}
+	}
+	return v, err
+}
+
+func (r *retryKV) Name() string {
+	return r.next.Name()
+}
+
+func (r *retryKV) Setup(ctx context.Context) error {
+	return r.next.Setup(ctx)
+}
+
+func (r *retryKV) Close(ctx context.Context) error {
+	return r.next.Close(ctx)
+}
+
+func (r *retryKV) Set(ctx context.Context, key, value string) error {
+	_, err := retry(ctx, r, func() (struct{}, error) {
+		return struct{}{}, r.next.Set(ctx, key, value)
+	})
+	return err
+}
+
+func (r *retryKV) Get(ctx context.Context, key string) (string, error) {
+	return retry(ctx, r, func() (string, error) {
+		return r.next.Get(ctx, key)
+	})
+}
+
+func (r *retryKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := retry(ctx, r, func() (struct{}, error) {
+		return struct{}{}, r.next.SetBytes(ctx, key, value)
+	})
+	return err
+}
+
+func (r *retryKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return retry(ctx, r, func() ([]byte, error) {
+		return r.next.GetBytes(ctx, key)
+	})
+}
+
+func (r *retryKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := retry(ctx, r, func() (struct{}, error) {
+		return struct{}{}, r.next.SetTTL(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (r *retryKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return r.next.SetNX(ctx, key, value)
+}
+
+func (r *retryKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return r.next.CompareAndSwap(ctx, key, old, new)
+}
+
+func (r *retryKV) Incr(ctx context.Context, key string) (int64, error) {
+	return r.next.Incr(ctx, key)
+}
+
+func (r *retryKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return windowCounterOf(r.next).IncrWindow(ctx, key, ttl)
+}
+
+func (r *retryKV) Push(ctx context.Context, queue, value string) error {
+	return queueOf(r.next).Push(ctx, queue, value)
+}
+
+func (r *retryKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	return queueOf(r.next).Pop(ctx, queue, timeout)
+}
+
+func (r *retryKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return retry(ctx, r, func() ([]string, error) {
+		return r.next.Scan(ctx, prefix, limit)
+	})
+}
+
+func (r *retryKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := retry(ctx, r, func() (struct{}, error) {
+		return struct{}{}, r.next.TxnSet(ctx, kvs)
+	})
+	return err
+}
+
+// BulkLoad isn't retried: a batch that committed before its reply was lost
+// would fail on the keys it already loaded.
+func (r *retryKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return r.next.BulkLoad(ctx, kvs)
+}
@@ -0,0 +1,471 @@
+// Package bench runs benchmark phases against kv backends, and collects,
+// compares and reports their results.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+type Result struct {
+	Backend string
+	Phase   string
+	Workers int
+	Run     int // 1-based repetition, 0 when the phase ran once
+	Step    int // 1-based load profile step, 0 without a profile
+	Batch   int // keys written per operation, if more than one
+
+	// Durability is the backend's durability settings, if it reports them.
+	Durability string
+
+	// Server is the backend's server version, settings and client library,
+	// nil if it doesn't report them.
+	Server *kv.ServerInfo
+
+	// Footprint and FootprintBefore are the space the backend took after
+	// and before the phase, nil if it doesn't report it.
+	Footprint       *kv.Footprint
+	FootprintBefore *kv.Footprint
+
+	// Client is the benchmark process's own resource use during the
+	// phase, nil for results merged from agents.
+	Client *ClientStats
+
+	// Table and TableBefore are the backend's table statistics after and
+	// before the phase, nil if it doesn't report them.
+	Table       *kv.TableStats
+	TableBefore *kv.TableStats
+
+	// Timings is where the backend's operations spent their time in the
+	// client during the phase, nil if it doesn't time them.
+	Timings *kv.Timings
+
+	// Shards is each shard's operations during the phase and keys after
+	// it, nil unless the backend is sharded.
+	Shards []kv.ShardStats
+
+	Samples []Sample
+	Stats   *Stats
+}
+
+// Label names the phase, including the load profile step and the
+// repetition when there was more than one.
+func (r Result) Label() string {
+	l := r.Phase
+	if r.Step > 0 {
+		l = fmt.Sprintf("%s@%d", l, r.Step)
+	}
+	if r.Run > 0 {
+		l = fmt.Sprintf("%s#%d", l, r.Run)
+	}
+	return l
+}
+
+func (r Result) Total() uint64 {
+	last := r.Samples[len(r.Samples)-1]
+	return last.OK + last.Err
+}
+
+// Ops is the completion rate: operations finished, successfully or not, per
+// second of the phase's measured duration.
+func (r Result) Ops() float64 {
+	last := r.Samples[len(r.Samples)-1]
+	return float64(r.Total()) / last.Elapsed.Seconds()
+}
+
+// IssueRate is operations started per second. It exceeds Ops when
+// operations were still in flight as the phase ended, and, in paced runs,
+// falls below the target when the workers couldn't keep up.
+func (r Result) IssueRate() float64 {
+	last := r.Samples[len(r.Samples)-1]
+	return float64(r.Stats.Issued()) / last.Elapsed.Seconds()
+}
+
+// KeyRate is keys written per second by phases that write a batch of keys
+// per operation, counting only successful batches.
+func (r Result) KeyRate() float64 {
+	last := r.Samples[len(r.Samples)-1]
+	return float64(last.OK) * float64(r.Batch) / last.Elapsed.Seconds()
+}
+
+type PhaseConfig struct {
+	Workers  int
+	Duration time.Duration
+	Rate     float64
+	OnSample func(Sample)
+	Probe    kv.EventProbe
+	Metrics  *Metrics
+	Clock    Clock // defaults to SystemClock
+
+	// Histograms keeps every sample's latency histogram (see WriteHdrLogs).
+	Histograms bool
+
+	// ErrorLogRate caps the errors logged per second, 0 for the default
+	// and negative for no limit.
+	ErrorLogRate int
+}
+
+// ErrPhaseUnsupported is returned by PhaseRunner.Run when the phase's
+// wrapper rejects the backend, or it lacks the interface the phase uses.
+var ErrPhaseUnsupported = errors.New("phase not supported by backend")
+
+// PhaseRunner runs phases with shared settings. Each Run owns its phase's
+// context: the context is cancelled and every goroutine the phase started
+// has exited by the time Run returns, so phases never overlap.
+type PhaseRunner struct {
+	Duration     time.Duration
+	Rate         float64
+	Clock        Clock
+	Metrics      *Metrics
+	Tracer       trace.Tracer
+	Live         bool
+	ProfileDir   string
+	ErrorLogRate int
+	Retry        RetryPolicy
+	OpTimeout    time.Duration // deadline of each operation, 0 for none
+	Compression  CompressionConfig
+	Latency      LatencyConfig
+	Fault        FaultConfig
+	Recorder     *OpRecorder  // records every phase's operations if set
+	Mirror       kv.KV        // secondary backend every write also goes to, if set
+	Histograms   bool         // keep per-second latency histograms
+	OnSample     func(Sample) // called with every sample as the phase runs
+}
+
+func (pr *PhaseRunner) Run(ctx context.Context, store kv.KV, ph Phase, workers int) (r Result, err error) {
+	phaseKV := store
+	if ph.wrap != nil {
+		var ok bool
+		phaseKV, ok = ph.wrap(store)
+		if !ok {
+			return Result{}, ErrPhaseUnsupported
+		}
+		// the wrapped backend is the phase's own, so it is closed with it
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if cerr := phaseKV.Close(ctx); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if ph.supports != nil && !ph.supports(phaseKV) {
+		return Result{}, ErrPhaseUnsupported
+	}
+	clock := pr.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	var mkv *mirrorKV
+	if pr.Mirror != nil {
+		mkv = NewMirrorKV(phaseKV, pr.Mirror, clock)
+		phaseKV = mkv
+	}
+	var fkv *faultKV
+	if pr.Fault.Enabled() {
+		fkv = NewFaultKV(phaseKV, pr.Fault, clock)
+		phaseKV = fkv
+	}
+	if pr.Latency.Delay > 0 {
+		phaseKV = NewLatencyKV(phaseKV, pr.Latency, clock)
+	}
+	var ckv *compressKV
+	if pr.Compression.Codec != "" {
+		ckv, err = NewCompressKV(phaseKV, pr.Compression)
+		if err != nil {
+			return Result{}, err
+		}
+		phaseKV = ckv
+	}
+	var tkv *timeoutKV
+	if pr.OpTimeout > 0 {
+		tkv = NewTimeoutKV(phaseKV, pr.OpTimeout)
+		phaseKV = tkv
+	}
+	if pr.Tracer != nil {
+		phaseKV = NewTracedKV(phaseKV, pr.Tracer)
+	}
+	var rkv *retryKV
+	if pr.Retry.Attempts > 0 {
+		rkv = NewRetryKV(phaseKV, pr.Retry, clock)
+		phaseKV = rkv
+	}
+
+	pc := PhaseConfig{
+		Workers:  workers,
+		Duration: pr.Duration,
+		Rate:     pr.Rate,
+		Metrics:  pr.Metrics,
+		Clock:    pr.Clock,
+		OnSample: pr.OnSample,
+
+		ErrorLogRate: pr.ErrorLogRate,
+		Histograms:   pr.Histograms,
+	}
+	if ph.workers > 0 {
+		pc.Workers = ph.workers
+	}
+	if ph.duration > 0 {
+		pc.Duration = ph.duration
+	}
+	if ph.rate > 0 {
+		pc.Rate = ph.rate
+	}
+	if ph.unbounded {
+		pc.Duration = 0
+	}
+	if pr.Recorder != nil {
+		phaseKV = pr.Recorder.Wrap(phaseKV, ph.Name, pc.Workers)
+	}
+
+	if p, ok := store.(kv.EventProber); ok {
+		pc.Probe = p.NewEventProbe()
+	}
+
+	var dash *Dashboard
+	if pr.Live {
+		dash = NewDashboard(os.Stdout, fmt.Sprintf("%s %s workers=%d", store.Name(), ph.Name, pc.Workers), pc.Duration)
+		pc.OnSample = dash.Update
+		if pr.OnSample != nil {
+			pc.OnSample = func(s Sample) {
+				dash.Update(s)
+				pr.OnSample(s)
+			}
+		}
+	}
+
+	if pr.ProfileDir != "" {
+		stop, err := startPhaseProfile(pr.ProfileDir, fmt.Sprintf("%s-%d-%s", store.Name(), pc.Workers, ph.Name))
+		if err != nil {
+			return Result{}, err
+		}
+		defer func() {
+			if serr := stop(); serr != nil && err == nil {
+				err = serr
+			}
+		}()
+	}
+
+	fr, _ := store.(kv.FootprintReporter)
+	var footprintBefore *kv.Footprint
+	if fr != nil {
+		footprintBefore = readFootprint(store, fr)
+	}
+	ts, _ := store.(kv.TableStatser)
+	var tableBefore *kv.TableStats
+	if ts != nil {
+		tableBefore = readTableStats(store, ts)
+	}
+
+	tr, _ := store.(kv.TimingReporter)
+	var timingsBefore kv.Timings
+	if tr != nil {
+		timingsBefore = tr.Timings()
+	}
+	sr, _ := store.(kv.ShardReporter)
+	var shardsBefore []kv.ShardStats
+	if sr != nil {
+		shardsBefore = readShardStats(store, sr)
+	}
+
+	run := ph.Run
+	if ph.newRun != nil {
+		run = ph.newRun()
+	}
+	r = runPhase(ctx, phaseKV, ph.Name, pc, run)
+	r.Backend = store.Name()
+	if tr != nil {
+		t := tr.Timings().Sub(timingsBefore)
+		r.Timings = &t
+	}
+	if fr != nil {
+		r.Footprint, r.FootprintBefore = readFootprint(store, fr), footprintBefore
+	}
+	if sr != nil && shardsBefore != nil {
+		r.Shards = readShardStats(store, sr)
+		for i := range r.Shards {
+			r.Shards[i].Ops -= shardsBefore[i].Ops
+			r.Shards[i].Errors -= shardsBefore[i].Errors
+		}
+	}
+	if ts != nil && tableBefore != nil {
+		r.Table, r.TableBefore = readTableStats(store, ts), tableBefore
+	}
+	if rkv != nil {
+		r.Stats.retried, r.Stats.retries = rkv.Retried()
+	}
+	if tkv != nil {
+		r.Stats.timeouts = tkv.Timeouts()
+	}
+	if ckv != nil {
+		r.Stats.compression = ckv.Stats()
+	}
+	if fkv != nil {
+		r.Stats.faults = fkv.Stats()
+	}
+	if mkv != nil {
+		r.Stats.mirror = mkv.Stats()
+	}
+	if dash != nil {
+		dash.Clear()
+	}
+	return r, nil
+}
+
+// readFootprint returns kv's footprint, or nil if it can't be read.
+func readFootprint(store kv.KV, fr kv.FootprintReporter) *kv.Footprint {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fp, err := fr.Stats(ctx)
+	if err != nil {
+		slog.Warn("reading backend footprint failed", "backend", store.Name(), "err", err)
+		return nil
+	}
+	return &fp
+}
+
+// readShardStats returns kv's shards, or nil if they can't be read.
+func readShardStats(store kv.KV, sr kv.ShardReporter) []kv.ShardStats {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := sr.ShardStats(ctx)
+	if err != nil {
+		slog.Warn("reading shard stats failed", "backend", store.Name(), "err", err)
+		return nil
+	}
+	return s
+}
+
+// readTableStats returns kv's table statistics, or nil if it has none or
+// they can't be read.
+func readTableStats(store kv.KV, ts kv.TableStatser) *kv.TableStats {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	t, err := ts.TableStats(ctx)
+	if err != nil {
+		slog.Warn("reading table statistics failed", "backend", store.Name(), "err", err)
+		return nil
+	}
+	return t
+}
+
+// runPhase runs one phase with cfg.Workers workers. It ends when
+// cfg.Duration elapses or every worker has returned, whichever is first; a
+// zero Duration runs until the workers finish on their own.
+func runPhase(ctx context.Context, store kv.KV, name string, cfg PhaseConfig, run Worker) Result {
+	fmt.Printf("==== %s ====\n", name)
+	clock := cfg.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	// the phase ends on the clock's timer rather than a context deadline so
+	// virtual time can drive it too
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timerDone := make(chan struct{})
+	if cfg.Duration > 0 {
+		end := clock.NewTimer(cfg.Duration)
+		go func() {
+			defer close(timerDone)
+			select {
+			case <-end.C():
+				cancel()
+			case <-ctx.Done():
+				end.Stop()
+			}
+		}()
+	} else {
+		close(timerDone)
+	}
+
+	log := newErrorLog(slog.With("backend", store.Name(), "phase", name), cfg.ErrorLogRate)
+	defer log.Flush()
+	s := NewStats(cfg.Workers, cfg.Metrics.Phase(store.Name(), name), log, clock)
+	sampler := NewSampler(clock, s, time.Second)
+	sampler.OnSample = cfg.OnSample
+	sampler.Probe = cfg.Probe
+	sampler.Histograms = cfg.Histograms
+	sampler.Start()
+	var wg sync.WaitGroup
+	pacers := make([]*Pacer, cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		i := i
+		pacers[i] = NewPacer(clock, cfg.Rate)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx, store, i, s.Worker(i), pacers[i])
+		}()
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-workersDone:
+	}
+	samples := sampler.Stop()
+	cancel()
+	<-workersDone
+	<-timerDone
+	s.Merge()
+	for _, p := range pacers {
+		s.issued += p.Issued()
+	}
+	return Result{
+		Phase:   name,
+		Workers: cfg.Workers,
+		Samples: samples,
+		Stats:   s,
+		Client:  sampler.Client(),
+	}
+}
+
+// WaitSetup retries store.Setup until it succeeds or timeout elapses, so a
+// backend that is still starting up doesn't fail the run. It returns the
+// duration of the successful attempt.
+func WaitSetup(ctx context.Context, store kv.KV, timeout time.Duration) (time.Duration, int, error) {
+	return waitSetup(ctx, SystemClock, store, timeout)
+}
+
+func waitSetup(ctx context.Context, clock Clock, store kv.KV, timeout time.Duration) (time.Duration, int, error) {
+	deadline := clock.Now().Add(timeout)
+	backoff := 100 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		start := clock.Now()
+		err := store.Setup(ctx)
+		if err == nil {
+			return clock.Since(start), attempt, nil
+		}
+		if clock.Now().Add(backoff).After(deadline) {
+			return 0, attempt, fmt.Errorf("setup %s: %w", store.Name(), err)
+		}
+
+		slog.Warn("setup failed, retrying", "backend", store.Name(), "err", err, "backoff", backoff)
+		t := clock.NewTimer(backoff)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return 0, attempt, ctx.Err()
+		}
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package bench
+
+import (
+	"context"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+type Sample struct {
+	At      time.Duration // aligned offset from the phase start
+	Elapsed time.Duration // actual monotonic offset when the snapshot was taken
+	OK      uint64
+	Err     uint64
+
+	// latency over the interval ending at this sample
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+
+	// Latency is the interval's full histogram, kept only when the phase
+	// runs with Histograms set
+	Latency *HistogramData `json:"latency,omitempty"`
+
+	// server-side events seen during the interval, such as snapshots
+	Events []string
+
+	// the benchmark process's own resource use: cores busy over the
+	// interval, and goroutines and heap at its end
+	ClientCPU  float64
+	Goroutines int
+	Heap       uint64
+}
+
+// Sampler snapshots Stats on a fixed ticker so timelines stay aligned to the
+// phase start regardless of when the phase ends or how late a tick fires.
+type Sampler struct {
+	clock    Clock
+	stats    *Stats
+	interval time.Duration
+	start    time.Time
+	samples  []Sample
+	client   clientReading // at the previous sample
+	first    clientReading // at the start
+	stop     chan struct{}
+	done     chan struct{}
+
+	// OnSample, if set, is called from the sampling goroutine after each tick.
+	OnSample func(Sample)
+
+	// Probe, if set, is called with each snapshot for the backend events
+	// since the previous one.
+	Probe kv.EventProbe
+
+	// Histograms keeps each interval's latency histogram in its sample.
+	Histograms bool
+}
+
+func NewSampler(clock Clock, s *Stats, interval time.Duration) *Sampler {
+	return &Sampler{
+		clock:    clock,
+		stats:    s,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Sampler) Start() {
+	s.start = s.clock.Now()
+	s.client = readClient()
+	s.first = s.client
+	go s.run()
+}
+
+func (s *Sampler) run() {
+	defer close(s.done)
+
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C():
+			s.snapshot(now)
+			if s.OnSample != nil {
+				s.OnSample(s.samples[len(s.samples)-1])
+			}
+		}
+	}
+}
+
+func (s *Sampler) snapshot(now time.Time) {
+	elapsed := now.Sub(s.start)
+	ok, err := s.stats.Snapshot()
+	w := s.stats.swapWindows()
+	client := readClient()
+	defer func() { s.client = client }()
+	var latency *HistogramData
+	if s.Histograms {
+		d := w.Export()
+		latency = &d
+	}
+	s.samples = append(s.samples, Sample{
+		At:      elapsed.Round(s.interval),
+		Elapsed: elapsed,
+		OK:      ok,
+		Err:     err,
+		P50:     w.Quantile(0.5),
+		P99:     w.Quantile(0.99),
+		Max:     w.Max(),
+		Latency: latency,
+		Events:  s.probe(),
+
+		ClientCPU:  client.cpuSince(s.client),
+		Goroutines: client.goroutines,
+		Heap:       client.heap,
+	})
+}
+
+func (s *Sampler) probe() []string {
+	if s.Probe == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval/2)
+	defer cancel()
+	events, err := s.Probe(ctx)
+	if err != nil {
+		return []string{"probe error: " + err.Error()}
+	}
+	return events
+}
+
+// Stop takes a final snapshot and returns all samples collected so far.
+func (s *Sampler) Stop() []Sample {
+	close(s.stop)
+	<-s.done
+	s.snapshot(s.clock.Now())
+	return s.samples
+}
+
+// Client summarizes the process's resource use from Start to Stop.
+func (s *Sampler) Client() *ClientStats {
+	return newClientStats(s.first, s.client, s.samples)
+}
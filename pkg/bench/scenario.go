@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a declarative benchmark definition loaded from YAML. Top-level
+// fields override the matching flags; phases, if any, replace the built-in
+// ones. Every backend runs once per topology.
+type Scenario struct {
+	Backends    []string        `yaml:"backends"`
+	PostgresURL string          `yaml:"postgres_url"`
+	RedisAddr   string          `yaml:"redis_addr"`
+	Topologies  []Topology      `yaml:"topologies"`
+	Workers     []int           `yaml:"workers"`
+	Duration    time.Duration   `yaml:"duration"`
+	Rate        float64         `yaml:"rate"`
+	Phases      []ScenarioPhase `yaml:"phases"`
+}
+
+// ScenarioPhase describes one phase of a scenario. Zero Workers, Duration
+// and Rate fall back to the scenario-wide values.
+type ScenarioPhase struct {
+	Name         string        `yaml:"name"`
+	Op           string        `yaml:"op"` // set, get, mixed, incr or session
+	Workers      int           `yaml:"workers"`
+	Duration     time.Duration `yaml:"duration"`
+	Rate         float64       `yaml:"rate"`
+	Keys         int           `yaml:"keys"`
+	Distribution string        `yaml:"distribution"` // uniform, zipf or sequential
+	ValueSize    int           `yaml:"value_size"`
+	TTL          time.Duration `yaml:"ttl"`        // expiry of written keys, or an incr phase's window; 0 for none
+	ReadRatio    float64       `yaml:"read_ratio"` // fraction of gets in a mixed phase
+
+	// HotRatio sends this fraction of the operations to the first HotKeys
+	// keys (1 if unset) instead of the distribution, so every worker
+	// contends for the same few rows or keys.
+	HotRatio float64 `yaml:"hot_ratio"`
+	HotKeys  int     `yaml:"hot_keys"`
+
+	// Churn is the fraction of a session phase's requests that are logins
+	// creating a new session, and RenewRatio the fraction of sessions read
+	// that are renewed (see runSession).
+	Churn      float64 `yaml:"churn"`
+	RenewRatio float64 `yaml:"renew_ratio"`
+}
+
+//go:embed scenarios/presets/*.yaml
+var presets embed.FS
+
+// Presets lists the names of the built-in scenarios.
+func Presets() []string {
+	entries, _ := presets.ReadDir("scenarios/presets")
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = strings.TrimSuffix(e.Name(), ".yaml")
+	}
+	return names
+}
+
+// LoadScenario loads a scenario file, or the built-in preset of that name
+// if no such file exists.
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !strings.ContainsAny(path, "/.") {
+		b, err = presets.ReadFile("scenarios/presets/" + path + ".yaml")
+		if err != nil {
+			return nil, fmt.Errorf("no scenario file or preset %s (presets: %s)", path, strings.Join(Presets(), ", "))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(&sc)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	if len(sc.Phases) == 0 && len(sc.Topologies) == 0 {
+		return nil, fmt.Errorf("scenario %s: no phases or topologies", path)
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) buildPhases(seed int64) ([]Phase, error) {
+	ps := make([]Phase, 0, len(sc.Phases))
+	for i, sp := range sc.Phases {
+		if sp.Name == "" {
+			sp.Name = fmt.Sprintf("%s-%d", sp.Op, i+1)
+		}
+		if sp.Keys <= 0 {
+			sp.Keys = 1000
+		}
+		if sp.ValueSize <= 0 {
+			sp.ValueSize = 16
+		}
+		switch sp.Op {
+		case "set", "get", "mixed", "incr":
+		case "session":
+			if sp.TTL <= 0 {
+				return nil, fmt.Errorf("phase %s: a session phase needs a ttl", sp.Name)
+			}
+		default:
+			return nil, fmt.Errorf("phase %s: unknown op: %s", sp.Name, sp.Op)
+		}
+		if sp.Churn < 0 || sp.Churn > 1 || sp.RenewRatio < 0 || sp.RenewRatio > 1 {
+			return nil, fmt.Errorf("phase %s: churn and renew_ratio must be between 0 and 1", sp.Name)
+		}
+		if sp.HotRatio < 0 || sp.HotRatio > 1 {
+			return nil, fmt.Errorf("phase %s: hot_ratio must be between 0 and 1", sp.Name)
+		}
+		if sp.HotKeys <= 0 {
+			sp.HotKeys = 1
+		}
+		if sp.HotKeys > sp.Keys {
+			return nil, fmt.Errorf("phase %s: hot_keys %d exceeds keys %d", sp.Name, sp.HotKeys, sp.Keys)
+		}
+		// validate the distribution up front instead of in every worker
+		_, err := NewKeyChooser(sp.Distribution, sp.Keys, 0, nil)
+		if err != nil {
+			return nil, fmt.Errorf("phase %s: %w", sp.Name, err)
+		}
+
+		run := runKeyspace(sp, seed)
+		if sp.Op == "session" {
+			run = runSession(sp, seed)
+		}
+		ps = append(ps, Phase{
+			Name:     sp.Name,
+			Run:      run,
+			workers:  sp.Workers,
+			duration: sp.Duration,
+			rate:     sp.Rate,
+		})
+	}
+	return ps, nil
+}
@@ -0,0 +1,304 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Priorities weighs the criteria the recommendation ranks backends by.
+type Priorities struct {
+	Throughput  float64
+	P99         float64
+	Durability  float64
+	Connections float64
+}
+
+func (p *Priorities) String() string {
+	return fmt.Sprintf("throughput=%g,p99=%g,durability=%g,connections=%g", p.Throughput, p.P99, p.Durability, p.Connections)
+}
+
+func (p *Priorities) Set(v string) error {
+	var x Priorities
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		k, sw, ok := strings.Cut(f, "=")
+		if !ok {
+			return fmt.Errorf("invalid priority %q, want name=weight", f)
+		}
+		w, err := strconv.ParseFloat(sw, 64)
+		if err != nil {
+			return err
+		}
+		if w < 0 {
+			return fmt.Errorf("invalid priority weight: %s", f)
+		}
+		switch k {
+		case "throughput":
+			x.Throughput = w
+		case "p99":
+			x.P99 = w
+		case "durability":
+			x.Durability = w
+		case "connections":
+			x.Connections = w
+		default:
+			return fmt.Errorf("unknown priority: %s", k)
+		}
+	}
+	*p = x
+	return nil
+}
+
+// durabilityLevel rates what survives a backend crash, from 0 (nothing) to
+// 2 (every acknowledged write).
+type durabilityLevel struct {
+	level int
+	note  string
+}
+
+// durability rates each backend in the default configuration this tool sets
+// up.
+var durability = map[string]durabilityLevel{
+	"memory":         {0, "process memory only"},
+	"redis":          {1, "depends on server RDB/AOF settings"},
+	"redis-pipeline": {1, "depends on server RDB/AOF settings"},
+	"redis-hash":     {1, "depends on server RDB/AOF settings"},
+	"redis-sharded":  {1, "depends on each server's RDB/AOF settings"},
+	"dragonfly":      {1, "snapshots only, writes since the last one lost"},
+	"keydb":          {1, "depends on server RDB/AOF settings"},
+	"valkey":         {1, "depends on server RDB/AOF settings"},
+	"nats":           {1, "file storage, fsynced every 2 minutes by default"},
+	"consul":         {2, "raft log fsynced on a quorum"},
+	"tikv":           {2, "raft log fsynced on a quorum of replicas"},
+	"foundationdb":   {2, "commits fsynced on every transaction log"},
+	"pebble":         {2, "WAL fsynced on every write"},
+	"cockroachdb":    {2, "raft-replicated, committed on a quorum"},
+	"yugabytedb":     {2, "raft-replicated, committed on a quorum"},
+	"postgresql":     {1, "unlogged table, truncated after a crash"},
+	"tiered":         {1, "postgres unlogged table, truncated after a crash"},
+}
+
+// backendDurability looks up a backend label, ignoring the topology and
+// durability mode in it, and refines the rating with the durability settings
+// the backend reported, if any.
+func backendDurability(label, settings string) durabilityLevel {
+	name, _, _ := strings.Cut(label, "@")
+	name, _, _ = strings.Cut(name, "+")
+	d := durability[name]
+	if settings == "" {
+		return d
+	}
+
+	s := make(map[string]string)
+	for _, f := range strings.Fields(settings) {
+		k, v, _ := strings.Cut(f, "=")
+		s[k] = v
+	}
+	switch name {
+	case "postgresql", "tiered":
+		switch {
+		case s["table"] != "logged":
+		case s["fsync"] == "off":
+			d = durabilityLevel{0, "fsync off, corrupted by a crash"}
+		case s["synchronous_commit"] == "off":
+			d = durabilityLevel{1, "logged table, synchronous_commit off loses recent commits"}
+		default:
+			d = durabilityLevel{2, "logged table, synchronous commit"}
+		}
+	case "redis", "redis-pipeline", "redis-hash", "keydb", "valkey":
+		switch {
+		case s["appendonly"] != "yes":
+			d = durabilityLevel{1, "no AOF, writes since the last RDB snapshot lost"}
+		case s["appendfsync"] == "always":
+			d = durabilityLevel{2, "AOF fsync on every write"}
+		default:
+			d = durabilityLevel{1, "AOF appendfsync " + s["appendfsync"] + ", recent writes lost"}
+		}
+	case "nats":
+		switch {
+		case s["storage"] == "memory":
+			d = durabilityLevel{0, "memory storage, lost on a server restart"}
+		case s["replicas"] != "1":
+			d = durabilityLevel{1, "file storage replicated to " + s["replicas"] + " servers, fsynced periodically"}
+		}
+	case "pebble":
+		if s["sync"] != "true" {
+			d = durabilityLevel{1, "WAL not fsynced, recent writes lost on a machine crash"}
+		}
+	}
+	return d
+}
+
+// BackendScore is one backend's standing in the recommendation.
+type BackendScore struct {
+	Backend        string
+	Workers        int // worker count the numbers are taken at
+	Ops            float64
+	P99            time.Duration
+	ConnCost       time.Duration // extra mean latency per op when reconnecting, 0 if not measured
+	Durability     int
+	DurabilityNote string
+	Score          float64 // 0-100
+}
+
+// scoreBackends ranks backends by p. Each backend is judged at the worker
+// count where its pooled phases had the highest mean throughput; each
+// criterion is scored relative to the best backend and weighted.
+func scoreBackends(results []Result, p Priorities) []BackendScore {
+	var order []string
+	settings := make(map[string]string) // durability settings by backend
+	type key struct {
+		backend string
+		workers int
+	}
+	type agg struct {
+		ops  []float64
+		p99  []float64
+		pool []float64 // mean latency of pooled gets
+		conn []float64 // mean latency of reconnecting gets
+	}
+	aggs := make(map[key]*agg)
+	for _, r := range results {
+		if r.Durability != "" {
+			settings[r.Backend] = r.Durability
+		}
+		k := key{r.Backend, r.Workers}
+		a := aggs[k]
+		if a == nil {
+			a = &agg{}
+			aggs[k] = a
+			if !containsString(order, r.Backend) {
+				order = append(order, r.Backend)
+			}
+		}
+		switch {
+		case r.Phase == "get-reconnect":
+			a.conn = append(a.conn, float64(r.Stats.latency.Mean()))
+		case strings.HasSuffix(r.Phase, "-reconnect") || strings.HasPrefix(r.Phase, "get-tls-"):
+		default:
+			a.ops = append(a.ops, r.Ops())
+			a.p99 = append(a.p99, float64(r.Stats.latency.Quantile(0.99)))
+			if r.Phase == "get" {
+				a.pool = append(a.pool, float64(r.Stats.latency.Mean()))
+			}
+		}
+	}
+
+	var scores []BackendScore
+	for _, b := range order {
+		var best BackendScore
+		var bestAgg *agg
+		for k, a := range aggs {
+			if k.backend != b || len(a.ops) == 0 {
+				continue
+			}
+			ops := spreadOf(a.ops).Mean
+			if bestAgg == nil || ops > best.Ops || (ops == best.Ops && k.workers < best.Workers) {
+				best = BackendScore{Backend: b, Workers: k.workers, Ops: ops}
+				bestAgg = a
+			}
+		}
+		if bestAgg == nil {
+			continue
+		}
+		best.P99 = time.Duration(spreadOf(bestAgg.p99).Mean)
+		if len(bestAgg.conn) > 0 && len(bestAgg.pool) > 0 {
+			best.ConnCost = time.Duration(math.Max(0, spreadOf(bestAgg.conn).Mean-spreadOf(bestAgg.pool).Mean))
+		}
+		d := backendDurability(b, settings[b])
+		best.Durability, best.DurabilityNote = d.level, d.note
+		scores = append(scores, best)
+	}
+
+	var maxOps, maxDur float64
+	var minP99, minConn time.Duration
+	for i, s := range scores {
+		maxOps = math.Max(maxOps, s.Ops)
+		maxDur = math.Max(maxDur, float64(s.Durability))
+		if i == 0 || s.P99 < minP99 {
+			minP99 = s.P99
+		}
+		if s.ConnCost > 0 && (minConn == 0 || s.ConnCost < minConn) {
+			minConn = s.ConnCost
+		}
+	}
+
+	for i := range scores {
+		s := &scores[i]
+		var sum, weight float64
+		add := func(w, v float64) {
+			sum += w * v
+			weight += w
+		}
+		if maxOps > 0 {
+			add(p.Throughput, s.Ops/maxOps)
+		}
+		if s.P99 > 0 {
+			add(p.P99, float64(minP99)/float64(s.P99))
+		}
+		if maxDur > 0 {
+			add(p.Durability, float64(s.Durability)/maxDur)
+		}
+		if s.ConnCost > 0 {
+			add(p.Connections, float64(minConn)/float64(s.ConnCost))
+		}
+		if weight > 0 {
+			s.Score = 100 * sum / weight
+		}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].Score > scores[j].Score })
+	return scores
+}
+
+func containsString(xs []string, x string) bool {
+	for _, y := range xs {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintRecommendation prints the backends ranked by p, with the numbers
+// behind each score.
+func PrintRecommendation(results []Result, p Priorities) {
+	scores := scoreBackends(results, p)
+	if len(scores) < 2 {
+		return
+	}
+
+	fmt.Printf("==== recommendation (%s) ====\n", p.String())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "rank\tbackend\tscore\tworkers\tops\tp99\tconn cost\tdurability\t\n")
+	for i, s := range scores {
+		conn := "-"
+		if s.ConnCost > 0 {
+			conn = s.ConnCost.String()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%d\t%.0f\t%s\t%s\t%s\t\n",
+			i+1,
+			s.Backend,
+			s.Score,
+			s.Workers,
+			s.Ops,
+			s.P99,
+			conn,
+			s.DurabilityNote,
+		)
+	}
+	w.Flush()
+	if p.Connections > 0 && scores[0].ConnCost == 0 {
+		fmt.Printf("note: connection cost not measured; run with -reconnect to include it\n")
+	}
+	fmt.Printf("recommended: %s\n", scores[0].Backend)
+}
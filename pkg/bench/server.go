@@ -0,0 +1,548 @@
+package bench
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// Run states reported by the serve API.
+const (
+	RunRunning = "running"
+	RunDone    = "done"
+	RunFailed  = "failed"
+	RunStopped = "stopped"
+)
+
+// RunRequest starts a run through the serve API: every phase the options
+// build, in order, against one backend.
+type RunRequest struct {
+	Backend      string           `json:"backend"`
+	Config       kv.BackendConfig `json:"config"`
+	Phases       PhaseOptions     `json:"phases"`
+	Workers      int              `json:"workers"`
+	Duration     time.Duration    `json:"duration"`
+	Rate         float64          `json:"rate"`
+	Retry        RetryPolicy      `json:"retry"`
+	Timeout      time.Duration    `json:"op_timeout,omitempty"`
+	Latency      LatencyConfig    `json:"latency"`
+	Fault        FaultConfig      `json:"fault"`
+	ReadyTimeout time.Duration    `json:"ready_timeout"`
+
+	Compression CompressionConfig `json:"compression"`
+}
+
+// redacted returns the request with the credentials in its backend config
+// masked, since anyone who can reach the API can read it back.
+func (r RunRequest) redacted() RunRequest {
+	c := &r.Config
+	for _, u := range []*string{
+		&c.PostgresURL, &c.CockroachURL, &c.YugabyteURL,
+		&c.RedisAddr, &c.DragonflyAddr, &c.KeyDBAddr, &c.ValkeyAddr,
+		&c.NATSURL, &c.ConsulAddr, &c.RemoteURL,
+	} {
+		*u = redactAddr(*u)
+	}
+	for _, list := range []*[]string{&c.RedisShards, &c.TiKVPD} {
+		if *list == nil {
+			continue
+		}
+		redacted := make([]string, len(*list))
+		for i, u := range *list {
+			redacted[i] = redactAddr(u)
+		}
+		*list = redacted
+	}
+	for _, v := range []*string{&c.PostgresPassword, &c.RedisPassword, &c.PostgresTLS.Key, &c.RedisTLS.Key} {
+		if *v != "" {
+			*v = redactedSecret
+		}
+	}
+	if c.Options != nil {
+		// plugin settings are opaque, so any of them may be a secret
+		opts := make(map[string]string, len(c.Options))
+		for k := range c.Options {
+			opts[k] = redactedSecret
+		}
+		c.Options = opts
+	}
+	return r
+}
+
+// redactAddr masks the password of a URL address. A host:port or a socket
+// path is kept, and a key=value DSN, or a URL that doesn't parse, is masked
+// whole.
+func redactAddr(addr string) string {
+	switch {
+	case strings.Contains(addr, "://"):
+		u, err := url.Parse(addr)
+		if err != nil {
+			return redactedSecret
+		}
+		return u.Redacted()
+	case strings.Contains(addr, "="):
+		return redactedSecret
+	}
+	return addr
+}
+
+// redactedSecret replaces a secret, as url.URL.Redacted does passwords.
+const redactedSecret = "xxxxx"
+
+// RunStatus is a run as the serve API reports it.
+type RunStatus struct {
+	ID       string          `json:"id"`
+	State    string          `json:"state"`
+	Phase    string          `json:"phase,omitempty"` // the phase running now
+	Error    string          `json:"error,omitempty"`
+	Started  time.Time       `json:"started"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Request  RunRequest      `json:"request"`
+	Results  []ResultSummary `json:"results"`
+	Skipped  []string        `json:"skipped,omitempty"` // phases the backend doesn't support
+}
+
+// RunEvent is one line of a run's event stream: a sample of the phase
+// running, a phase's result, or the run's end.
+type RunEvent struct {
+	Type   string         `json:"type"` // "phase", "sample", "result", "skipped" or "end"
+	Phase  string         `json:"phase,omitempty"`
+	Sample *Sample        `json:"sample,omitempty"`
+	Result *ResultSummary `json:"result,omitempty"`
+	State  string         `json:"state,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// serverRun is one run started through the API. Its events are kept for
+// the run's life, so a stream opened late replays what it missed.
+type serverRun struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	status  RunStatus
+	events  []RunEvent
+	changed chan struct{} // closed and replaced on every event
+}
+
+func (r *serverRun) emit(e RunEvent, update func(s *RunStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if update != nil {
+		update(&r.status)
+	}
+	r.events = append(r.events, e)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// next returns the events from i on, and a channel closed when there are
+// more.
+func (r *serverRun) next(i int) ([]RunEvent, chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events[i:], r.changed
+}
+
+func (r *serverRun) Status() RunStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Results = append([]ResultSummary{}, s.Results...)
+	s.Skipped = append([]string(nil), s.Skipped...)
+	return s
+}
+
+// maxFinishedRuns is how many finished runs the server keeps, with their
+// events, for clients to read back; older ones are dropped as runs start.
+const maxFinishedRuns = 100
+
+// server runs benchmarks for the serve API, one at a time: runs sharing
+// the machine would skew each other's numbers.
+type server struct {
+	dataDir string // where requests may name files and directories, "" for nowhere
+	token   string // bearer token every request must carry, "" for none
+
+	mu     sync.Mutex
+	runs   map[string]*serverRun
+	order  []string
+	nextID int
+	active *serverRun
+}
+
+func (s *server) start(req RunRequest) (*serverRun, error) {
+	if req.Backend == "" {
+		return nil, errors.New("backend is required")
+	}
+	if req.Workers <= 0 {
+		req.Workers = 100
+	}
+	if req.Duration <= 0 {
+		req.Duration = 10 * time.Second
+	}
+	if req.Phases.SetValues == "" {
+		req.Phases.SetValues = "both"
+	}
+	if req.ReadyTimeout <= 0 {
+		req.ReadyTimeout = 30 * time.Second
+	}
+	err := restrictPaths(&req.Config, &req.Phases, s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	phases, err := req.Phases.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		return nil, errRunActive
+	}
+	s.nextID++
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &serverRun{
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		status: RunStatus{
+			ID:      strconv.Itoa(s.nextID),
+			State:   RunRunning,
+			Started: time.Now(),
+			Request: req.redacted(),
+			Results: []ResultSummary{},
+		},
+	}
+	s.prune()
+	s.runs[run.status.ID] = run
+	s.order = append(s.order, run.status.ID)
+	s.active = run
+	go func() {
+		err := s.run(ctx, run, req, phases)
+		state := RunDone
+		switch {
+		case ctx.Err() != nil:
+			state, err = RunStopped, nil
+		case err != nil:
+			state = RunFailed
+			slog.Error("run failed", "id", run.status.ID, "error", err)
+		}
+		e := RunEvent{Type: "end", State: state}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		run.emit(e, func(st *RunStatus) {
+			now := time.Now()
+			st.State, st.Phase, st.Error, st.Finished = state, "", e.Error, &now
+		})
+		cancel()
+
+		s.mu.Lock()
+		s.active = nil
+		s.mu.Unlock()
+	}()
+	return run, nil
+}
+
+var errRunActive = errors.New("a run is in progress")
+
+// restrictPaths checks the fields of a request from the network that name
+// files on this machine: the pebble directory is deleted on setup and
+// close, and the others are read. Each must lie under dataDir, relative
+// paths are taken from it, and with no dataDir none may be set. Plugin
+// options can't be checked, so they aren't accepted at all. phases may be
+// nil for a request without any.
+func restrictPaths(cfg *kv.BackendConfig, phases *PhaseOptions, dataDir string) error {
+	if len(cfg.Options) > 0 {
+		return errors.New("backend options aren't accepted over the network")
+	}
+	if phases == nil {
+		phases = &PhaseOptions{}
+	}
+	for _, f := range []struct {
+		name string
+		path *string
+	}{
+		{"pebble dir", &cfg.PebbleDir},
+		{"fdb cluster file", &cfg.FDBClusterFile},
+		{"postgres tls ca", &cfg.PostgresTLS.CA},
+		{"postgres tls cert", &cfg.PostgresTLS.Cert},
+		{"postgres tls key", &cfg.PostgresTLS.Key},
+		{"redis tls ca", &cfg.RedisTLS.CA},
+		{"redis tls cert", &cfg.RedisTLS.Cert},
+		{"redis tls key", &cfg.RedisTLS.Key},
+		{"replay", &phases.Replay},
+	} {
+		if *f.path == "" {
+			continue
+		}
+		if dataDir == "" {
+			return fmt.Errorf("%s names a path on this machine; start with -data-dir to allow paths under it", f.name)
+		}
+		p := *f.path
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dataDir, p)
+		}
+		p = filepath.Clean(p)
+		rel, err := filepath.Rel(dataDir, p)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s %s is outside %s", f.name, *f.path, dataDir)
+		}
+		*f.path = p
+	}
+	return nil
+}
+
+// prune drops the oldest runs past maxFinishedRuns. It is called with s.mu
+// held and no run active, so every run it sees has finished.
+func (s *server) prune() {
+	n := len(s.order) - maxFinishedRuns
+	if n <= 0 {
+		return
+	}
+	for _, id := range s.order[:n] {
+		delete(s.runs, id)
+	}
+	s.order = append([]string(nil), s.order[n:]...)
+}
+
+func (s *server) run(ctx context.Context, run *serverRun, req RunRequest, phases []Phase) (err error) {
+	store, err := kv.New(req.Backend, req.Config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if cerr := store.Close(ctx); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	_, _, err = WaitSetup(ctx, store, req.ReadyTimeout)
+	if err != nil {
+		return err
+	}
+
+	for _, ph := range phases {
+		if ctx.Err() != nil {
+			return nil
+		}
+		name := ph.Name
+		run.emit(RunEvent{Type: "phase", Phase: name}, func(st *RunStatus) { st.Phase = name })
+		runner := &PhaseRunner{
+			Duration:    req.Duration,
+			Rate:        req.Rate,
+			Retry:       req.Retry,
+			OpTimeout:   req.Timeout,
+			Latency:     req.Latency,
+			Fault:       req.Fault,
+			Compression: req.Compression,
+			OnSample: func(x Sample) {
+				run.emit(RunEvent{Type: "sample", Phase: name, Sample: &x}, nil)
+			},
+		}
+		r, err := runner.Run(ctx, store, ph, req.Workers)
+		if errors.Is(err, ErrPhaseUnsupported) {
+			run.emit(RunEvent{Type: "skipped", Phase: name}, func(st *RunStatus) { st.Skipped = append(st.Skipped, name) })
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(r.Samples) == 0 {
+			continue
+		}
+		sum := r.Summary()
+		run.emit(RunEvent{Type: "result", Phase: name, Result: &sum}, func(st *RunStatus) { st.Results = append(st.Results, sum) })
+	}
+	return nil
+}
+
+func (s *server) lookup(id string) *serverRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[id]
+}
+
+// ServeHTTP routes:
+//
+//	POST /runs             start a run from a RunRequest
+//	GET  /runs             every run's status
+//	GET  /runs/{id}        one run's status
+//	POST /runs/{id}/stop   cancel a run, keeping its results so far
+//	GET  /runs/{id}/events the run's events as newline-delimited JSON,
+//	                       from its start and until it ends
+//
+// With a token, every request needs an "Authorization: Bearer <token>"
+// header.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "runs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			runs := make([]RunStatus, 0, len(s.order))
+			for _, id := range s.order {
+				runs = append(runs, s.runs[id].Status())
+			}
+			s.mu.Unlock()
+			writeJSON(w, http.StatusOK, runs)
+		case http.MethodPost:
+			var req RunRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			run, err := s.start(req)
+			if errors.Is(err, errRunActive) {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, run.Status())
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	run := s.lookup(parts[1])
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, run.Status())
+	case action == "stop" && r.Method == http.MethodPost:
+		run.cancel()
+		writeJSON(w, http.StatusAccepted, run.Status())
+	case action == "events" && r.Method == http.MethodGet:
+		streamEvents(w, r, run)
+	case action == "" || action == "stop" || action == "events":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamEvents writes run's events to w as they happen, flushing each, and
+// returns after the end event or when the client goes away.
+func streamEvents(w http.ResponseWriter, r *http.Request, run *serverRun) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i := 0; ; {
+		events, changed := run.next(i)
+		for _, e := range events {
+			if enc.Encode(e) != nil {
+				return
+			}
+			if e.Type == "end" {
+				return
+			}
+		}
+		i += len(events)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// RunServer implements the "serve" subcommand: an HTTP API that starts,
+// stops and monitors runs, for orchestration tools and dashboards to drive
+// instead of parsing the CLI's output.
+func RunServer(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8090", "address to serve the API on; any other than loopback needs -token")
+	token := fs.String("token", "", "bearer token clients must send in an Authorization header")
+	dataDir := fs.String("data-dir", "", "directory runs may name files and directories under (pebble dir, replay trace, TLS files, fdb cluster file); none may be named without it")
+	plugins := fs.String("plugins", "", "comma-separated Go plugins to load, which add backends with kv.Register")
+	fs.Parse(args)
+
+	if *token == "" && !loopback(*listen) {
+		return fmt.Errorf("-listen %s is reachable from other machines; set -token", *listen)
+	}
+	if *dataDir != "" {
+		dir, err := filepath.Abs(*dataDir)
+		if err != nil {
+			return err
+		}
+		*dataDir = dir
+	}
+
+	if *plugins != "" {
+		err := kv.LoadPlugins(strings.Split(*plugins, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("serving API", "addr", *listen)
+	return http.ListenAndServe(*listen, &server{
+		dataDir: *dataDir,
+		token:   *token,
+		runs:    make(map[string]*serverRun),
+	})
+}
+
+// loopback reports whether addr only accepts connections from this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
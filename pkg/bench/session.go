@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// runSession models a web session store. Each request is one operation:
+// with probability sp.Churn a login writing a new session, otherwise a read
+// of a recent session, hit or miss recorded apart. A hit is renewed with
+// probability sp.RenewRatio, sliding its expiry; a miss, a session that
+// expired, logs the user in again. New sessions are numbered on from the
+// sp.Keys a load phase wrote, and reads pick among the newest sp.Keys by
+// the distribution, so the oldest fall out of use and are left to expire:
+// a backend that doesn't reclaim expired keys keeps growing.
+func runSession(sp ScenarioPhase, seed int64) Worker {
+	value := fillValue(sp.ValueSize)
+	var next atomic.Int64
+	next.Store(int64(sp.Keys))
+
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		rnd := workerRand(seed, i)
+		recent, err := NewKeyChooser(sp.Distribution, sp.Keys, i, rnd)
+		if err != nil {
+			s.Err(err)
+			return
+		}
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			if rnd.Float64() < sp.Churn {
+				err = store.SetTTL(ctx, workerKey(int(next.Add(1)-1)), value, sp.TTL)
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+				s.OK(p.Since(start))
+				continue
+			}
+
+			key := workerKey(int(next.Load()) - 1 - recent.Next())
+			_, err = store.Get(ctx, key)
+			hit := !missingKey(err)
+			if err != nil && hit {
+				s.Err(err)
+				continue
+			}
+			if !hit || rnd.Float64() < sp.RenewRatio {
+				err = store.SetTTL(ctx, key, value, sp.TTL)
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+			}
+			s.OKLookup(p.Since(start), hit)
+		}
+	}
+}
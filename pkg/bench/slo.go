@@ -0,0 +1,216 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SLO is an assertion every matching phase's result must meet, such as
+// p99<5ms or error_rate<0.1%.
+type SLO struct {
+	Phase  string // empty for every phase
+	Metric string // p50, p90, p99, p999, max, mean, ops or error_rate
+	Op     string // <, <=, > or >=
+	Limit  float64
+	text   string
+}
+
+func (s SLO) String() string {
+	return s.text
+}
+
+// ParseSLO parses [phase:]metric<op>limit. Latency limits are durations,
+// ops a rate and error_rate a fraction or a percentage: "get:p99<5ms",
+// "ops>=10000", "error_rate<0.1%".
+func ParseSLO(s string) (SLO, error) {
+	slo := SLO{text: s}
+	expr := s
+	if phase, rest, ok := strings.Cut(s, ":"); ok {
+		slo.Phase, expr = phase, rest
+	}
+	i := strings.IndexAny(expr, "<>")
+	if i <= 0 {
+		return SLO{}, fmt.Errorf("invalid slo %q, want [phase:]metric<limit", s)
+	}
+	slo.Metric, slo.Op = expr[:i], expr[i:i+1]
+	limit := expr[i+1:]
+	if strings.HasPrefix(limit, "=") {
+		slo.Op += "="
+		limit = limit[1:]
+	}
+
+	var err error
+	switch slo.Metric {
+	case "p50", "p90", "p99", "p999", "max", "mean":
+		var d time.Duration
+		d, err = time.ParseDuration(limit)
+		slo.Limit = float64(d)
+	case "ops":
+		slo.Limit, err = strconv.ParseFloat(limit, 64)
+	case "error_rate":
+		if pct, ok := strings.CutSuffix(limit, "%"); ok {
+			slo.Limit, err = strconv.ParseFloat(pct, 64)
+			slo.Limit /= 100
+		} else {
+			slo.Limit, err = strconv.ParseFloat(limit, 64)
+		}
+	default:
+		return SLO{}, fmt.Errorf("invalid slo metric: %s (want p50, p90, p99, p999, max, mean, ops or error_rate)", slo.Metric)
+	}
+	if err != nil {
+		return SLO{}, fmt.Errorf("invalid slo limit in %q: %w", s, err)
+	}
+	return slo, nil
+}
+
+// value returns the metric of r the SLO is on, latencies in nanoseconds.
+func (s SLO) value(r ResultSummary) float64 {
+	switch s.Metric {
+	case "p50":
+		return float64(r.P50)
+	case "p90":
+		return float64(r.P90)
+	case "p99":
+		return float64(r.P99)
+	case "p999":
+		return float64(r.P999)
+	case "max":
+		return float64(r.Max)
+	case "mean":
+		return float64(r.Mean)
+	case "ops":
+		return r.Ops
+	default: // error_rate
+		if r.Total == 0 {
+			return 0
+		}
+		return float64(r.Err) / float64(r.Total)
+	}
+}
+
+func (s SLO) met(v float64) bool {
+	switch s.Op {
+	case "<":
+		return v < s.Limit
+	case "<=":
+		return v <= s.Limit
+	case ">":
+		return v > s.Limit
+	default:
+		return v >= s.Limit
+	}
+}
+
+func (s SLO) format(v float64) string {
+	switch s.Metric {
+	case "ops":
+		return fmt.Sprintf("%.0f", v)
+	case "error_rate":
+		return fmt.Sprintf("%.3f%%", v*100)
+	default:
+		return time.Duration(v).String()
+	}
+}
+
+// SLOCheck is one SLO checked against one result. An SLO no result matched
+// is checked once, against nothing, and fails, so a misspelled phase can't
+// pass silently.
+type SLOCheck struct {
+	SLO     string  `json:"slo"`
+	Backend string  `json:"backend,omitempty"`
+	Workers int     `json:"workers,omitempty"`
+	Phase   string  `json:"phase,omitempty"`
+	Value   float64 `json:"value"` // latencies in nanoseconds
+	Passed  bool    `json:"passed"`
+}
+
+// CheckSLOs checks every SLO against the results it applies to.
+func CheckSLOs(results []Result, slos []SLO) []SLOCheck {
+	var checks []SLOCheck
+	for _, slo := range slos {
+		matched := false
+		for _, r := range results {
+			if slo.Phase != "" && r.Phase != slo.Phase {
+				continue
+			}
+			matched = true
+			sum := r.Summary()
+			v := slo.value(sum)
+			checks = append(checks, SLOCheck{
+				SLO:     slo.text,
+				Backend: r.Backend,
+				Workers: r.Workers,
+				Phase:   r.Label(),
+				Value:   v,
+				Passed:  slo.met(v),
+			})
+		}
+		if !matched {
+			checks = append(checks, SLOCheck{SLO: slo.text})
+		}
+	}
+	return checks
+}
+
+// PrintSLOs prints the checks and reports whether all of them passed.
+func PrintSLOs(checks []SLOCheck, slos []SLO) bool {
+	byText := make(map[string]SLO, len(slos))
+	for _, s := range slos {
+		byText[s.text] = s
+	}
+
+	passed := true
+	fmt.Printf("==== slo ====\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "slo\tworkers\tphase\tbackend\tvalue\t\t\n")
+	for _, c := range checks {
+		status := "ok"
+		if !c.Passed {
+			status = "FAIL"
+			passed = false
+		}
+		if c.Phase == "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\tno results\t%s\t\n", c.SLO, status)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t\n", c.SLO, c.Workers, c.Phase, c.Backend, byText[c.SLO].format(c.Value), status)
+	}
+	w.Flush()
+	return passed
+}
+
+// CISummary is the single JSON line -quiet prints for CI to parse.
+type CISummary struct {
+	Passed      bool            `json:"passed"`
+	Interrupted bool            `json:"interrupted,omitempty"`
+	Regressed   bool            `json:"regressed,omitempty"`
+	Metadata    Metadata        `json:"metadata"`
+	Results     []ResultSummary `json:"results"`
+	SLOs        []SLOCheck      `json:"slos,omitempty"`
+}
+
+func NewCISummary(md Metadata, results []Result, checks []SLOCheck) CISummary {
+	s := CISummary{Passed: true, Metadata: md, SLOs: checks}
+	for _, r := range results {
+		s.Results = append(s.Results, r.Summary())
+	}
+	for _, c := range checks {
+		if !c.Passed {
+			s.Passed = false
+		}
+	}
+	return s
+}
+
+// Write writes s as one line of JSON.
+func (s CISummary) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s)
+}
@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+// testResult is a one-second result of phase with ok operations taking
+// latency each and errs failed ones.
+func testResult(phase string, latency time.Duration, ok, errs uint64) Result {
+	r := Result{
+		Backend: "memory",
+		Phase:   phase,
+		Workers: 1,
+		Samples: []Sample{{At: time.Second, Elapsed: time.Second, OK: ok, Err: errs}},
+		Stats:   &Stats{issued: ok + errs},
+	}
+	for i := uint64(0); i < ok; i++ {
+		r.Stats.latency.Record(latency)
+	}
+	return r
+}
+
+func TestParseSLO(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		phase  string
+		metric string
+		op     string
+		limit  float64
+	}{
+		{"p99<5ms", "", "p99", "<", float64(5 * time.Millisecond)},
+		{"get:p50<=100us", "get", "p50", "<=", float64(100 * time.Microsecond)},
+		{"max>1s", "", "max", ">", float64(time.Second)},
+		{"set:ops>=10000", "set", "ops", ">=", 10000},
+		{"error_rate<0.1%", "", "error_rate", "<", 0.001},
+		{"error_rate<=0.02", "", "error_rate", "<=", 0.02},
+	} {
+		slo, err := ParseSLO(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if slo.Phase != tc.phase || slo.Metric != tc.metric || slo.Op != tc.op || slo.Limit != tc.limit {
+			t.Errorf("%s: got %s:%s%s%v, want %s:%s%s%v", tc.in,
+				slo.Phase, slo.Metric, slo.Op, slo.Limit, tc.phase, tc.metric, tc.op, tc.limit)
+		}
+		if slo.String() != tc.in {
+			t.Errorf("%s: String() = %s", tc.in, slo)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"p99",
+		"<5ms",
+		"get:",
+		"p42<5ms",
+		"p99<fast",
+		"p99<",
+		"ops>=many",
+		"error_rate<x%",
+	} {
+		if _, err := ParseSLO(in); err == nil {
+			t.Errorf("%q: parsed, want an error", in)
+		}
+	}
+}
+
+func TestSLOMet(t *testing.T) {
+	for _, tc := range []struct {
+		op                  string
+		below, equal, above bool
+	}{
+		{"<", true, false, false},
+		{"<=", true, true, false},
+		{">", false, false, true},
+		{">=", false, true, true},
+	} {
+		slo := SLO{Op: tc.op, Limit: 10}
+		if got := [3]bool{slo.met(9), slo.met(10), slo.met(11)}; got != [3]bool{tc.below, tc.equal, tc.above} {
+			t.Errorf("%s 10: met(9, 10, 11) = %v, want %v", tc.op, got, [3]bool{tc.below, tc.equal, tc.above})
+		}
+	}
+}
+
+func TestCheckSLOs(t *testing.T) {
+	results := []Result{
+		testResult("get", time.Millisecond, 1000, 0),
+		testResult("set", time.Millisecond, 990, 10),
+	}
+	for _, tc := range []struct {
+		slo    string
+		passed []bool // per matched result, in order
+	}{
+		{"get:p99<2ms", []bool{true}},
+		{"p99<500us", []bool{false, false}},
+		{"mean>=900us", []bool{true, true}},
+		{"ops>=1000", []bool{true, true}},
+		{"error_rate<0.5%", []bool{true, false}},
+		{"error_rate<=1%", []bool{true, true}},
+		// a phase nothing ran fails rather than passing unchecked
+		{"gte:p99<1s", []bool{false}},
+	} {
+		slo, err := ParseSLO(tc.slo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks := CheckSLOs(results, []SLO{slo})
+		if len(checks) != len(tc.passed) {
+			t.Errorf("%s: %d checks, want %d", tc.slo, len(checks), len(tc.passed))
+			continue
+		}
+		for i, c := range checks {
+			if c.Passed != tc.passed[i] {
+				t.Errorf("%s on %s: passed = %t (value %v)", tc.slo, c.Phase, c.Passed, c.Value)
+			}
+		}
+
+		want := true
+		for _, p := range tc.passed {
+			want = want && p
+		}
+		if s := NewCISummary(Metadata{}, results, checks); s.Passed != want {
+			t.Errorf("%s: summary passed = %t, want %t", tc.slo, s.Passed, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// SoakConfig runs one mixed workload for hours, reported in checkpoints.
+type SoakConfig struct {
+	Duration  time.Duration // total length of the run
+	Interval  time.Duration // length of each checkpoint
+	Keys      int
+	ReadRatio float64
+	ValueSize int
+	Workers   int
+	Seed      int64
+
+	// Degradation is the fraction by which a checkpoint's throughput may
+	// fall below, or its p99 rise above, the first checkpoint's before it
+	// is reported as degraded.
+	Degradation float64
+}
+
+// soakCheckpoint is one interval of a soak run.
+type soakCheckpoint struct {
+	At     time.Duration // offset of the checkpoint's end from the run's start
+	Ops    float64
+	P99    time.Duration
+	Errors uint64
+	Memory int64 // server memory at the end, 0 if the backend doesn't report it
+}
+
+// RunSoak runs the workload in back-to-back checkpoints of sc.Interval,
+// each a phase of its own with fresh stats, over one keyspace written
+// throughout, so slow drifts like table bloat, fragmentation or memory
+// pressure show as a trend across checkpoints. An interrupt ends it early
+// with the checkpoints so far.
+func RunSoak(ctx context.Context, pr *PhaseRunner, store kv.KV, sc SoakConfig) ([]Result, error) {
+	fmt.Printf("soak: %s for %s, checkpoints every %s\n", store.Name(), sc.Duration, sc.Interval)
+	run := runKeyspace(ScenarioPhase{
+		Op:           "mixed",
+		Keys:         sc.Keys,
+		Distribution: "uniform",
+		ValueSize:    sc.ValueSize,
+		ReadRatio:    sc.ReadRatio,
+	}, sc.Seed)
+
+	var (
+		results []Result
+		cps     []soakCheckpoint
+		elapsed time.Duration
+	)
+	for n := 1; elapsed < sc.Duration && ctx.Err() == nil; n++ {
+		d := min(sc.Interval, sc.Duration-elapsed)
+		r, err := pr.Run(ctx, store, Phase{Name: fmt.Sprintf("soak-%d", n), Run: run, duration: d}, sc.Workers)
+		if err != nil {
+			return results, err
+		}
+		elapsed += d
+		results = append(results, r)
+
+		cp := soakCheckpoint{
+			At:     elapsed,
+			Ops:    r.Ops(),
+			P99:    r.Stats.latency.Quantile(0.99),
+			Errors: r.Samples[len(r.Samples)-1].Err,
+		}
+		if r.Footprint != nil {
+			cp.Memory = r.Footprint.Memory
+		}
+		cps = append(cps, cp)
+		printSoakCheckpoint(cp, cps[0])
+		if cp.degraded(cps[0], sc.Degradation) {
+			slog.Warn("soak degraded", "backend", store.Name(), "at", cp.At, "ops", math.Round(cp.Ops), "first_ops", math.Round(cps[0].Ops), "p99", cp.P99, "first_p99", cps[0].P99)
+		}
+	}
+	printSoakDrift(store.Name(), cps, sc.Degradation)
+	return results, nil
+}
+
+func (cp soakCheckpoint) degraded(first soakCheckpoint, by float64) bool {
+	return cp.Ops < first.Ops*(1-by) || float64(cp.P99) > float64(first.P99)*(1+by)
+}
+
+func printSoakCheckpoint(cp, first soakCheckpoint) {
+	fmt.Printf("checkpoint %s: ops: %.1f/s (%+.1f%%) p99: %s (%s) err: %d",
+		cp.At,
+		cp.Ops,
+		percentDelta(first.Ops, cp.Ops),
+		cp.P99,
+		signedDuration(cp.P99-first.P99),
+		cp.Errors,
+	)
+	if cp.Memory > 0 {
+		fmt.Printf(" server memory: %s", formatBytes(cp.Memory))
+	}
+	fmt.Println()
+}
+
+// printSoakDrift summarizes how the run changed from its first checkpoint to
+// its last, with the throughput trend fitted over all of them, so a slow
+// decline isn't hidden by the noise of single checkpoints.
+func printSoakDrift(backend string, cps []soakCheckpoint, by float64) {
+	if len(cps) == 0 {
+		return
+	}
+	first, last := cps[0], cps[len(cps)-1]
+	worst := first
+	var degraded int
+	for _, cp := range cps {
+		if cp.Ops < worst.Ops {
+			worst = cp
+		}
+		if cp.degraded(first, by) {
+			degraded++
+		}
+	}
+
+	fmt.Printf("==== soak drift: %s ====\n", backend)
+	fmt.Printf("checkpoints: %d, degraded: %d (by more than %.0f%%)\n", len(cps), degraded, 100*by)
+	fmt.Printf("ops: %.1f/s -> %.1f/s (%+.1f%%), worst %.1f/s at %s\n", first.Ops, last.Ops, percentDelta(first.Ops, last.Ops), worst.Ops, worst.At)
+	fmt.Printf("p99: %s -> %s (%s)\n", first.P99, last.P99, signedDuration(last.P99-first.P99))
+	if len(cps) > 2 && first.Ops > 0 {
+		fmt.Printf("ops trend: %+.1f%% per hour\n", 100*opsSlope(cps)*float64(time.Hour)/first.Ops)
+	}
+	if first.Memory > 0 && last.Memory > 0 {
+		fmt.Printf("server memory: %s -> %s (%+.1f%%)\n", formatBytes(first.Memory), formatBytes(last.Memory), percentDelta(float64(first.Memory), float64(last.Memory)))
+	}
+}
+
+// opsSlope fits a least-squares line to throughput over time and returns
+// its slope in ops/s per nanosecond.
+func opsSlope(cps []soakCheckpoint) float64 {
+	var sx, sy, sxx, sxy float64
+	for _, cp := range cps {
+		x := float64(cp.At)
+		sx += x
+		sy += cp.Ops
+		sxx += x * x
+		sxy += x * cp.Ops
+	}
+	n := float64(len(cps))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
@@ -0,0 +1,269 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Stats aggregates a phase's measurements. Each worker records into its own
+// WorkerStats shard so the hot path never contends on shared counters; shards
+// are summed when sampled and merged once the phase ends.
+type Stats struct {
+	workers []*WorkerStats
+
+	// set by Merge, or directly for results merged from remote agents
+	latency   Histogram
+	anomalies uint64
+	errors    ErrorCounts
+	retried   uint64 // operations that succeeded after retrying
+	retries   uint64
+	timeouts  uint64 // operations cut off by the op timeout
+	issued    uint64 // operations the pacers let start
+
+	compression *CompressionStats // nil unless values were compressed
+	faults      *FaultStats       // nil unless faults were injected
+	lookups     *LookupStats      // nil unless the phase split hits from misses
+	queue       *QueueStats       // nil unless the phase pushed or popped
+	mirror      *MirrorStats      // nil unless writes were mirrored
+	maxAt       time.Duration     // offset from the phase start of the slowest operation
+}
+
+func NewStats(n int, metrics *phaseMetrics, log *errorLog, clock Clock) *Stats {
+	s := &Stats{workers: make([]*WorkerStats, n)}
+	start := clock.Now()
+	for i := range s.workers {
+		s.workers[i] = &WorkerStats{metrics: metrics, log: log, clock: clock, start: start}
+	}
+	return s
+}
+
+func (s *Stats) Worker(i int) *WorkerStats {
+	return s.workers[i]
+}
+
+func (s *Stats) Snapshot() (ok, err uint64) {
+	for _, w := range s.workers {
+		ok += atomic.LoadUint64(&w.ok)
+		err += atomic.LoadUint64(&w.err)
+	}
+	return ok, err
+}
+
+func (s *Stats) Anomalies() uint64 {
+	return s.anomalies
+}
+
+// Retried returns how many operations succeeded only after retrying, and
+// how many retries were made; failures after the last retry count as errors.
+func (s *Stats) Retried() (ops, retries uint64) {
+	return s.retried, s.retries
+}
+
+// Timeouts returns how many operations ran past the op timeout.
+func (s *Stats) Timeouts() uint64 {
+	return s.timeouts
+}
+
+// Issued returns how many operations were started; set once the phase ends.
+func (s *Stats) Issued() uint64 {
+	return s.issued
+}
+
+// Compression returns the phase's compression stats, or nil.
+func (s *Stats) Compression() *CompressionStats {
+	return s.compression
+}
+
+// Faults returns what fault injection did to the phase, or nil.
+func (s *Stats) Faults() *FaultStats {
+	return s.faults
+}
+
+// Lookups returns the phase's read latencies split by hit and miss, or nil.
+func (s *Stats) Lookups() *LookupStats {
+	return s.lookups
+}
+
+// Mirror returns the phase's mirrored operations, or nil.
+func (s *Stats) Mirror() *MirrorStats {
+	return s.mirror
+}
+
+// Queue returns the phase's pushes and pops, or nil.
+func (s *Stats) Queue() *QueueStats {
+	return s.queue
+}
+
+// Errors returns the phase's errors by class.
+func (s *Stats) Errors() ErrorCounts {
+	return s.errors
+}
+
+// swapWindows starts a new sampling interval on every worker and returns the
+// merged latencies of the interval that just ended.
+func (s *Stats) swapWindows() *Histogram {
+	h := new(Histogram)
+	for _, w := range s.workers {
+		old := &w.windows[w.window.Load()]
+		w.window.Store(1 - w.window.Load())
+		h.Merge(old)
+		old.Reset()
+	}
+	return h
+}
+
+// Merge folds every worker's latency histogram into s.latency. Call it once
+// all workers have stopped.
+func (s *Stats) Merge() {
+	var max time.Duration
+	for _, w := range s.workers {
+		s.latency.Merge(&w.latency)
+		s.anomalies += atomic.LoadUint64(&w.anomaly)
+		if w.lookups != nil {
+			if s.lookups == nil {
+				s.lookups = &LookupStats{}
+			}
+			s.lookups.Merge(w.lookups)
+		}
+		if w.queue != nil {
+			if s.queue == nil {
+				s.queue = &QueueStats{}
+			}
+			s.queue.Merge(w.queue)
+		}
+		for c := range w.errs {
+			s.errors[c] += atomic.LoadUint64(&w.errs[c])
+		}
+		if w.max > max {
+			max, s.maxAt = w.max, w.maxAt
+		}
+	}
+}
+
+// WorkerSummary is one worker's share of a phase.
+type WorkerSummary struct {
+	OK   uint64
+	Err  uint64
+	Mean time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// Workers returns per-worker totals; call it after Merge. Results merged
+// from remote agents have none.
+func (s *Stats) Workers() []WorkerSummary {
+	ws := make([]WorkerSummary, len(s.workers))
+	for i, w := range s.workers {
+		ws[i] = WorkerSummary{
+			OK:   atomic.LoadUint64(&w.ok),
+			Err:  atomic.LoadUint64(&w.err),
+			Mean: w.latency.Mean(),
+			P99:  w.latency.Quantile(0.99),
+			Max:  w.latency.Max(),
+		}
+	}
+	return ws
+}
+
+// MaxAt returns when, relative to the phase start, the operation with the
+// exact maximum latency completed.
+func (s *Stats) MaxAt() time.Duration {
+	return s.maxAt
+}
+
+type WorkerStats struct {
+	ok      uint64
+	err     uint64
+	errs    ErrorCounts // err broken down by class
+	anomaly uint64
+	latency Histogram
+	lookups *LookupStats // allocated on the first OKLookup
+	queue   *QueueStats  // allocated on the first OKPush or OKPop
+	metrics *phaseMetrics
+	log     *errorLog
+
+	// the worker's slowest operation; only the worker writes these and
+	// they're read after it stops
+	clock Clock
+	start time.Time
+	max   time.Duration
+	maxAt time.Duration
+
+	// windows are double-buffered per-interval histograms; window indexes the
+	// one being recorded into and is flipped by the sampler on each tick.
+	windows [2]Histogram
+	window  atomic.Uint32
+}
+
+func (s *WorkerStats) OK(latency time.Duration) {
+	atomic.AddUint64(&s.ok, 1)
+	s.latency.Record(latency)
+	if latency > s.max {
+		s.max, s.maxAt = latency, s.clock.Since(s.start)
+	}
+	s.windows[s.window.Load()].Record(latency)
+	if s.metrics != nil {
+		s.metrics.OK(latency)
+	}
+}
+
+// OKLookup records a successful read, counting its latency apart by
+// whether the key was found.
+func (s *WorkerStats) OKLookup(latency time.Duration, hit bool) {
+	s.OK(latency)
+	if s.lookups == nil {
+		s.lookups = &LookupStats{}
+	}
+	if hit {
+		s.lookups.Hit.Record(latency)
+	} else {
+		s.lookups.Miss.Record(latency)
+	}
+}
+
+// OKPush records a successful push onto a queue.
+func (s *WorkerStats) OKPush(latency time.Duration) {
+	s.OK(latency)
+	if s.queue == nil {
+		s.queue = &QueueStats{}
+	}
+	s.queue.Push.Record(latency)
+}
+
+// OKPop records a successful pop off a queue, with how long the value
+// waited in it; a pop that found the queue empty has no value.
+func (s *WorkerStats) OKPop(latency time.Duration, ok bool, lag time.Duration) {
+	s.OK(latency)
+	if s.queue == nil {
+		s.queue = &QueueStats{}
+	}
+	if !ok {
+		s.queue.Empty++
+		return
+	}
+	s.queue.Pop.Record(latency)
+	s.queue.Lag.Record(lag)
+}
+
+func (s *WorkerStats) Err(err error) {
+	// operations cut off by the end of the phase aren't failures
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+	s.log.Log(slog.LevelError, "operation failed", err)
+	atomic.AddUint64(&s.err, 1)
+	atomic.AddUint64(&s.errs[classifyError(err)], 1)
+	if s.metrics != nil {
+		s.metrics.Err()
+	}
+}
+
+// Anomaly records a correctness violation. Anomalies are counted apart from
+// errors since the operation itself succeeded.
+func (s *WorkerStats) Anomaly(err error) {
+	s.log.Log(slog.LevelWarn, "anomaly", err)
+	atomic.AddUint64(&s.anomaly, 1)
+}
@@ -0,0 +1,229 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// streamerOf returns kv's own streaming API, or one that splits values into
+// chunks of chunkSize bytes.
+func streamerOf(store kv.KV, chunkSize int) kv.BlobStreamer {
+	if s, ok := store.(kv.BlobStreamer); ok {
+		return s
+	}
+	return &chunkedStreamer{kv: store, chunkSize: chunkSize}
+}
+
+// chunkedStreamer stores a value of any size as binary chunks under
+// "<key>.<n>", followed by a manifest under key with the chunk count and
+// size. The manifest is written last, so readers never see a value whose
+// chunks aren't all stored; only one chunk is held in memory at a time.
+type chunkedStreamer struct {
+	kv        kv.KV
+	chunkSize int
+}
+
+func chunkKey(key string, n int) string {
+	return key + "." + strconv.Itoa(n)
+}
+
+func (c *chunkedStreamer) SetStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := make([]byte, c.chunkSize)
+	var size int64
+	var chunks int
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if serr := c.kv.SetBytes(ctx, chunkKey(key, chunks), buf[:n]); serr != nil {
+				return size, serr
+			}
+			size += int64(n)
+			chunks++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+	return size, c.kv.Set(ctx, key, fmt.Sprintf("chunks=%d size=%d", chunks, size))
+}
+
+func (c *chunkedStreamer) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	m, err := c.kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var chunks int
+	var size int64
+	_, err = fmt.Sscanf(m, "chunks=%d size=%d", &chunks, &size)
+	if err != nil {
+		return nil, fmt.Errorf("stream %s: bad manifest %q", key, m)
+	}
+	return &chunkReader{ctx: ctx, kv: c.kv, key: key, chunks: chunks, left: size}, nil
+}
+
+// chunkReader fetches a chunked value's chunks as they are read.
+type chunkReader struct {
+	ctx    context.Context
+	kv     kv.KV
+	key    string
+	chunks int   // chunks in the value
+	next   int   // index of the next chunk to fetch
+	left   int64 // bytes not read yet, per the manifest
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next == r.chunks {
+			if r.left != 0 {
+				return 0, fmt.Errorf("stream %s: %d bytes short", r.key, r.left)
+			}
+			return 0, io.EOF
+		}
+		b, err := r.kv.GetBytes(r.ctx, chunkKey(r.key, r.next))
+		if err != nil {
+			return 0, err
+		}
+		if len(b) == 0 {
+			return 0, fmt.Errorf("stream %s: chunk %d missing", r.key, r.next)
+		}
+		r.buf = b
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.left -= int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return nil
+}
+
+// patternReader produces size bytes of a repeating pattern without holding
+// the value in memory.
+type patternReader struct {
+	pattern string
+	off     int64
+	size    int64
+}
+
+func newPatternReader(i int, size int64) *patternReader {
+	return &patternReader{pattern: streamPattern(i), size: size}
+}
+
+// streamPattern is worker i's value pattern, so a worker reading another
+// worker's value is caught.
+func streamPattern(i int) string {
+	return strings.Repeat("stream_"+strconv.Itoa(i)+"/", 64)
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off == r.size {
+		return 0, io.EOF
+	}
+	if rest := r.size - r.off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	for n := 0; n < len(p); {
+		k := int(r.off % int64(len(r.pattern)))
+		c := copy(p[n:], r.pattern[k:])
+		n += c
+		r.off += int64(c)
+	}
+	return len(p), nil
+}
+
+// patternChecker is the write side of patternReader: it checks that
+// everything written to it is the pattern, in order.
+type patternChecker struct {
+	pattern string
+	off     int64
+}
+
+func (w *patternChecker) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		k := int(w.off % int64(len(w.pattern)))
+		c := min(len(p)-n, len(w.pattern)-k)
+		if string(p[n:n+c]) != w.pattern[k:k+c] {
+			return n, mismatchf("wrong bytes at offset %d", w.off)
+		}
+		n += c
+		w.off += int64(c)
+	}
+	return len(p), nil
+}
+
+func streamKey(i int) string {
+	return "stream_" + strconv.Itoa(i)
+}
+
+// runSetStream writes a size-byte value per worker through the streaming
+// API, never holding more than a chunk of it.
+func runSetStream(size int64, chunkSize int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		st := streamerOf(store, chunkSize)
+		key := streamKey(i)
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			_, err = st.SetStream(ctx, key, newPatternReader(i, size))
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+// runGetStream reads each worker's value back through the streaming API and
+// checks every byte as it arrives.
+func runGetStream(size int64, chunkSize int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		st := streamerOf(store, chunkSize)
+		key := streamKey(i)
+		pattern := streamPattern(i)
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			n, err := readStream(ctx, st, key, &patternChecker{pattern: pattern})
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			if n != size {
+				s.Err(mismatchf("get-stream %s: read %d bytes, expected %d", key, n, size))
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func readStream(ctx context.Context, st kv.BlobStreamer, key string, w io.Writer) (int64, error) {
+	rc, err := st.GetStream(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
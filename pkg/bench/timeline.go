@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func WriteTimeline(path string, results []Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"backend", "workers", "phase", "t_sec", "ops", "err", "p50_us", "p99_us", "max_us", "events"})
+	for _, r := range results {
+		var prev Sample
+		for _, x := range r.Samples {
+			w.Write([]string{
+				r.Backend,
+				strconv.Itoa(r.Workers),
+				r.Phase,
+				strconv.FormatFloat(x.At.Seconds(), 'f', -1, 64),
+				strconv.FormatUint((x.OK+x.Err)-(prev.OK+prev.Err), 10),
+				strconv.FormatUint(x.Err-prev.Err, 10),
+				micros(x.P50),
+				micros(x.P99),
+				micros(x.Max),
+				strings.Join(x.Events, ";"),
+			})
+			prev = x
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func micros(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', 1, 64)
+}
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders per-interval throughput scaled to the busiest interval.
+func Sparkline(samples []Sample) string {
+	ops := make([]uint64, len(samples))
+	var prev Sample
+	var max uint64
+	for i, x := range samples {
+		ops[i] = (x.OK + x.Err) - (prev.OK + prev.Err)
+		if ops[i] > max {
+			max = ops[i]
+		}
+		prev = x
+	}
+
+	rs := make([]rune, len(ops))
+	for i, v := range ops {
+		if max == 0 {
+			rs[i] = sparkTicks[0]
+			continue
+		}
+		rs[i] = sparkTicks[v*uint64(len(sparkTicks)-1)/max]
+	}
+	return string(rs)
+}
@@ -0,0 +1,151 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// errOpTimeout fails an operation that ran past -op-timeout. It doesn't
+// wrap context.DeadlineExceeded, which would pass for the end of the phase.
+var errOpTimeout = errors.New("operation timed out")
+
+// timeoutKV gives every operation on next a deadline of its own, so a call
+// the backend never answers fails on its own instead of holding its worker
+// until the phase ends. Each retry is a new operation with a new deadline.
+type timeoutKV struct {
+	next    kv.KV
+	timeout time.Duration
+
+	timeouts atomic.Uint64
+}
+
+func NewTimeoutKV(next kv.KV, timeout time.Duration) *timeoutKV {
+	return &timeoutKV{next: next, timeout: timeout}
+}
+
+// Timeouts returns how many operations ran past the timeout.
+func (t *timeoutKV) Timeouts() uint64 {
+	return t.timeouts.Load()
+}
+
+// bounded runs op with the timeout. An operation that failed once its own
+// deadline passed, rather than the phase's, fails with errOpTimeout,
+// whatever error the client made of the deadline.
+func bounded[T any](ctx context.Context, t *timeoutKV, op func(ctx context.Context) (T, error)) (T, error) {
+	octx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	v, err := op(octx)
+	if err != nil && ctx.Err() == nil && errors.Is(octx.Err(), context.DeadlineExceeded) {
+		t.timeouts.Add(1)
+		return v, fmt.Errorf("%w after %s: %v", errOpTimeout, t.timeout, err)
+	}
+	return v, err
+}
+
+func (t *timeoutKV) Name() string {
+	return t.next.Name()
+}
+
+func (t *timeoutKV) Setup(ctx context.Context) error {
+	return t.next.Setup(ctx)
+}
+
+func (t *timeoutKV) Close(ctx context.Context) error {
+	return t.next.Close(ctx)
+}
+
+func (t *timeoutKV) Set(ctx context.Context, key, value string) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.next.Set(ctx, key, value)
+	})
+	return err
+}
+
+func (t *timeoutKV) Get(ctx context.Context, key string) (string, error) {
+	return bounded(ctx, t, func(ctx context.Context) (string, error) {
+		return t.next.Get(ctx, key)
+	})
+}
+
+func (t *timeoutKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.next.SetBytes(ctx, key, value)
+	})
+	return err
+}
+
+func (t *timeoutKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return bounded(ctx, t, func(ctx context.Context) ([]byte, error) {
+		return t.next.GetBytes(ctx, key)
+	})
+}
+
+func (t *timeoutKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.next.SetTTL(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (t *timeoutKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return bounded(ctx, t, func(ctx context.Context) (bool, error) {
+		return t.next.SetNX(ctx, key, value)
+	})
+}
+
+func (t *timeoutKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return bounded(ctx, t, func(ctx context.Context) (bool, error) {
+		return t.next.CompareAndSwap(ctx, key, old, new)
+	})
+}
+
+func (t *timeoutKV) Incr(ctx context.Context, key string) (int64, error) {
+	return bounded(ctx, t, func(ctx context.Context) (int64, error) {
+		return t.next.Incr(ctx, key)
+	})
+}
+
+func (t *timeoutKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return bounded(ctx, t, func(ctx context.Context) (int64, error) {
+		return windowCounterOf(t.next).IncrWindow(ctx, key, ttl)
+	})
+}
+
+func (t *timeoutKV) Push(ctx context.Context, queue, value string) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, queueOf(t.next).Push(ctx, queue, value)
+	})
+	return err
+}
+
+func (t *timeoutKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	p, err := bounded(ctx, t, func(ctx context.Context) (popped, error) {
+		return pop(ctx, t.next, queue, timeout)
+	})
+	return p.value, p.ok, err
+}
+
+func (t *timeoutKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return bounded(ctx, t, func(ctx context.Context) ([]string, error) {
+		return t.next.Scan(ctx, prefix, limit)
+	})
+}
+
+func (t *timeoutKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.next.TxnSet(ctx, kvs)
+	})
+	return err
+}
+
+func (t *timeoutKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	_, err := bounded(ctx, t, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.next.BulkLoad(ctx, kvs)
+	})
+	return err
+}
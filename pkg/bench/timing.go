@@ -0,0 +1,71 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+)
+
+// timingStage is one stage of an operation's time, per operation.
+type timingStage struct {
+	name string
+	d    time.Duration
+}
+
+// timingStages splits a phase's mean latency into the stages the backend
+// timed; "other" is the rest, spent in the client library above the
+// driver and in the benchmark itself.
+func timingStages(r Result) []timingStage {
+	t, n := r.Timings, r.Total()
+	if t == nil || n == 0 {
+		return nil
+	}
+	per := func(d time.Duration) time.Duration { return d / time.Duration(n) }
+	stages := []timingStage{
+		{"pool", per(t.PoolWait)},
+		{"connect", per(t.Connect)},
+		{"query", per(t.Query)},
+		{"fetch", per(t.Fetch)},
+	}
+	other := r.Stats.latency.Mean()
+	for _, s := range stages {
+		other -= s.d
+	}
+	return append(stages, timingStage{"other", max(other, 0)})
+}
+
+// printTimings reports where the mean operation spent its time, which tells
+// pool starvation (pool, connect) from a slow server (query).
+func printTimings(r Result) {
+	stages := timingStages(r)
+	if stages == nil {
+		return
+	}
+	var total time.Duration
+	for _, s := range stages {
+		total += s.d
+	}
+	fmt.Printf("timing per op:")
+	for _, s := range stages {
+		fmt.Printf(" %s=%s (%.0f%%)", s.name, s.d, 100*float64(s.d)/float64(max(total, 1)))
+	}
+	t := r.Timings
+	fmt.Printf("; pool waits=%d new conns=%d statements=%.1f/op\n",
+		t.PoolWaits, t.Connects, float64(t.Queries)/float64(r.Total()))
+}
+
+// WriteTimingFolded writes each phase's time by stage in the folded stack
+// format flamegraph.pl and speedscope read, one backend;workers;phase;stage
+// line per stage weighted by its total microseconds.
+func WriteTimingFolded(path string, results []Result) error {
+	return writeFile(path, func(w *bufio.Writer) error {
+		for _, r := range results {
+			stages := timingStages(r)
+			for _, s := range stages {
+				total := s.d * time.Duration(r.Total())
+				fmt.Fprintf(w, "%s;workers=%d;%s;%s %d\n", r.Backend, r.Workers, r.Label(), s.name, total.Microseconds())
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// Topology is a network path to the backends, such as a local HAProxy,
+// Envoy or PgBouncer sidecar with its own DSN, or the servers' Unix sockets
+// (a postgres URL with a host=/socket/dir parameter, a redis socket path).
+// Empty fields use the direct connection settings.
+type Topology struct {
+	Name        string `yaml:"name"`
+	PostgresURL string `yaml:"postgres_url"`
+	RedisAddr   string `yaml:"redis_addr"`
+}
+
+// direct reports whether t connects straight to the backends.
+func (t Topology) direct() bool {
+	return t.Name == "" || t.Name == "direct"
+}
+
+func (t Topology) Config(cfg kv.BackendConfig) kv.BackendConfig {
+	if t.PostgresURL != "" {
+		cfg.PostgresURL = t.PostgresURL
+	}
+	if t.RedisAddr != "" {
+		cfg.RedisAddr = t.RedisAddr
+	}
+	return cfg
+}
+
+// Label names the backend as reached through t, e.g. "redis@envoy".
+func (t Topology) Label(backend string) string {
+	if t.direct() {
+		return backend
+	}
+	return backend + "@" + t.Name
+}
+
+// target is one backend reached through one topology, in one durability
+// mode.
+type target struct {
+	Backend    string
+	topology   Topology
+	durability DurabilityMode
+}
+
+func (t target) Config(cfg kv.BackendConfig) kv.BackendConfig {
+	return t.durability.Config(t.topology.Config(cfg))
+}
+
+// Label names the backend as run for t, e.g. "redis+aof-always@envoy".
+func (t target) Label(backend string) string {
+	return t.topology.Label(t.durability.Label(backend))
+}
+
+// Targets runs every topology and durability mode of a backend back to back,
+// so the numbers being compared are measured close together. Without
+// durabilityMatrix, each backend runs only as configured.
+func Targets(backends []string, topologies []Topology, durabilityMatrix bool) []target {
+	if len(topologies) == 0 {
+		topologies = []Topology{{}}
+	}
+	var ts []target
+	for _, b := range backends {
+		modes := []DurabilityMode{{}}
+		if durabilityMatrix {
+			modes = durabilityModes(b)
+		}
+		for _, t := range topologies {
+			for _, m := range modes {
+				ts = append(ts, target{Backend: b, topology: t, durability: m})
+			}
+		}
+	}
+	return ts
+}
+
+// PrintTopologyComparison compares every proxied result with the direct
+// result of the same backend, workers and phase.
+func PrintTopologyComparison(results []Result) {
+	type key struct {
+		backend string
+		workers int
+		phase   string
+	}
+	direct := make(map[key]*Result)
+	for i := range results {
+		r := &results[i]
+		if !strings.Contains(r.Backend, "@") {
+			direct[key{r.Backend, r.Workers, r.Label()}] = r
+		}
+	}
+
+	var printed bool
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, r := range results {
+		backend, _, ok := strings.Cut(r.Backend, "@")
+		if !ok {
+			continue
+		}
+		d := direct[key{backend, r.Workers, r.Label()}]
+		if d == nil {
+			continue
+		}
+		if !printed {
+			fmt.Printf("==== direct vs proxied ====\n")
+			fmt.Fprintf(w, "workers\tphase\tvia\tops\tdirect ops\tΔops\tp99\tdirect p99\tΔp99\t\n")
+			printed = true
+		}
+		p99, dp99 := r.Stats.latency.Quantile(0.99), d.Stats.latency.Quantile(0.99)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%.0f\t%+.1f%%\t%s\t%s\t%s\t\n",
+			r.Workers,
+			r.Label(),
+			r.Backend,
+			r.Ops(),
+			d.Ops(),
+			percentDelta(d.Ops(), r.Ops()),
+			p99,
+			dp99,
+			signedDuration(p99-dp99),
+		)
+	}
+	w.Flush()
+}
+
+func signedDuration(d time.Duration) string {
+	if d >= 0 {
+		return "+" + d.String()
+	}
+	return d.String()
+}
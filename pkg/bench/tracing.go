@@ -0,0 +1,211 @@
+package bench
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// NewTracerProvider exports a sampled fraction of spans over OTLP/HTTP to
+// endpoint (host:port of a collector).
+func NewTracerProvider(ctx context.Context, endpoint string, fraction float64) (*sdktrace.TracerProvider, error) {
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(fraction)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("kv-test-perf"))),
+	), nil
+}
+
+// tracedKV emits a span around every Set and Get of the wrapped backend. The
+// span context is passed down so instrumented drivers nest under it.
+type tracedKV struct {
+	next   kv.KV
+	tracer trace.Tracer
+}
+
+func NewTracedKV(next kv.KV, tracer trace.Tracer) kv.KV {
+	return &tracedKV{next: next, tracer: tracer}
+}
+
+func (t *tracedKV) Name() string {
+	return t.next.Name()
+}
+
+func (t *tracedKV) Setup(ctx context.Context) error {
+	return t.next.Setup(ctx)
+}
+
+func (t *tracedKV) Close(ctx context.Context) error {
+	return t.next.Close(ctx)
+}
+
+func (t *tracedKV) Set(ctx context.Context, key, value string) error {
+	ctx, span := t.start(ctx, "kv.Set", key)
+	defer span.End()
+
+	err := t.next.Set(ctx, key, value)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	ctx, span := t.start(ctx, "kv.SetTTL", key)
+	defer span.End()
+
+	err := t.next.SetTTL(ctx, key, value, ttl)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) Get(ctx context.Context, key string) (string, error) {
+	ctx, span := t.start(ctx, "kv.Get", key)
+	defer span.End()
+
+	v, err := t.next.Get(ctx, key)
+	recordSpanError(span, err)
+	return v, err
+}
+
+func (t *tracedKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	ctx, span := t.start(ctx, "kv.SetBytes", key)
+	defer span.End()
+
+	err := t.next.SetBytes(ctx, key, value)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := t.start(ctx, "kv.GetBytes", key)
+	defer span.End()
+
+	v, err := t.next.GetBytes(ctx, key)
+	recordSpanError(span, err)
+	return v, err
+}
+
+func (t *tracedKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	ctx, span := t.start(ctx, "kv.SetNX", key)
+	defer span.End()
+
+	ok, err := t.next.SetNX(ctx, key, value)
+	recordSpanError(span, err)
+	return ok, err
+}
+
+func (t *tracedKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	ctx, span := t.start(ctx, "kv.CompareAndSwap", key)
+	defer span.End()
+
+	ok, err := t.next.CompareAndSwap(ctx, key, old, new)
+	recordSpanError(span, err)
+	return ok, err
+}
+
+func (t *tracedKV) Incr(ctx context.Context, key string) (int64, error) {
+	ctx, span := t.start(ctx, "kv.Incr", key)
+	defer span.End()
+
+	n, err := t.next.Incr(ctx, key)
+	recordSpanError(span, err)
+	return n, err
+}
+
+func (t *tracedKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	ctx, span := t.start(ctx, "kv.IncrWindow", key)
+	defer span.End()
+
+	n, err := windowCounterOf(t.next).IncrWindow(ctx, key, ttl)
+	recordSpanError(span, err)
+	return n, err
+}
+
+func (t *tracedKV) Push(ctx context.Context, queue, value string) error {
+	ctx, span := t.start(ctx, "kv.Push", queue)
+	defer span.End()
+
+	err := queueOf(t.next).Push(ctx, queue, value)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	ctx, span := t.start(ctx, "kv.Pop", queue)
+	defer span.End()
+
+	v, ok, err := queueOf(t.next).Pop(ctx, queue, timeout)
+	recordSpanError(span, err)
+	return v, ok, err
+}
+
+func (t *tracedKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	ctx, span := t.start(ctx, "kv.Scan", prefix)
+	defer span.End()
+
+	keys, err := t.next.Scan(ctx, prefix, limit)
+	recordSpanError(span, err)
+	return keys, err
+}
+
+func (t *tracedKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	ctx, span := t.tracer.Start(ctx, "kv.TxnSet",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("kv.backend", t.next.Name()),
+			attribute.Int("kv.keys", len(kvs)),
+		),
+	)
+	defer span.End()
+
+	err := t.next.TxnSet(ctx, kvs)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	ctx, span := t.tracer.Start(ctx, "kv.BulkLoad",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("kv.backend", t.next.Name()),
+			attribute.Int("kv.keys", len(kvs)),
+		),
+	)
+	defer span.End()
+
+	err := t.next.BulkLoad(ctx, kvs)
+	recordSpanError(span, err)
+	return err
+}
+
+func (t *tracedKV) start(ctx context.Context, name, key string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("kv.backend", t.next.Name()),
+			attribute.String("kv.key", key),
+		),
+	)
+}
+
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// verifyKeys is the number of keys the verify phase's writers and readers
+// share.
+const verifyKeys = 64
+
+// verifyState is shared by the workers of one verify run. committed[k] is
+// the latest version of key k whose Set was acknowledged.
+type verifyState struct {
+	keys      []string
+	committed []atomic.Int64
+}
+
+// newVerify builds a verify run. The first verifyKeys even workers are
+// writers: each owns one key, writes increasing versions to it and reads
+// every write back. The other workers cycle through the keys and check each
+// value is intact, for the right key, no older than the last acknowledged
+// write, and no older than what the same reader saw before. Violations are
+// counted as anomalies, not errors.
+func newVerify() Worker {
+	vs := &verifyState{
+		keys:      make([]string, verifyKeys),
+		committed: make([]atomic.Int64, verifyKeys),
+	}
+	for k := range vs.keys {
+		vs.keys[k] = "verify_" + strconv.Itoa(k)
+	}
+
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		if i%2 == 0 && i/2 < verifyKeys {
+			vs.write(ctx, store, i/2, s, p)
+		} else {
+			vs.read(ctx, store, i, s, p)
+		}
+	}
+}
+
+// versioned renders key, version and a checksum of both, so a torn or
+// misdirected value fails to parse.
+func versioned(key string, version int64) string {
+	store := key + "@" + strconv.FormatInt(version, 10)
+	return store + "#" + strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(store))), 16)
+}
+
+func parseVersioned(key, v string) (int64, error) {
+	body, sum, ok := strings.Cut(v, "#")
+	if !ok || strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(body))), 16) != sum {
+		return 0, fmt.Errorf("torn value for %s: %q", key, v)
+	}
+	k, ver, _ := strings.Cut(body, "@")
+	if k != key {
+		return 0, fmt.Errorf("value for %s read from %s: %q", k, key, v)
+	}
+	return strconv.ParseInt(ver, 10, 64)
+}
+
+func (vs *verifyState) write(ctx context.Context, store kv.KV, k int, s *WorkerStats, p *Pacer) {
+	key := vs.keys[k]
+
+	for {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		version := vs.committed[k].Load() + 1
+		err = store.Set(ctx, key, versioned(key, version))
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+		vs.committed[k].Store(version)
+
+		v, err := missingAsEmpty(store.Get(ctx, key))
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+		s.OK(p.Since(start))
+
+		got, err := parseVersioned(key, v)
+		if err != nil {
+			s.Anomaly(err)
+			continue
+		}
+		if got < version {
+			s.Anomaly(fmt.Errorf("read-your-writes %s: wrote version %d, read %d", key, version, got))
+		}
+	}
+}
+
+func (vs *verifyState) read(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+	seen := make([]int64, verifyKeys)
+	for seq := i; ; seq++ {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		k := seq % verifyKeys
+		c := vs.committed[k].Load()
+		if c == 0 {
+			// not written yet in this run
+			continue
+		}
+
+		key := vs.keys[k]
+		v, err := missingAsEmpty(store.Get(ctx, key))
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+		s.OK(p.Since(start))
+
+		got, err := parseVersioned(key, v)
+		switch {
+		case err != nil:
+			s.Anomaly(err)
+		case got < c:
+			s.Anomaly(fmt.Errorf("stale read %s: version %d after %d was acknowledged", key, got, c))
+		case got < seen[k]:
+			s.Anomaly(fmt.Errorf("non-monotonic read %s: version %d after reading %d", key, got, seen[k]))
+		default:
+			seen[k] = got
+		}
+	}
+}
@@ -0,0 +1,678 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acoshift/kv-test-perf/pkg/kv"
+)
+
+// workerRand returns worker i's random source. Workers draw keys, values and
+// operation mixes from it alone, so runs with the same seed issue the same
+// operations in the same order on every worker, whatever the backend.
+func workerRand(seed int64, i int) *rand.Rand {
+	return rand.New(rand.NewSource(seed + int64(i)))
+}
+
+// runs counts the runs of each phase that asked runPrefix for a prefix, by
+// name and seed.
+var (
+	runsMu sync.Mutex
+	runs   = make(map[string]int)
+)
+
+// processNonce sets this process's keys apart from those of earlier
+// processes run with the same seed, which backends that keep data across
+// runs still hold.
+var processNonce = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// runPrefix returns a prefix for keys a run of a phase mustn't share with
+// earlier runs. In a seeded run it is made of the seed, the process nonce
+// and how many runs before this one in the process asked for it, so the
+// seed repeats the run's choices without repeating its keyspace; otherwise
+// it is made of the clock.
+func runPrefix(name string, seed int64) string {
+	if seed == 0 {
+		return name + "_" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_"
+	}
+	prefix := name + "_" + strconv.FormatUint(uint64(seed), 36) + "_" + processNonce + "_"
+	runsMu.Lock()
+	defer runsMu.Unlock()
+	runs[prefix]++
+	return prefix + strconv.Itoa(runs[prefix]) + "_"
+}
+
+// The workers below keep per-operation allocations out of the loop: keys and
+// values are formatted once per worker, and changing values are rendered into
+// a reused buffer so only the final string (which the backend may keep) is
+// allocated.
+
+func workerKey(i int) string {
+	return "key_" + strconv.Itoa(i)
+}
+
+func workerValue(i int) string {
+	return "value_" + strconv.Itoa(i)
+}
+
+// seqValue renders "value_<i>#<seq>" values into a reused buffer.
+type seqValue struct {
+	buf    []byte
+	prefix int
+}
+
+func newSeqValue(i int) *seqValue {
+	return newSeqString("value_", i)
+}
+
+// newSeqString renders "<prefix><i>#<seq>" strings.
+func newSeqString(prefix string, i int) *seqValue {
+	b := append(strconv.AppendInt([]byte(prefix), int64(i), 10), '#')
+	return &seqValue{buf: b, prefix: len(b)}
+}
+
+func (v *seqValue) Next(seq int) string {
+	v.buf = strconv.AppendInt(v.buf[:v.prefix], int64(seq), 10)
+	return string(v.buf)
+}
+
+func runSet(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+	key := workerKey(i)
+	value := workerValue(i)
+
+	for {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		err = store.Set(ctx, key, value)
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+
+		s.OK(p.Since(start))
+	}
+}
+
+// runSetChanging writes a new value on every Set so backends can't skip
+// no-op updates.
+func runSetChanging(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+	key := workerKey(i)
+	value := newSeqValue(i)
+
+	for seq := 0; ; seq++ {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		err = store.Set(ctx, key, value.Next(seq))
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+
+		s.OK(p.Since(start))
+	}
+}
+
+// runSetDuplicate re-sends a fraction of Sets twice, then verifies the key's
+// final value matches the last write, as if every Set applied exactly once.
+func runSetDuplicate(ratio float64, seed int64) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		key := workerKey(i)
+		seqv := newSeqValue(i)
+		rnd := workerRand(seed, i)
+
+		// maybe is the most recent value whose Set failed; it may still have
+		// been applied, so it is an acceptable final state too.
+		var last, maybe string
+		for seq := 0; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				break
+			}
+
+			value := seqv.Next(seq)
+			err = store.Set(ctx, key, value)
+			if err == nil && rnd.Float64() < ratio {
+				err = store.Set(ctx, key, value)
+			}
+			if err != nil {
+				maybe = value
+				s.Err(err)
+				continue
+			}
+
+			last, maybe = value, ""
+			s.OK(p.Since(start))
+		}
+
+		if last == "" {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		v, err := missingAsEmpty(store.Get(ctx, key))
+		if err != nil {
+			s.Err(err)
+			return
+		}
+		if v != last && (maybe == "" || v != maybe) {
+			s.Anomaly(fmt.Errorf("duplicate set %s: expected %s, got %s", key, last, v))
+		}
+	}
+}
+
+// newSetNX returns a setnx phase worker. It claims idempotency tokens:
+// even operations SetNX a new token, which must succeed, and odd ones replay
+// the previous token, which must be rejected. Tokens are unique per run,
+// since not every backend's Setup clears old keys.
+func newSetNX(seed int64) func() Worker {
+	return func() Worker {
+		prefix := runPrefix("token", seed)
+		return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+			tokens := newSeqString(prefix, i)
+			value := workerValue(i)
+
+			// claimed is the token the last even operation set, if it succeeded
+			var claimed string
+			for seq := 0; ; seq++ {
+				start, err := p.Wait(ctx)
+				if err != nil {
+					return
+				}
+
+				replay := seq%2 == 1
+				token := claimed
+				if !replay {
+					token = tokens.Next(seq)
+				} else if token == "" {
+					continue
+				}
+
+				ok, err := store.SetNX(ctx, token, value)
+				if err != nil {
+					claimed = ""
+					s.Err(err)
+					continue
+				}
+				s.OK(p.Since(start))
+
+				switch {
+				case !replay && !ok:
+					claimed = ""
+					s.Anomaly(fmt.Errorf("setnx %s: new token rejected", token))
+				case !replay:
+					claimed = token
+				case ok:
+					s.Anomaly(fmt.Errorf("setnx %s: replayed token accepted", token))
+				}
+			}
+		}
+	}
+}
+
+// casLocks is the number of lock keys the cas-lock workers contend on.
+const casLocks = 16
+
+// runCASLock takes and releases locks shared between workers with
+// CompareAndSwap. Each operation is one acquire attempt, plus the release if
+// it acquired the lock; a release that finds another owner means two workers
+// held the lock at once.
+func runCASLock(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+	const free = "free"
+	key := "lock_" + strconv.Itoa(i%casLocks)
+	owner := "owner_" + strconv.Itoa(i)
+
+	_, err := store.SetNX(ctx, key, free)
+	if err != nil {
+		s.Err(err)
+		return
+	}
+
+	for {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		ok, err := store.CompareAndSwap(ctx, key, free, owner)
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+		if ok {
+			// release even if the phase is ending, so the lock isn't left
+			// held for the next run
+			rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ok, err = store.CompareAndSwap(rctx, key, owner, free)
+			cancel()
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			if !ok {
+				s.Anomaly(fmt.Errorf("cas-lock %s: lost lock held by %s", key, owner))
+			}
+		}
+
+		s.OK(p.Since(start))
+	}
+}
+
+// runIncr has every worker increment the same few counters, so the phase
+// measures how each backend handles write contention on a hot key. Each
+// worker checks the values it sees on a counter only ever increase.
+func runIncr(counters int) Worker {
+	keys := make([]string, counters)
+	for i := range keys {
+		keys[i] = "counter_" + strconv.Itoa(i)
+	}
+
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		seen := make([]int64, counters)
+		for seq := i; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			c := seq % counters
+			n, err := store.Incr(ctx, keys[c])
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+			s.OK(p.Since(start))
+
+			if n <= seen[c] {
+				s.Anomaly(fmt.Errorf("incr %s: got %d after %d", keys[c], n, seen[c]))
+			}
+			seen[c] = n
+		}
+	}
+}
+
+// rmwPayload pads read-modify-write values to the size of a small session.
+const rmwPayload = 256
+
+// runRMW has every worker update records shared by all workers, such as
+// sessions, by reading one, bumping the version in it and writing it back.
+// A plain write can overwrite a concurrent update; with cas, the write is a
+// compare-and-swap that is retried from the read until it wins, as
+// optimistic concurrency would. Each operation's latency covers the whole
+// cycle, retries included.
+func runRMW(keys int, cas bool) Worker {
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "rmw_" + strconv.Itoa(i)
+	}
+	payload := fillValue(rmwPayload)
+
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		// CompareAndSwap never matches a missing key, so every record
+		// starts out at version 0
+		for _, key := range names {
+			_, err := store.SetNX(ctx, key, rmwValue(0, payload))
+			if err != nil {
+				s.Err(err)
+				return
+			}
+		}
+
+	ops:
+		for seq := i; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			key := names[seq%keys]
+			for {
+				old, err := store.Get(ctx, key)
+				if err != nil {
+					s.Err(err)
+					continue ops
+				}
+				version, err := parseRMWValue(old)
+				if err != nil {
+					s.Err(mismatchf("rmw %s: %v", key, err))
+					continue ops
+				}
+
+				value := rmwValue(version+1, payload)
+				if !cas {
+					err = store.Set(ctx, key, value)
+					if err != nil {
+						s.Err(err)
+						continue ops
+					}
+					break
+				}
+				ok, err := store.CompareAndSwap(ctx, key, old, value)
+				if err != nil {
+					s.Err(err)
+					continue ops
+				}
+				if ok {
+					break
+				}
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func rmwValue(version int64, payload string) string {
+	return "v" + strconv.FormatInt(version, 10) + ":" + payload
+}
+
+func parseRMWValue(v string) (int64, error) {
+	sv, _, ok := strings.Cut(v, ":")
+	if !ok || !strings.HasPrefix(sv, "v") {
+		return 0, fmt.Errorf("malformed value %.32q", v)
+	}
+	return strconv.ParseInt(sv[1:], 10, 64)
+}
+
+// runScan gives each worker its own prefix with n keys under it, then lists
+// the prefix with Scan and checks all n keys come back.
+func runScan(n int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		prefix := "scan_" + strconv.Itoa(i) + "_"
+		for j := 0; j < n; j++ {
+			err := store.Set(ctx, prefix+strconv.Itoa(j), workerValue(i))
+			if err != nil {
+				s.Err(err)
+				return
+			}
+		}
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			keys, err := store.Scan(ctx, prefix, n)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			if len(keys) != n {
+				s.Err(mismatchf("scan %s: expected %d keys, got %d", prefix, n, len(keys)))
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+// runTxnSet writes n keys per transaction, all with the same new value, then
+// reads them back at the end and checks no transaction was applied partially.
+func runTxnSet(n int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		keys := make([]string, n)
+		for j := range keys {
+			keys[j] = "txn_" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+		}
+		seqv := newSeqValue(i)
+		kvs := make(map[string]string, n)
+
+		// as in runSetDuplicate, a failed commit may still have applied
+		var last, maybe string
+		for seq := 0; ; seq++ {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				break
+			}
+
+			value := seqv.Next(seq)
+			for _, k := range keys {
+				kvs[k] = value
+			}
+			err = store.TxnSet(ctx, kvs)
+			if err != nil {
+				maybe = value
+				s.Err(err)
+				continue
+			}
+
+			last, maybe = value, ""
+			s.OK(p.Since(start))
+		}
+
+		if last == "" {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var first string
+		for j, k := range keys {
+			v, err := missingAsEmpty(store.Get(ctx, k))
+			if err != nil {
+				s.Err(err)
+				return
+			}
+			if j == 0 {
+				first = v
+			}
+			if v != first || (v != last && v != maybe) {
+				s.Anomaly(fmt.Errorf("txn-set: %s has %s, %s has %s, last commit %s", keys[0], first, k, v, last))
+				return
+			}
+		}
+	}
+}
+
+// newBulkLoad returns a bulk-load phase worker, writing batches of n new
+// keys with BulkLoad. Keys are unique per run, since the backends' bulk
+// paths can't overwrite existing keys.
+func newBulkLoad(n int, seed int64) func() Worker {
+	return func() Worker {
+		prefix := runPrefix("bulk", seed)
+		return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+			keys := newSeqString(prefix, i)
+			value := workerValue(i)
+			kvs := make(map[string]string, n)
+
+			for seq := 0; ; {
+				start, err := p.Wait(ctx)
+				if err != nil {
+					return
+				}
+
+				for k := range kvs {
+					delete(kvs, k)
+				}
+				for j := 0; j < n; j++ {
+					kvs[keys.Next(seq)] = value
+					seq++
+				}
+				err = store.BulkLoad(ctx, kvs)
+				if err != nil {
+					s.Err(err)
+					continue
+				}
+
+				s.OK(p.Since(start))
+			}
+		}
+	}
+}
+
+// binaryValue returns worker i's binary value: size random bytes, the same
+// for the set-bytes and get-bytes phases.
+func binaryValue(i, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(i))).Read(b)
+	return b
+}
+
+func runSetBytes(size int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		key := "bin_" + strconv.Itoa(i)
+		value := binaryValue(i, size)
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			err = store.SetBytes(ctx, key, value)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func runGetBytes(size int) Worker {
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		key := "bin_" + strconv.Itoa(i)
+		value := binaryValue(i, size)
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			v, err := store.GetBytes(ctx, key)
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			if !bytes.Equal(v, value) {
+				s.Err(mismatchf("get-bytes %s: wrong value (%d bytes, expected %d)", key, len(v), len(value)))
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+func runGet(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+	key := workerKey(i)
+	value := workerValue(i)
+	changed := value + "#"
+
+	for {
+		start, err := p.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		v, err := store.Get(ctx, key)
+		if err != nil {
+			s.Err(err)
+			continue
+		}
+
+		if v != value && !strings.HasPrefix(v, changed) {
+			s.Err(mismatchf("unexpected value: %s", v))
+			continue
+		}
+
+		s.OK(p.Since(start))
+	}
+}
+
+// runKeyspace runs a scenario phase: each operation picks a key from a
+// shared keyspace using the phase's distribution, or one of its hot keys,
+// and either sets a fixed-size value (with the phase's TTL, if any), reads it
+// back or increments it.
+func runKeyspace(sp ScenarioPhase, seed int64) Worker {
+	ks := NewKeyspace(sp.Keys)
+	value := fillValue(sp.ValueSize)
+
+	return func(ctx context.Context, store kv.KV, i int, s *WorkerStats, p *Pacer) {
+		rnd := workerRand(seed, i)
+		keys, err := NewKeyChooser(sp.Distribution, ks.Len(), i, rnd)
+		if err != nil {
+			s.Err(err)
+			return
+		}
+		if sp.HotRatio > 0 {
+			keys = &hotChooser{next: keys, hot: sp.HotKeys, ratio: sp.HotRatio, rnd: rnd}
+		}
+		counter := windowCounterOf(store)
+
+		for {
+			start, err := p.Wait(ctx)
+			if err != nil {
+				return
+			}
+
+			key := ks.keys[keys.Next()]
+			read := sp.Op == "get" || (sp.Op == "mixed" && rnd.Float64() < sp.ReadRatio)
+			switch {
+			case read:
+				_, err = store.Get(ctx, key)
+				if missingKey(err) {
+					// the keyspace isn't preloaded, so a read may come
+					// before any write of its key
+					err = nil
+				}
+			case sp.Op == "incr" && sp.TTL > 0:
+				_, err = counter.IncrWindow(ctx, key, sp.TTL)
+			case sp.Op == "incr":
+				_, err = store.Incr(ctx, key)
+			case sp.TTL > 0:
+				err = store.SetTTL(ctx, key, value, sp.TTL)
+			default:
+				err = store.Set(ctx, key, value)
+			}
+			if err != nil {
+				s.Err(err)
+				continue
+			}
+
+			s.OK(p.Since(start))
+		}
+	}
+}
+
+// windowCounterOf returns kv's own fixed-window counter, or one made of
+// Incr and SetTTL. That one isn't atomic: an increment between the two
+// calls is lost when SetTTL writes the count back. The phase decorators
+// are window counters too, passing IncrWindow on through windowCounterOf,
+// so decorating a backend doesn't lose its atomic counter.
+func windowCounterOf(store kv.KV) kv.WindowCounter {
+	if c, ok := store.(kv.WindowCounter); ok {
+		return c
+	}
+	return incrTTLCounter{kv: store}
+}
+
+type incrTTLCounter struct {
+	kv kv.KV
+}
+
+func (c incrTTLCounter) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := c.kv.Incr(ctx, key)
+	if err != nil || n != 1 {
+		return n, err
+	}
+	return n, c.kv.SetTTL(ctx, key, "1", ttl)
+}
@@ -0,0 +1,232 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// consulPrefix is the key prefix the benchmark writes under, so Setup can
+// clear it without touching anything else in the cluster.
+const consulPrefix = "kvperf/"
+
+// consulMaxTxnOps is the most operations Consul accepts in one transaction.
+const consulMaxTxnOps = 64
+
+var errConsulTTL = errors.New("consul kv: no per-key TTL, only session-bound keys")
+
+// consulKV stores keys in Consul's KV store over its HTTP API. Every write
+// goes through the Raft leader and is committed to a quorum's log before it
+// returns; reads use the default consistency mode, so they are served by the
+// leader too.
+//
+// Keys have no TTL of their own (sessions expire in tens of seconds at the
+// least and aren't meant to be one per key), so SetTTL fails.
+// CompareAndSwap and Incr are a read and a write conditional on the index
+// read. Transactions are capped at 64 operations.
+type consulKV struct {
+	transport *http.Transport
+	kv        *api.KV
+	agent     *api.Agent
+}
+
+// NewConsulKV connects to the Consul agent at addr, which is host:port or an
+// http:// or https:// URL, dialing through dialer if it isn't nil.
+func NewConsulKV(addr string, dialer *SourceDialer) (KV, error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = addr
+	cfg.Transport.MaxIdleConnsPerHost = 30
+	if dialer != nil {
+		cfg.Transport.DialContext = dialer.DialContext
+	}
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &consulKV{transport: cfg.Transport, kv: client.KV(), agent: client.Agent()}, nil
+}
+
+func (c *consulKV) Name() string {
+	return "consul"
+}
+
+func (c *consulKV) Setup(ctx context.Context) error {
+	_, err := c.kv.DeleteTree(consulPrefix, c.write(ctx))
+	return err
+}
+
+func (c *consulKV) Close(ctx context.Context) error {
+	return closeContext(ctx, func() error {
+		c.transport.CloseIdleConnections()
+		return nil
+	})
+}
+
+// ServerInfo reads the version of the agent the client talks to, along
+// with whether it is a server or forwards to one.
+func (c *consulKV) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	info := ServerInfo{Driver: driverVersion("github.com/hashicorp/consul/api")}
+	self, err := c.agent.Self()
+	if err != nil {
+		return info, err
+	}
+	info.Version = fmt.Sprint(self["Config"]["Version"])
+	info.Config = fmt.Sprintf("server=%v", self["Config"]["Server"])
+	return info, nil
+}
+
+func (c *consulKV) query(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{}).WithContext(ctx)
+}
+
+func (c *consulKV) write(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+func (c *consulKV) Set(ctx context.Context, key, value string) error {
+	return c.SetBytes(ctx, key, []byte(value))
+}
+
+func (c *consulKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := c.GetBytes(ctx, key)
+	return string(v), err
+}
+
+func (c *consulKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := c.kv.Put(&api.KVPair{Key: consulPrefix + key, Value: value}, c.write(ctx))
+	return err
+}
+
+func (c *consulKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	p, _, err := c.kv.Get(consulPrefix+key, c.query(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNotFound
+	}
+	return p.Value, nil
+}
+
+func (c *consulKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errConsulTTL
+}
+
+// SetNX is a check-and-set at index 0, which only succeeds if the key
+// doesn't exist.
+func (c *consulKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	ok, _, err := c.kv.CAS(&api.KVPair{Key: consulPrefix + key, Value: []byte(value)}, c.write(ctx))
+	return ok, err
+}
+
+// CompareAndSwap sets key at the index it read; a concurrent write in
+// between fails the check-and-set and counts as a mismatch, as on Redis.
+func (c *consulKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	p, _, err := c.kv.Get(consulPrefix+key, c.query(ctx))
+	if err != nil || p == nil || string(p.Value) != old {
+		return false, err
+	}
+	p.Value = []byte(new)
+	ok, _, err := c.kv.CAS(p, c.write(ctx))
+	return ok, err
+}
+
+// Incr retries its read and check-and-set until no other writer gets in
+// between, so contended counters take several round trips per increment.
+func (c *consulKV) Incr(ctx context.Context, key string) (int64, error) {
+	for {
+		p, _, err := c.kv.Get(consulPrefix+key, c.query(ctx))
+		if err != nil {
+			return 0, err
+		}
+		var v int64
+		if p == nil {
+			p = &api.KVPair{Key: consulPrefix + key}
+		} else {
+			v, err = strconv.ParseInt(string(p.Value), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		v++
+		p.Value = []byte(strconv.FormatInt(v, 10))
+		ok, _, err := c.kv.CAS(p, c.write(ctx))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return v, nil
+		}
+	}
+}
+
+// Scan lists every key under the prefix; the API has no limit, so the
+// whole listing is sent and cut short on the client.
+func (c *consulKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	keys, _, err := c.kv.Keys(consulPrefix+prefix, "", c.query(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, consulPrefix)
+	}
+	return keys, nil
+}
+
+// TxnSet sends the sets as one transaction, which Consul applies in a
+// single Raft entry.
+func (c *consulKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	if len(kvs) > consulMaxTxnOps {
+		return fmt.Errorf("consul kv: %d keys in a transaction, at most %d", len(kvs), consulMaxTxnOps)
+	}
+	return c.txn(ctx, kvs)
+}
+
+// BulkLoad sends the sets as transactions of up to 64 keys each.
+func (c *consulKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	batch := make(map[string]string, min(len(kvs), consulMaxTxnOps))
+	for k, v := range kvs {
+		batch[k] = v
+		if len(batch) < consulMaxTxnOps {
+			continue
+		}
+		err := c.txn(ctx, batch)
+		if err != nil {
+			return err
+		}
+		clear(batch)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return c.txn(ctx, batch)
+}
+
+func (c *consulKV) txn(ctx context.Context, kvs map[string]string) error {
+	ops := make(api.KVTxnOps, 0, len(kvs))
+	for k, v := range kvs {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: consulPrefix + k, Value: []byte(v)})
+	}
+	ok, resp, _, err := c.kv.Txn(ops, c.query(ctx))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		var errs []error
+		for _, e := range resp.Errors {
+			errs = append(errs, fmt.Errorf("consul txn op %d: %s", e.OpIndex, e.What))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
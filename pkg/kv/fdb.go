@@ -0,0 +1,308 @@
+//go:build fdb
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// fdbAPIVersion is the client API version the binding is used at; it needs
+// a libfdb_c of 7.1 or newer.
+const fdbAPIVersion = 710
+
+// fdbPrefix is the key prefix the benchmark writes under, so Setup can
+// clear its range without touching anything else in the cluster.
+var fdbPrefix = []byte("kvperf/")
+
+var errFDBTTL = errors.New("foundationdb: no key expiry")
+
+// fdbKV runs every operation as a FoundationDB transaction: reads get a read
+// version from the cluster first, and commits are resolved against
+// concurrent transactions and made durable on the transaction logs before
+// they return, so single operations pay two round trips or more. Conflicting
+// transactions are retried by the binding.
+//
+// With batch above 1, Sets and Gets of all workers are queued and run up to
+// batch at a time in one transaction, amortizing the read version and the
+// commit over the batch the way applications group writes. The other
+// operations always run in a transaction of their own.
+//
+// Transactions can't be cancelled, so an operation in flight as a phase
+// ends finishes first. There is no expiry, so SetTTL fails.
+type fdbKV struct {
+	db    fdb.Database
+	batch int
+	calls chan *fdbCall
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+type fdbCall struct {
+	op   func(tr fdb.Transaction) error
+	err  error
+	done chan struct{}
+}
+
+// NewFDBKV opens the cluster named by clusterFile, or the default cluster
+// file if it's empty, and runs up to batch Sets and Gets per transaction.
+func NewFDBKV(clusterFile string, batch int) (KV, error) {
+	err := fdb.APIVersion(fdbAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	db, err := fdb.OpenDatabase(clusterFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fdbKV{db: db, batch: batch}
+	if batch > 1 {
+		f.calls = make(chan *fdbCall, batch)
+		f.stop = make(chan struct{})
+		for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+			f.wg.Add(1)
+			go f.flush()
+		}
+	}
+	return f, nil
+}
+
+func (f *fdbKV) Name() string {
+	return "foundationdb"
+}
+
+func (f *fdbKV) Setup(ctx context.Context) error {
+	r, err := fdb.PrefixRange(fdbPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = f.db.Transact(func(tr fdb.Transaction) (any, error) {
+		tr.ClearRange(r)
+		return nil, nil
+	})
+	return err
+}
+
+// Close stops the batch runners; the database handle and the client's
+// network thread live as long as the process.
+func (f *fdbKV) Close(ctx context.Context) error {
+	if f.stop != nil {
+		close(f.stop)
+		f.wg.Wait()
+	}
+	return nil
+}
+
+// flush runs the queued calls in transactions of up to f.batch calls. A
+// retried transaction runs every call again, so each records only its last
+// attempt.
+func (f *fdbKV) flush() {
+	defer f.wg.Done()
+	batch := make([]*fdbCall, 0, f.batch)
+	for {
+		select {
+		case c := <-f.calls:
+			batch = append(batch, c)
+		case <-f.stop:
+			return
+		}
+	fill:
+		for len(batch) < f.batch {
+			select {
+			case c := <-f.calls:
+				batch = append(batch, c)
+			default:
+				break fill
+			}
+		}
+
+		_, err := f.db.Transact(func(tr fdb.Transaction) (any, error) {
+			for _, c := range batch {
+				c.err = c.op(tr)
+			}
+			return nil, nil
+		})
+		for i, c := range batch {
+			if err != nil {
+				c.err = err
+			}
+			close(c.done)
+			batch[i] = nil
+		}
+		batch = batch[:0]
+	}
+}
+
+// do runs op in a transaction, shared with other calls when batching.
+func (f *fdbKV) do(ctx context.Context, op func(tr fdb.Transaction) error) error {
+	if f.calls == nil {
+		_, err := f.db.Transact(func(tr fdb.Transaction) (any, error) {
+			return nil, op(tr)
+		})
+		return err
+	}
+
+	c := &fdbCall{op: op, done: make(chan struct{})}
+	select {
+	case f.calls <- c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func fdbKey(key string) fdb.Key {
+	return append(append(fdb.Key(nil), fdbPrefix...), key...)
+}
+
+func (f *fdbKV) Set(ctx context.Context, key, value string) error {
+	return f.SetBytes(ctx, key, []byte(value))
+}
+
+func (f *fdbKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := f.GetBytes(ctx, key)
+	return string(v), err
+}
+
+func (f *fdbKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return f.do(ctx, func(tr fdb.Transaction) error {
+		tr.Set(fdbKey(key), value)
+		return nil
+	})
+}
+
+func (f *fdbKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	var v []byte
+	err := f.do(ctx, func(tr fdb.Transaction) error {
+		var err error
+		v, err = tr.Get(fdbKey(key)).Get()
+		return err
+	})
+	if err == nil && v == nil {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (f *fdbKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errFDBTTL
+}
+
+// transact runs op in a transaction of its own, outside any batch.
+func (f *fdbKV) transact(op func(tr fdb.Transaction) error) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (any, error) {
+		return nil, op(tr)
+	})
+	return err
+}
+
+func (f *fdbKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	var ok bool
+	err := f.transact(func(tr fdb.Transaction) error {
+		k := fdbKey(key)
+		v, err := tr.Get(k).Get()
+		if err != nil {
+			return err
+		}
+		ok = v == nil
+		if ok {
+			tr.Set(k, []byte(value))
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// CompareAndSwap reads and writes the key in one transaction; a concurrent
+// write conflicts with the read and the transaction is retried, so unlike
+// the optimistic backends it never fails spuriously.
+func (f *fdbKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	var ok bool
+	err := f.transact(func(tr fdb.Transaction) error {
+		k := fdbKey(key)
+		v, err := tr.Get(k).Get()
+		if err != nil {
+			return err
+		}
+		ok = v != nil && string(v) == old
+		if ok {
+			tr.Set(k, []byte(new))
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// Incr reads and writes the counter in one transaction, which contended
+// counters retry on conflict. FoundationDB's conflict-free atomic add can't
+// return the new value.
+func (f *fdbKV) Incr(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := f.transact(func(tr fdb.Transaction) error {
+		k := fdbKey(key)
+		v, err := tr.Get(k).Get()
+		if err != nil {
+			return err
+		}
+		n = 0
+		if v != nil {
+			n, err = strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+		n++
+		tr.Set(k, []byte(strconv.FormatInt(n, 10)))
+		return nil
+	})
+	return n, err
+}
+
+// Scan reads the prefix's key range in order, as a snapshot read that adds
+// no conflicts.
+func (f *fdbKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	r, err := fdb.PrefixRange(fdbKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	_, err = f.db.ReadTransact(func(rtr fdb.ReadTransaction) (any, error) {
+		kvs, err := rtr.Snapshot().GetRange(r, fdb.RangeOptions{Limit: limit, Mode: fdb.StreamingModeWantAll}).GetSliceWithError()
+		if err != nil {
+			return nil, err
+		}
+		keys = keys[:0]
+		for _, kv := range kvs {
+			keys = append(keys, string(kv.Key[len(fdbPrefix):]))
+		}
+		return nil, nil
+	})
+	return keys, err
+}
+
+func (f *fdbKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return f.transact(func(tr fdb.Transaction) error {
+		for k, v := range kvs {
+			tr.Set(fdbKey(k), []byte(v))
+		}
+		return nil
+	})
+}
+
+// BulkLoad writes the batch in one transaction, which must stay under
+// FoundationDB's 10MB transaction size limit.
+func (f *fdbKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return f.TxnSet(ctx, kvs)
+}
@@ -0,0 +1,13 @@
+//go:build !fdb
+
+package kv
+
+import "errors"
+
+// NewFDBKV fails in builds without the fdb tag: the FoundationDB binding
+// needs cgo and the libfdb_c client library, so it's left out by default.
+// Build with -tags fdb to include it, after adding the binding that matches
+// the installed client to go.mod.
+func NewFDBKV(clusterFile string, batch int) (KV, error) {
+	return nil, errors.New("foundationdb: not built in, add github.com/apple/foundationdb/bindings/go at the libfdb_c version to go.mod and rebuild with -tags fdb")
+}
@@ -0,0 +1,439 @@
+// Package kv defines the KV interface the benchmark drives, with optional
+// interfaces for what only some backends can do, and implements it for each
+// supported backend.
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+type KV interface {
+	Name() string
+	Setup(ctx context.Context) error
+
+	// Close releases the backend's connections. It returns ctx's error if
+	// ctx is done before they are closed.
+	Close(ctx context.Context) error
+
+	Set(ctx context.Context, key, value string) error
+
+	// Get returns ErrNotFound for a key that doesn't exist or has expired.
+	Get(ctx context.Context, key string) (string, error)
+
+	// SetBytes and GetBytes store binary values, such as protobuf or
+	// msgpack blobs, as raw bytes. Backends may keep them apart from string
+	// values, so a key must be read back the way it was written. GetBytes
+	// returns ErrNotFound like Get.
+	SetBytes(ctx context.Context, key string, value []byte) error
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+
+	// SetTTL sets key to expire after ttl; reads of an expired key see it
+	// missing.
+	SetTTL(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// SetNX sets key only if it doesn't exist yet and reports whether it did.
+	SetNX(ctx context.Context, key, value string) (bool, error)
+
+	// CompareAndSwap sets key to new only if its current value is old, and
+	// reports whether it did. A missing key never matches.
+	CompareAndSwap(ctx context.Context, key, old, new string) (bool, error)
+
+	// Incr atomically adds one to the integer stored at key, starting from
+	// zero if it doesn't exist, and returns the new value.
+	Incr(ctx context.Context, key string) (int64, error)
+
+	// Scan returns up to limit keys starting with prefix, in no particular
+	// order.
+	Scan(ctx context.Context, prefix string, limit int) ([]string, error)
+
+	// TxnSet sets every key in kvs atomically: readers see all of the
+	// writes or none of them.
+	TxnSet(ctx context.Context, kvs map[string]string) error
+
+	// BulkLoad writes keys that don't exist yet in one batch, the fastest
+	// way the backend can load data (COPY on Postgres, a pipeline on Redis).
+	// Loading a key that already exists may fail the batch.
+	BulkLoad(ctx context.Context, kvs map[string]string) error
+}
+
+// ErrNotFound is returned by Get and GetBytes for a missing key, the same
+// way by every backend, whatever its client makes of one.
+var ErrNotFound = errors.New("key not found")
+
+type BackendConfig struct {
+	PostgresURL  string
+	CockroachURL string // postgres:// URL of a CockroachDB node
+	YugabyteURL  string // postgres:// URL of a YugabyteDB YSQL endpoint
+	RedisAddr    string
+	// DragonflyAddr, KeyDBAddr and ValkeyAddr are Redis-compatible
+	// servers, addressed like RedisAddr.
+	DragonflyAddr string
+	KeyDBAddr     string
+	ValkeyAddr    string
+	SourceAddrs   []string // local addresses to spread connections across
+	Pipeline      int      // commands per round trip for redis-pipeline
+	RedisHashes   int      // hashes redis-hash spreads keys over
+	RedisShards   []string // Redis addresses redis-sharded spreads keys over
+
+	// PostgresTable is "unlogged" (the default) or "logged". Unlogged
+	// tables skip the WAL, so they are faster but emptied after a crash.
+	PostgresTable string
+
+	// PostgresPartitions, if more than 0, hash-partitions the kv table on
+	// the key into this many partitions.
+	PostgresPartitions int
+
+	PostgresIndex      string // "btree" (primary key) or "hash"
+	PostgresFillFactor int    // table fillfactor in percent, 0 for the default
+
+	// PostgresMaxConns caps each of the sql backend's connection pools, 0
+	// for no limit; workers beyond it wait for a connection.
+	PostgresMaxConns int
+
+	// PostgresSynchronousCommit, if set, overrides the server's
+	// synchronous_commit for the benchmark's connections.
+	PostgresSynchronousCommit string
+
+	// PostgresSocket, if set, is the directory of the server's Unix
+	// socket to connect through instead of the URL's host, leaving out
+	// the cost of TCP over loopback.
+	PostgresSocket string
+
+	// PostgresUser and PostgresPassword, if set, override the URL's
+	// credentials, and PostgresTLS its sslmode and certificates.
+	PostgresUser     string
+	PostgresPassword string
+	PostgresTLS      TLSOptions
+
+	// RedisUsername and RedisPassword, if set, override RedisAddr's
+	// credentials. RedisTLS applies to every Redis-protocol server reached
+	// over TCP.
+	RedisUsername string
+	RedisPassword string
+	RedisTLS      TLSOptions
+
+	// RedisAOF, if set, reconfigures the server's append-only file for the
+	// run with CONFIG SET: "off", "everysec" or "always". The previous
+	// settings are restored on Close.
+	RedisAOF string
+
+	NATSURL     string
+	NATSStorage string // JetStream storage of the nats bucket: "file" or "memory"
+
+	ConsulAddr string
+	TiKVPD     []string // PD endpoints of the TiKV cluster
+
+	FDBClusterFile string // empty for the default cluster file
+	FDBBatch       int    // Sets and Gets per foundationdb transaction
+
+	// RemoteURL is the service the remote backend drives: an http:// or
+	// https:// base URL, or grpc://host:port.
+	RemoteURL string
+
+	// TieredTTL is how long the tiered backend caches values in Redis, 0
+	// until they are evicted, and TieredInvalidation how writes reach the
+	// cache: TieredWriteThrough or TieredInvalidate.
+	TieredTTL          time.Duration
+	TieredInvalidation string
+
+	PebbleDir  string // empty for a directory under the system's temp dir
+	PebbleSync bool   // fsync the WAL on every write
+
+	// Options holds name=value settings for backends added with Register,
+	// which the built-in backends ignore.
+	Options map[string]string
+}
+
+// New returns the backend called name, configured by cfg.
+func New(name string, cfg BackendConfig) (KV, error) {
+	if f := registered(name); f != nil {
+		return f(cfg)
+	}
+
+	dialer, err := NewSourceDialer(cfg.SourceAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "postgres", "postgresql":
+		uri, err := cfg.postgresURI()
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLKV(uri, SQLOptions{
+			Table:      cfg.PostgresTable,
+			Partitions: cfg.PostgresPartitions,
+			Index:      cfg.PostgresIndex,
+			FillFactor: cfg.PostgresFillFactor,
+			MaxConns:   cfg.PostgresMaxConns,
+		}, dialer)
+	case "cockroachdb", "yugabytedb":
+		uri := cfg.CockroachURL
+		if name == "yugabytedb" {
+			uri = cfg.YugabyteURL
+		}
+		return NewSQLKV(uri, SQLOptions{Dialect: name, MaxConns: cfg.PostgresMaxConns}, dialer)
+	case "redis":
+		return NewRedisKV(cfg.redisAddrConn(), cfg.RedisAOF, dialer)
+	case "dragonfly":
+		// RedisAOF isn't passed on: Dragonfly has no append-only file
+		return NewRedisCompatKV(name, cfg.redisConn(cfg.DragonflyAddr), "", dialer)
+	case "keydb":
+		return NewRedisCompatKV(name, cfg.redisConn(cfg.KeyDBAddr), cfg.RedisAOF, dialer)
+	case "valkey":
+		return NewRedisCompatKV(name, cfg.redisConn(cfg.ValkeyAddr), cfg.RedisAOF, dialer)
+	case "redis-hash":
+		return NewRedisHashKV(cfg.redisAddrConn(), cfg.RedisAOF, dialer, cfg.RedisHashes)
+	case "redis-sharded":
+		conns := make([]RedisConn, len(cfg.RedisShards))
+		for i, addr := range cfg.RedisShards {
+			conns[i] = cfg.redisConn(addr)
+			conns[i].Username, conns[i].Password = cfg.RedisUsername, cfg.RedisPassword
+		}
+		return NewRedisShardKV(conns, dialer)
+	case "redis-pipeline":
+		return NewRedisPipelineKV(cfg.redisAddrConn(), cfg.RedisAOF, dialer, cfg.Pipeline)
+	case "nats":
+		return NewNATSKV(cfg.NATSURL, cfg.NATSStorage, dialer)
+	case "consul":
+		return NewConsulKV(cfg.ConsulAddr, dialer)
+	case "tikv":
+		return NewTiKVKV(cfg.TiKVPD, dialer)
+	case "foundationdb", "fdb":
+		return NewFDBKV(cfg.FDBClusterFile, cfg.FDBBatch)
+	case "remote":
+		return NewRemoteKV(cfg.RemoteURL, dialer)
+	case "tiered":
+		source, err := New("postgres", cfg)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := NewTieredKV(cfg.redisAddrConn(), dialer, source, cfg.TieredTTL, cfg.TieredInvalidation)
+		if err != nil {
+			source.Close(context.Background())
+			return nil, err
+		}
+		return kv, nil
+	case "pebble":
+		return NewPebbleKV(cfg.PebbleDir, cfg.PebbleSync)
+	case "memory":
+		return NewMemoryKV()
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", name)
+	}
+}
+
+// postgresURI is PostgresURL with the connection settings and credentials
+// of the config applied.
+func (cfg BackendConfig) postgresURI() (string, error) {
+	uri, err := cfg.PostgresTLS.PostgresURI(cfg.PostgresURL)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range [][2]string{
+		{"synchronous_commit", cfg.PostgresSynchronousCommit},
+		{"host", cfg.PostgresSocket},
+		{"user", cfg.PostgresUser},
+		{"password", cfg.PostgresPassword},
+	} {
+		uri, err = withConnParam(uri, p[0], p[1])
+		if err != nil {
+			return "", err
+		}
+	}
+	return uri, nil
+}
+
+// redisConn connects to a Redis-protocol server at addr with RedisTLS; its
+// credentials come from addr's URL.
+func (cfg BackendConfig) redisConn(addr string) RedisConn {
+	return RedisConn{Addr: addr, TLS: cfg.RedisTLS}
+}
+
+// redisAddrConn connects to RedisAddr with the configured credentials.
+func (cfg BackendConfig) redisAddrConn() RedisConn {
+	c := cfg.redisConn(cfg.RedisAddr)
+	c.Username, c.Password = cfg.RedisUsername, cfg.RedisPassword
+	return c
+}
+
+// closeContext runs close, which can't be cancelled itself, but stops
+// waiting for it once ctx is done.
+func closeContext(ctx context.Context, close func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reconnector is implemented by network backends that can run every
+// operation on a new connection instead of a pooled one.
+type Reconnector interface {
+	Reconnect() (KV, bool)
+}
+
+// durabilityReporter is implemented by backends whose crash safety depends
+// on how they are set up and configured, so results can state it.
+type durabilityReporter interface {
+	// Durability returns the relevant settings as space-separated
+	// name=value pairs.
+	Durability(ctx context.Context) (string, error)
+}
+
+// DurabilitySettings returns kv's durability settings, or "" if it
+// doesn't report them or they can't be read.
+func DurabilitySettings(ctx context.Context, kv KV) string {
+	d, ok := kv.(durabilityReporter)
+	if !ok {
+		return ""
+	}
+	s, err := d.Durability(ctx)
+	if err != nil {
+		slog.Warn("reading durability settings failed", "backend", kv.Name(), "err", err)
+		return ""
+	}
+	return s
+}
+
+// Footprint is the space a backend takes for the data it stores.
+type Footprint struct {
+	Memory int64 // bytes of server memory, 0 if unknown
+	Disk   int64 // bytes on disk, 0 if unknown
+	Keys   int64 // keys stored, possibly estimated; -1 if unknown
+}
+
+// FootprintReporter is implemented by backends that can tell how much space
+// the stored keys take, so the space cost is part of the comparison.
+type FootprintReporter interface {
+	Stats(ctx context.Context) (Footprint, error)
+}
+
+// TableStats is the state of a backend's table after a phase: how big it
+// grew, how many dead row versions it carries and how often autovacuum
+// processed it.
+type TableStats struct {
+	Bytes        int64 // table, indexes and TOAST
+	LiveTuples   int64
+	DeadTuples   int64
+	Autovacuums  int64 // since the server's statistics were reset
+	Autoanalyzes int64
+	FreeBytes    int64 // reusable space per pgstattuple, -1 if it isn't installed
+}
+
+// TableStatser is implemented by backends that store keys as table rows
+// whose old versions linger until vacuumed, so results can show bloat
+// alongside throughput. It returns nil if the server has no such
+// statistics.
+type TableStatser interface {
+	TableStats(ctx context.Context) (*TableStats, error)
+}
+
+// Timings is the time a backend's operations spent so far in each stage
+// of the client, so slowness can be pinned on the connection pool or on
+// the server. Stages a backend can't see are zero.
+type Timings struct {
+	PoolWaits uint64        // operations that waited for a pooled connection
+	PoolWait  time.Duration // waiting for a pooled connection at the pool's limit
+	Connects  uint64
+	Connect   time.Duration // dialing and authenticating new connections
+	Queries   uint64        // statements sent, including begin and commit
+	Query     time.Duration // from sending a statement until its response
+	Fetch     time.Duration // reading result rows
+}
+
+// Add returns the time spent in both t and o, such as on two clients.
+func (t Timings) Add(o Timings) Timings {
+	return Timings{
+		PoolWaits: t.PoolWaits + o.PoolWaits,
+		PoolWait:  t.PoolWait + o.PoolWait,
+		Connects:  t.Connects + o.Connects,
+		Connect:   t.Connect + o.Connect,
+		Queries:   t.Queries + o.Queries,
+		Query:     t.Query + o.Query,
+		Fetch:     t.Fetch + o.Fetch,
+	}
+}
+
+// Sub returns the time spent between o and t.
+func (t Timings) Sub(o Timings) Timings {
+	return Timings{
+		PoolWaits: t.PoolWaits - o.PoolWaits,
+		PoolWait:  t.PoolWait - o.PoolWait,
+		Connects:  t.Connects - o.Connects,
+		Connect:   t.Connect - o.Connect,
+		Queries:   t.Queries - o.Queries,
+		Query:     t.Query - o.Query,
+		Fetch:     t.Fetch - o.Fetch,
+	}
+}
+
+// TimingReporter is implemented by backends that time the stages of their
+// operations below the KV interface.
+type TimingReporter interface {
+	Timings() Timings
+}
+
+// EventProbe returns the server-side events since its previous call.
+type EventProbe func(ctx context.Context) ([]string, error)
+
+// EventProber is implemented by backends that can report server-side events,
+// such as snapshots or forks, that explain latency spikes.
+type EventProber interface {
+	NewEventProbe() EventProbe
+}
+
+// TLSReconnector is implemented by backends that can run every operation on
+// a fresh TLS connection, with or without a client session cache for
+// resumption. It reports false when the backend isn't using TLS.
+type TLSReconnector interface {
+	ReconnectTLS(resume bool) (KV, bool)
+}
+
+// WindowCounter is implemented by backends that can count in fixed
+// windows in one atomic operation, the way rate limiters do. Backends
+// without it are driven through Incr and SetTTL.
+type WindowCounter interface {
+	// IncrWindow adds one to the counter at key and returns the new value.
+	// A counter that doesn't exist or has expired starts from zero and
+	// expires ttl after this increment.
+	IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// Queue is implemented by backends that can serve as a work queue, such
+// as a Redis list or a Postgres table popped with SKIP LOCKED, so they can
+// be compared as lightweight queues too.
+type Queue interface {
+	// Push appends value to the queue.
+	Push(ctx context.Context, queue, value string) error
+
+	// Pop removes and returns the oldest value in the queue, waiting up to
+	// timeout for one to arrive; ok is false if none did.
+	Pop(ctx context.Context, queue string, timeout time.Duration) (value string, ok bool, err error)
+}
+
+// BlobStreamer is implemented by backends that can store a value from an
+// io.Reader and read it back as one, such as blob stores with multipart
+// uploads. SetStream should consume r as it writes rather than buffer the
+// whole value, and GetStream shouldn't see a value before the SetStream
+// writing it returned. Callers can stream through backends without it by
+// storing a value as chunks under keys of their own.
+type BlobStreamer interface {
+	// SetStream stores everything read from r as key's value and returns
+	// its size.
+	SetStream(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// GetStream opens key's value for reading. The caller closes it.
+	GetStream(ctx context.Context, key string) (io.ReadCloser, error)
+}
@@ -0,0 +1,223 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryKV is an in-process map, useful as a harness-overhead baseline.
+type memoryKV struct {
+	mu     sync.RWMutex
+	m      map[string]string
+	exp    map[string]time.Time // expiry of keys set with a TTL
+	queues map[string][]string
+}
+
+func NewMemoryKV() (KV, error) {
+	return &memoryKV{m: make(map[string]string)}, nil
+}
+
+func (m *memoryKV) Name() string {
+	return "memory"
+}
+
+func (m *memoryKV) Setup(ctx context.Context) error {
+	m.mu.Lock()
+	m.m = make(map[string]string)
+	m.exp = nil
+	m.queues = nil
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryKV) Close(ctx context.Context) error {
+	return nil
+}
+
+// Stats reports the bytes of the keys and values stored, leaving out the
+// map's own overhead.
+func (m *memoryKV) Stats(ctx context.Context) (Footprint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f := Footprint{Keys: int64(len(m.m))}
+	for k, v := range m.m {
+		f.Memory += int64(len(k) + len(v))
+	}
+	return f, nil
+}
+
+func (m *memoryKV) Set(ctx context.Context, key, value string) error {
+	m.mu.Lock()
+	m.m[key] = value
+	delete(m.exp, key)
+	m.mu.Unlock()
+	return nil
+}
+
+// SetBytes stores binary values as strings, which hold any bytes, so they
+// share the string keyspace.
+func (m *memoryKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return m.Set(ctx, key, string(value))
+}
+
+func (m *memoryKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, err := m.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+func (m *memoryKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	m.m[key] = value
+	if m.exp == nil {
+		m.exp = make(map[string]time.Time)
+	}
+	m.exp[key] = time.Now().Add(ttl)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.m[key]; ok && !m.expired(key) {
+		return false, nil
+	}
+	m.m[key] = value
+	delete(m.exp, key)
+	return true, nil
+}
+
+func (m *memoryKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.m[key]; !ok || v != old {
+		return false, nil
+	}
+	m.m[key] = new
+	return true, nil
+}
+
+func (m *memoryKV) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	if v, ok := m.m[key]; ok {
+		var err error
+		n, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n++
+	m.m[key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (m *memoryKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	if v, ok := m.m[key]; ok && !m.expired(key) {
+		var err error
+		n, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n++
+	m.m[key] = strconv.FormatInt(n, 10)
+	if n == 1 {
+		if m.exp == nil {
+			m.exp = make(map[string]time.Time)
+		}
+		m.exp[key] = time.Now().Add(ttl)
+	}
+	return n, nil
+}
+
+func (m *memoryKV) Push(ctx context.Context, queue, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queues == nil {
+		m.queues = make(map[string][]string)
+	}
+	m.queues[queue] = append(m.queues[queue], value)
+	return nil
+}
+
+// Pop polls for a value until timeout; the memory backend is a baseline,
+// not a queue worth tuning.
+func (m *memoryKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.mu.Lock()
+		if q := m.queues[queue]; len(q) > 0 {
+			v := q[0]
+			m.queues[queue] = q[1:]
+			m.mu.Unlock()
+			return v, true, nil
+		}
+		m.mu.Unlock()
+		if !time.Now().Before(deadline) {
+			return "", false, nil
+		}
+		select {
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+}
+
+func (m *memoryKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []string
+	for k := range m.m {
+		if len(keys) == limit {
+			break
+		}
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	m.mu.Lock()
+	for k, v := range kvs {
+		m.m[k] = v
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return m.TxnSet(ctx, kvs)
+}
+
+func (m *memoryKV) Get(ctx context.Context, key string) (string, error) {
+	m.mu.RLock()
+	v, ok := m.m[key]
+	if m.expired(key) {
+		ok = false
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// expired reports whether key had a TTL that has passed. m.mu must be held.
+func (m *memoryKV) expired(key string) bool {
+	t, ok := m.exp[key]
+	return ok && !time.Now().Before(t)
+}
@@ -0,0 +1,281 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsBucket is the JetStream KV bucket the benchmark writes to.
+const natsBucket = "kv"
+
+var (
+	errNATSTTL = errors.New("nats kv: no per-key TTL, only a bucket-wide one")
+	errNATSTxn = errors.New("nats kv: no multi-key transactions")
+)
+
+// natsKV stores keys in a JetStream KV bucket, a stream keeping the last
+// revision of each key's subject. Every write is a publish the server
+// acknowledges once it's stored (and replicated, with replicas), so Set pays
+// a round trip to the stream leader.
+//
+// Buckets have no per-key TTL and no multi-key atomic writes, so SetTTL and
+// TxnSet fail; CompareAndSwap and Incr are a read and a write conditional on
+// the revision read.
+type natsKV struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	kv      jetstream.KeyValue
+	storage jetstream.StorageType
+}
+
+// NewNATSKV connects to the NATS server at url, dialing through dialer if it
+// isn't nil. storage is "file" (the default) or "memory". The connection is
+// retried in the background, so Setup fails until the server is up.
+func NewNATSKV(url, storage string, dialer *SourceDialer) (KV, error) {
+	var st jetstream.StorageType
+	switch storage {
+	case "", "file":
+		st = jetstream.FileStorage
+	case "memory":
+		st = jetstream.MemoryStorage
+	default:
+		return nil, fmt.Errorf("invalid nats storage: %s", storage)
+	}
+
+	opts := []nats.Option{nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1)}
+	if dialer != nil {
+		opts = append(opts, nats.SetCustomDialer(dialer))
+	}
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &natsKV{nc: nc, js: js, storage: st}, nil
+}
+
+func (n *natsKV) Name() string {
+	return "nats"
+}
+
+// Setup recreates the bucket, which is quicker than purging every key and
+// leaves no delete markers behind.
+func (n *natsKV) Setup(ctx context.Context) error {
+	err := n.js.DeleteKeyValue(ctx, natsBucket)
+	if err != nil && !errors.Is(err, jetstream.ErrStreamNotFound) && !errors.Is(err, jetstream.ErrBucketNotFound) {
+		return err
+	}
+	kv, err := n.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  natsBucket,
+		History: 1,
+		Storage: n.storage,
+	})
+	if err != nil {
+		return err
+	}
+	n.kv = kv
+	return nil
+}
+
+func (n *natsKV) Close(ctx context.Context) error {
+	return closeContext(ctx, func() error {
+		n.nc.Close()
+		return nil
+	})
+}
+
+// Durability reports the bucket's storage and replica count.
+func (n *natsKV) Durability(ctx context.Context) (string, error) {
+	s, err := n.kv.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	cfg := s.(*jetstream.KeyValueBucketStatus).StreamInfo().Config
+	return fmt.Sprintf("storage=%s replicas=%d", strings.ToLower(cfg.Storage.String()), cfg.Replicas), nil
+}
+
+func (n *natsKV) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	return ServerInfo{
+		Version: n.nc.ConnectedServerVersion(),
+		Driver:  driverVersion("github.com/nats-io/nats.go"),
+	}, nil
+}
+
+// Stats reports the bucket's stream size, in memory or on disk by its
+// storage, and its message count, which with a history of 1 is the key
+// count.
+func (n *natsKV) Stats(ctx context.Context) (Footprint, error) {
+	s, err := n.kv.Status(ctx)
+	if err != nil {
+		return Footprint{}, err
+	}
+	f := Footprint{Keys: int64(s.Values())}
+	if n.storage == jetstream.MemoryStorage {
+		f.Memory = int64(s.Bytes())
+	} else {
+		f.Disk = int64(s.Bytes())
+	}
+	return f, nil
+}
+
+func (n *natsKV) Set(ctx context.Context, key, value string) error {
+	_, err := n.kv.PutString(ctx, key, value)
+	return err
+}
+
+func (n *natsKV) Get(ctx context.Context, key string) (string, error) {
+	e, err := n.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(e.Value()), nil
+}
+
+func (n *natsKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := n.kv.Put(ctx, key, value)
+	return err
+}
+
+func (n *natsKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	e, err := n.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.Value(), nil
+}
+
+func (n *natsKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errNATSTTL
+}
+
+func (n *natsKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	_, err := n.kv.Create(ctx, key, []byte(value))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompareAndSwap updates key at the revision it read; a concurrent write in
+// between fails the update and counts as a mismatch, as on Redis.
+func (n *natsKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	e, err := n.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if string(e.Value()) != old {
+		return false, nil
+	}
+	_, err = n.kv.Update(ctx, key, []byte(new), e.Revision())
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr retries its read and conditional write until no other writer gets in
+// between, so contended counters take several round trips per increment.
+func (n *natsKV) Incr(ctx context.Context, key string) (int64, error) {
+	for {
+		var v int64
+		var rev uint64
+		e, err := n.kv.Get(ctx, key)
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+		case err != nil:
+			return 0, err
+		default:
+			v, err = strconv.ParseInt(string(e.Value()), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			rev = e.Revision()
+		}
+
+		v++
+		b := []byte(strconv.FormatInt(v, 10))
+		if rev == 0 {
+			_, err = n.kv.Create(ctx, key, b)
+		} else {
+			_, err = n.kv.Update(ctx, key, b, rev)
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			continue
+		}
+		return v, err
+	}
+}
+
+// Scan has to watch the whole bucket: subject filters match whole tokens,
+// not key prefixes, so the server can't narrow it down.
+func (n *natsKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	w, err := n.kv.WatchAll(ctx, jetstream.IgnoreDeletes(), jetstream.MetaOnly())
+	if err != nil {
+		return nil, err
+	}
+	defer w.Stop()
+
+	var keys []string
+	for len(keys) < limit {
+		select {
+		case e := <-w.Updates():
+			// nil marks the end of the initial values
+			if e == nil {
+				return keys, nil
+			}
+			if strings.HasPrefix(e.Key(), prefix) {
+				keys = append(keys, e.Key())
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return keys, nil
+}
+
+func (n *natsKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return errNATSTxn
+}
+
+// BulkLoad publishes the keys without waiting for each acknowledgement, then
+// waits for all of them.
+func (n *natsKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	futures := make([]jetstream.PubAckFuture, 0, len(kvs))
+	for k, v := range kvs {
+		f, err := n.js.PublishAsync("$KV."+natsBucket+"."+k, []byte(v))
+		if err != nil {
+			return err
+		}
+		futures = append(futures, f)
+	}
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
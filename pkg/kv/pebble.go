@@ -0,0 +1,268 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// pebbleLocks is the number of lock stripes pebbleKV's read-modify-write
+// operations are serialized on.
+const pebbleLocks = 256
+
+var errPebbleTTL = errors.New("pebble: no key expiry")
+
+// pebbleKV is an embedded Pebble LSM store in a local directory, measuring
+// what a KV store costs without a network hop. Writes go through the WAL,
+// fsynced per write when sync is set; concurrent syncs are grouped into one
+// fsync.
+//
+// Pebble has no conditional writes, so SetNX, CompareAndSwap and Incr read
+// and write under one of a fixed set of striped locks. Plain writes take
+// the locks too, or one could land between such a read and its write and
+// be lost. There is no expiry, so SetTTL fails.
+type pebbleKV struct {
+	dir   string
+	sync  bool
+	db    *pebble.DB
+	write *pebble.WriteOptions
+	locks [pebbleLocks]sync.Mutex
+}
+
+// NewPebbleKV keeps its store in dir, or a directory under the system's
+// temporary directory if dir is empty. Setup wipes the directory.
+func NewPebbleKV(dir string, sync bool) (KV, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "kv-test-perf-pebble")
+	}
+	write := pebble.NoSync
+	if sync {
+		write = pebble.Sync
+	}
+	return &pebbleKV{dir: dir, sync: sync, write: write}, nil
+}
+
+func (p *pebbleKV) Name() string {
+	return "pebble"
+}
+
+// Setup recreates the store from an empty directory, so no earlier run's
+// data or compaction debt carries over.
+func (p *pebbleKV) Setup(ctx context.Context) error {
+	if p.db != nil {
+		err := p.db.Close()
+		p.db = nil
+		if err != nil {
+			return err
+		}
+	}
+	err := os.RemoveAll(p.dir)
+	if err != nil {
+		return err
+	}
+	db, err := pebble.Open(p.dir, &pebble.Options{})
+	if err != nil {
+		return err
+	}
+	p.db = db
+	return nil
+}
+
+// Close closes the store and removes its directory.
+func (p *pebbleKV) Close(ctx context.Context) error {
+	if p.db == nil {
+		return nil
+	}
+	return closeContext(ctx, func() error {
+		return errors.Join(p.db.Close(), os.RemoveAll(p.dir))
+	})
+}
+
+// Durability reports whether writes fsync the WAL.
+func (p *pebbleKV) Durability(ctx context.Context) (string, error) {
+	return fmt.Sprintf("sync=%t", p.sync), nil
+}
+
+// ServerInfo reports the version of Pebble linked in, which is the whole
+// store.
+func (p *pebbleKV) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	return ServerInfo{Driver: driverVersion("github.com/cockroachdb/pebble")}, nil
+}
+
+// Stats reports the store's size on disk, WAL included, and the memory of
+// its memtables and block cache. Pebble doesn't count keys.
+func (p *pebbleKV) Stats(ctx context.Context) (Footprint, error) {
+	m := p.db.Metrics()
+	return Footprint{
+		Memory: int64(m.MemTable.Size) + m.BlockCache.Size,
+		Disk:   int64(m.DiskSpaceUsage()),
+		Keys:   -1,
+	}, nil
+}
+
+func (p *pebbleKV) stripe(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % pebbleLocks)
+}
+
+func (p *pebbleKV) lock(key string) *sync.Mutex {
+	return &p.locks[p.stripe(key)]
+}
+
+// lockAll locks the stripes of every key in kvs, in order so two batches
+// can't deadlock, and returns the function that unlocks them.
+func (p *pebbleKV) lockAll(kvs map[string]string) func() {
+	seen := make(map[int]bool, len(kvs))
+	var stripes []int
+	for k := range kvs {
+		i := p.stripe(k)
+		if !seen[i] {
+			seen[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		p.locks[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			p.locks[i].Unlock()
+		}
+	}
+}
+
+// get returns a copy of key's value and whether it exists; a stored empty
+// value is found but empty.
+func (p *pebbleKV) get(key string) ([]byte, bool, error) {
+	v, closer, err := p.db.Get([]byte(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer closer.Close()
+	return append([]byte{}, v...), true, nil
+}
+
+func (p *pebbleKV) Set(ctx context.Context, key, value string) error {
+	mu := p.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return p.db.Set([]byte(key), []byte(value), p.write)
+}
+
+func (p *pebbleKV) Get(ctx context.Context, key string) (string, error) {
+	v, closer, err := p.db.Get([]byte(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	defer closer.Close()
+	return string(v), nil
+}
+
+func (p *pebbleKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	mu := p.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return p.db.Set([]byte(key), value, p.write)
+}
+
+func (p *pebbleKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, found, err := p.get(key)
+	if err == nil && !found {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (p *pebbleKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errPebbleTTL
+}
+
+func (p *pebbleKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	mu := p.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	_, found, err := p.get(key)
+	if err != nil || found {
+		return false, err
+	}
+	return true, p.db.Set([]byte(key), []byte(value), p.write)
+}
+
+func (p *pebbleKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	mu := p.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	v, found, err := p.get(key)
+	if err != nil || !found || string(v) != old {
+		return false, err
+	}
+	return true, p.db.Set([]byte(key), []byte(new), p.write)
+}
+
+func (p *pebbleKV) Incr(ctx context.Context, key string) (int64, error) {
+	mu := p.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	v, found, err := p.get(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if found {
+		n, err = strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n++
+	return n, p.db.Set([]byte(key), []byte(strconv.FormatInt(n, 10)), p.write)
+}
+
+// Scan iterates the prefix's key range in order.
+func (p *pebbleKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	it, err := p.db.NewIterWithContext(ctx, &pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: prefixEnd([]byte(prefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for ok := it.First(); ok && len(keys) < limit; ok = it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys, errors.Join(it.Error(), it.Close())
+}
+
+// TxnSet commits the keys as one batch, which Pebble applies atomically.
+func (p *pebbleKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	defer p.lockAll(kvs)()
+	b := p.db.NewBatch()
+	defer b.Close()
+	for k, v := range kvs {
+		err := b.Set([]byte(k), []byte(v), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return b.Commit(p.write)
+}
+
+func (p *pebbleKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return p.TxnSet(ctx, kvs)
+}
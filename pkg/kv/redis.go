@@ -0,0 +1,584 @@
+package kv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type redisKV struct {
+	name   string // "redis", or the redisCompats server it runs against
+	client *redis.Client
+	opts   *redis.Options
+
+	aof     string            // AOF mode Setup configures, "" to leave it
+	restore map[string]string // server settings to put back on Close
+}
+
+// RedisConn is how to connect to a Redis-protocol server.
+type RedisConn struct {
+	// Addr is host:port, a redis:// or rediss:// (TLS) URL, or a Unix
+	// socket as an absolute path or a unix:// URL.
+	Addr string
+
+	// Username and Password override the URL's credentials if set, for
+	// ACL users or the AUTH password of managed servers.
+	Username string
+	Password string
+
+	// TLS turns TLS on if any option is set, adding to a rediss:// URL's.
+	TLS TLSOptions
+}
+
+// NewRedisKV connects to the Redis server at conn, dialing through dialer if
+// it isn't nil. A non-empty aof ("off", "everysec" or "always")
+// reconfigures the server's append-only file in Setup.
+func NewRedisKV(conn RedisConn, aof string, dialer *SourceDialer) (KV, error) {
+	r, err := newRedisKV("redis", conn, aof, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func newRedisKV(name string, conn RedisConn, aof string, dialer *SourceDialer) (*redisKV, error) {
+	switch aof {
+	case "", "off", "everysec", "always":
+	default:
+		return nil, fmt.Errorf("invalid redis aof mode: %s", aof)
+	}
+	c, compat := redisCompats[name]
+	if compat && !c.aof && aof != "" {
+		return nil, fmt.Errorf("%s: no append-only file", name)
+	}
+
+	opts := &redis.Options{Addr: conn.Addr}
+	if strings.HasPrefix(conn.Addr, "/") {
+		opts.Network = "unix"
+	} else if strings.Contains(conn.Addr, "://") {
+		var err error
+		opts, err = redis.ParseURL(conn.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conn.Username != "" {
+		opts.Username = conn.Username
+	}
+	if conn.Password != "" {
+		opts.Password = conn.Password
+	}
+	if conn.TLS.Enabled() && opts.Network != "unix" {
+		host, _, err := net.SplitHostPort(opts.Addr)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig, err = conn.TLS.Config(opts.TLSConfig, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.MaxIdleConns = 30
+	if dialer != nil {
+		opts.Dialer = dialer.DialContext
+	}
+
+	if compat {
+		c.tune(opts)
+	}
+
+	client := redis.NewClient(opts)
+	return &redisKV{name: name, client: client, opts: opts, aof: aof}, nil
+}
+
+func (r *redisKV) Close(ctx context.Context) error {
+	if r.restore != nil {
+		err := r.configSet(ctx, r.restore)
+		if err != nil {
+			slog.Warn("restoring redis settings failed", "err", err)
+		}
+	}
+	return closeContext(ctx, r.client.Close)
+}
+
+func (r *redisKV) Name() string {
+	return r.name
+}
+
+func (r *redisKV) Setup(ctx context.Context) error {
+	if r.aof == "" {
+		return nil
+	}
+
+	if r.restore == nil {
+		cur, err := r.configGet(ctx, "appendonly", "appendfsync")
+		if err != nil {
+			return err
+		}
+		r.restore = cur
+	}
+	set := map[string]string{"appendonly": "no"}
+	if r.aof != "off" {
+		set = map[string]string{"appendonly": "yes", "appendfsync": r.aof}
+	}
+	err := r.configSet(ctx, set)
+	if err != nil {
+		return err
+	}
+	return r.waitAOFRewrite(ctx)
+}
+
+// waitAOFRewrite waits out the rewrite that turning appendonly on starts,
+// so it doesn't overlap the first phase.
+func (r *redisKV) waitAOFRewrite(ctx context.Context) error {
+	for {
+		info, err := r.client.Info(ctx, "persistence").Result()
+		if err != nil {
+			return err
+		}
+		if parseRedisInfo(info)["aof_rewrite_in_progress"] != "1" {
+			return nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *redisKV) configGet(ctx context.Context, names ...string) (map[string]string, error) {
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		v, err := r.client.ConfigGet(ctx, name).Result()
+		if err != nil {
+			return nil, err
+		}
+		m[name] = v[name]
+	}
+	return m, nil
+}
+
+// configSet applies settings in a fixed order, so appendfsync is already
+// in place when appendonly turns the AOF on.
+func (r *redisKV) configSet(ctx context.Context, settings map[string]string) error {
+	for _, name := range []string{"appendfsync", "appendonly"} {
+		v, ok := settings[name]
+		if !ok {
+			continue
+		}
+		err := r.client.ConfigSet(ctx, name, v).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Durability reports the server's append-only file settings, if it has one.
+func (r *redisKV) Durability(ctx context.Context) (string, error) {
+	if c, ok := redisCompats[r.name]; ok && !c.aof {
+		return "", nil
+	}
+	s, err := r.configGet(ctx, "appendonly", "appendfsync")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("appendonly=%s appendfsync=%s", s["appendonly"], s["appendfsync"]), nil
+}
+
+// redisSettings are the settings ServerInfo reports: memory limits,
+// persistence and threading.
+var redisSettings = []string{"maxmemory", "maxmemory-policy", "appendonly", "appendfsync", "io-threads"}
+
+// ServerInfo reads the version from INFO server, preferring a compatible
+// server's own version field (e.g. dragonfly_version) over the Redis one it
+// claims, and the settings in redisSettings. Servers implementing only part
+// of CONFIG GET report the settings they know.
+func (r *redisKV) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	info := ServerInfo{Driver: driverVersion("github.com/redis/go-redis/v9")}
+	s, err := r.client.Info(ctx, "server").Result()
+	if err != nil {
+		return info, err
+	}
+	m := parseRedisInfo(s)
+	info.Version = m["redis_version"]
+	if v, ok := m[r.name+"_version"]; ok {
+		info.Version = v
+	}
+
+	values := make(map[string]string, len(redisSettings))
+	for _, name := range redisSettings {
+		v, err := r.client.ConfigGet(ctx, name).Result()
+		if err != nil {
+			continue
+		}
+		if x, ok := v[name]; ok {
+			values[name] = x
+		}
+	}
+	info.Config = settings(values, redisSettings...)
+	return info, nil
+}
+
+func (r *redisKV) Set(ctx context.Context, key, value string) error {
+	return r.client.Set(ctx, key, value, 0).Err()
+}
+
+func (r *redisKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+// notFound turns redis.Nil, the reply for a missing key, into ErrNotFound.
+func notFound[T any](v T, err error) (T, error) {
+	if errors.Is(err, redis.Nil) {
+		err = ErrNotFound
+	}
+	return v, err
+}
+
+func (r *redisKV) Get(ctx context.Context, key string) (string, error) {
+	return notFound(r.client.Get(ctx, key).Result())
+}
+
+func (r *redisKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return r.client.Set(ctx, key, value, 0).Err()
+}
+
+func (r *redisKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return notFound(r.client.Get(ctx, key).Bytes())
+}
+
+func (r *redisKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return r.client.SetNX(ctx, key, value, 0).Result()
+}
+
+func (r *redisKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return redisCompareAndSwap(ctx, r.client, key, old, new)
+}
+
+// redisCompareAndSwap runs the compare and the set in a WATCH/MULTI
+// transaction; a concurrent write to key aborts it and counts as a mismatch.
+func redisCompareAndSwap(ctx context.Context, client *redis.Client, key, old, new string) (bool, error) {
+	var swapped bool
+	err := client.Watch(ctx, func(tx *redis.Tx) error {
+		v, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) || (err == nil && v != old) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, new, 0)
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	return swapped, err
+}
+
+func (r *redisKV) Incr(ctx context.Context, key string) (int64, error) {
+	return r.client.Incr(ctx, key).Result()
+}
+
+// Push and Pop use a list as the queue: LPUSH onto one end and BRPOP off
+// the other, which blocks on the server until a value arrives.
+func (r *redisKV) Push(ctx context.Context, queue, value string) error {
+	return r.client.LPush(ctx, queue, value).Err()
+}
+
+func (r *redisKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	kv, err := r.client.BRPop(ctx, timeout, queue).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	// BRPOP returns the list's name and the value
+	return kv[1], true, nil
+}
+
+// incrWindow is INCR with a PEXPIRE on the increment that created the
+// counter, as a script so the two can't be split by a crash or a
+// concurrent INCR.
+var incrWindow = redis.NewScript(`
+local n = redis.call("incr", KEYS[1])
+if n == 1 then
+	redis.call("pexpire", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (r *redisKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrWindow.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+func (r *redisKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return redisScan(ctx, r.client, prefix, limit)
+}
+
+// redisScan pages through SCAN MATCH until it has limit keys or the cursor
+// wraps; SCAN may return a key more than once, so keys are deduplicated.
+func redisScan(ctx context.Context, client *redis.Client, prefix string, limit int) ([]string, error) {
+	match := globEscaper.Replace(prefix) + "*"
+	seen := make(map[string]struct{})
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := client.Scan(ctx, cursor, match, int64(limit)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range page {
+			if _, ok := seen[k]; ok || len(keys) == limit {
+				continue
+			}
+			seen[k] = struct{}{}
+			keys = append(keys, k)
+		}
+		cursor = next
+		if cursor == 0 || len(keys) == limit {
+			return keys, nil
+		}
+	}
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (r *redisKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return redisTxnSet(ctx, r.client, kvs)
+}
+
+// redisTxnSet sends the sets in one MULTI/EXEC block.
+func redisTxnSet(ctx context.Context, client *redis.Client, kvs map[string]string) error {
+	_, err := client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range kvs {
+			p.Set(ctx, k, v, 0)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return redisBulkLoad(ctx, r.client, kvs)
+}
+
+// redisBulkLoad sends the sets in one pipeline, without MULTI/EXEC.
+func redisBulkLoad(ctx context.Context, client *redis.Client, kvs map[string]string) error {
+	_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range kvs {
+			p.Set(ctx, k, v, 0)
+		}
+		return nil
+	})
+	return err
+}
+
+// Stats reports used_memory from INFO, the AOF's size if it's on, and the
+// key count from DBSIZE. used_memory includes the server's own baseline of
+// a megabyte or so.
+func (r *redisKV) Stats(ctx context.Context) (Footprint, error) {
+	// the default sections; asking for several by name needs Redis 7
+	info, err := r.client.Info(ctx).Result()
+	if err != nil {
+		return Footprint{}, err
+	}
+	m := parseRedisInfo(info)
+	var f Footprint
+	f.Memory, err = strconv.ParseInt(m["used_memory"], 10, 64)
+	if err != nil {
+		return Footprint{}, err
+	}
+	if v, ok := m["aof_current_size"]; ok {
+		f.Disk, _ = strconv.ParseInt(v, 10, 64)
+	}
+	f.Keys, err = r.client.DBSize(ctx).Result()
+	if err != nil {
+		return Footprint{}, err
+	}
+	return f, nil
+}
+
+// NewEventProbe reports snapshot (BGSAVE) and AOF rewrite activity from
+// INFO persistence, along with the fork time of each, since forking a large
+// dataset stalls the server.
+func (r *redisKV) NewEventProbe() EventProbe {
+	var prev map[string]string
+	return func(ctx context.Context) ([]string, error) {
+		info, err := r.client.Info(ctx, "persistence").Result()
+		if err != nil {
+			return nil, err
+		}
+		cur := parseRedisInfo(info)
+		if prev == nil {
+			prev = cur
+			return nil, nil
+		}
+		events := persistenceEvents(prev, cur)
+		prev = cur
+		return events, nil
+	}
+}
+
+func parseRedisInfo(info string) map[string]string {
+	m := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func persistenceEvents(prev, cur map[string]string) []string {
+	var events []string
+	for _, p := range []struct {
+		name       string
+		inProgress string
+		done       []string // fields that change when one completes
+	}{
+		{"bgsave", "rdb_bgsave_in_progress", []string{"rdb_saves", "rdb_last_save_time"}},
+		{"aof-rewrite", "aof_rewrite_in_progress", []string{"aof_rewrites", "aof_last_rewrite_time_sec"}},
+	} {
+		was, is := prev[p.inProgress] == "1", cur[p.inProgress] == "1"
+		switch {
+		case !was && is:
+			events = append(events, p.name+" started")
+		case was && !is:
+			events = append(events, p.name+" finished")
+		case !was && !is:
+			for _, f := range p.done {
+				if cur[f] != prev[f] {
+					events = append(events, p.name)
+					break
+				}
+			}
+		}
+	}
+	if f := cur["latest_fork_usec"]; f != prev["latest_fork_usec"] && len(events) > 0 {
+		if usec, err := strconv.ParseInt(f, 10, 64); err == nil {
+			events = append(events, "fork "+(time.Duration(usec)*time.Microsecond).String())
+		}
+	}
+	return events
+}
+
+func (r *redisKV) Reconnect() (KV, bool) {
+	return &redisReconnectKV{name: r.name, opts: r.opts}, true
+}
+
+func (r *redisKV) ReconnectTLS(resume bool) (KV, bool) {
+	if r.opts.TLSConfig == nil {
+		return nil, false
+	}
+
+	opts := *r.opts
+	opts.TLSConfig = r.opts.TLSConfig.Clone()
+	opts.TLSConfig.ClientSessionCache = nil
+	if resume {
+		opts.TLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return &redisReconnectKV{name: r.name, opts: &opts}, true
+}
+
+// redisReconnectKV builds a new client, and so a new connection, for every
+// operation, like a serverless function that reconnects on each invocation.
+type redisReconnectKV struct {
+	name string
+	opts *redis.Options
+}
+
+func (r *redisReconnectKV) Name() string {
+	return r.name
+}
+
+func (r *redisReconnectKV) Setup(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op: every operation closes its own client.
+func (r *redisReconnectKV) Close(ctx context.Context) error {
+	return nil
+}
+
+func (r *redisReconnectKV) Set(ctx context.Context, key, value string) error {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return client.Set(ctx, key, value, 0).Err()
+}
+
+func (r *redisReconnectKV) Get(ctx context.Context, key string) (string, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return notFound(client.Get(ctx, key).Result())
+}
+
+func (r *redisReconnectKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return client.Set(ctx, key, value, 0).Err()
+}
+
+func (r *redisReconnectKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return notFound(client.Get(ctx, key).Bytes())
+}
+
+func (r *redisReconnectKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return client.SetNX(ctx, key, value, 0).Result()
+}
+
+func (r *redisReconnectKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return redisCompareAndSwap(ctx, client, key, old, new)
+}
+
+func (r *redisReconnectKV) Incr(ctx context.Context, key string) (int64, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return client.Incr(ctx, key).Result()
+}
+
+func (r *redisReconnectKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return redisScan(ctx, client, prefix, limit)
+}
+
+func (r *redisReconnectKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return redisTxnSet(ctx, client, kvs)
+}
+
+func (r *redisReconnectKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return client.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *redisReconnectKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	client := redis.NewClient(r.opts)
+	defer client.Close()
+	return redisBulkLoad(ctx, client, kvs)
+}
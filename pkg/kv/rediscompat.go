@@ -0,0 +1,54 @@
+package kv
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCompat is a server that speaks the Redis protocol but isn't Redis,
+// run through the redis backend under its own name.
+type redisCompat struct {
+	aof bool // has an append-only file to configure and report
+
+	// poolPerCPU sets the client's pool size per GOMAXPROCS, 0 for
+	// go-redis's default of 10. A server running commands on many threads
+	// needs more connections in flight than one that runs them on one.
+	poolPerCPU int
+}
+
+var redisCompats = map[string]redisCompat{
+	// Dragonfly shards the keyspace over a thread per core and has no
+	// append-only file, only snapshots.
+	"dragonfly": {poolPerCPU: 40},
+	// KeyDB is a Redis fork running commands on several server threads.
+	"keydb": {aof: true, poolPerCPU: 20},
+	// Valkey is Redis under a new name, so it keeps Redis's client settings.
+	"valkey": {aof: true},
+}
+
+// IsRedisCompat reports whether name is a Redis-compatible server.
+func IsRedisCompat(name string) bool {
+	_, ok := redisCompats[name]
+	return ok
+}
+
+func (c redisCompat) tune(opts *redis.Options) {
+	if c.poolPerCPU > 0 && opts.PoolSize == 0 {
+		opts.PoolSize = c.poolPerCPU * runtime.GOMAXPROCS(0)
+		opts.MaxIdleConns = opts.PoolSize
+	}
+}
+
+// NewRedisCompatKV connects to a redisCompats server like NewRedisKV.
+func NewRedisCompatKV(name string, conn RedisConn, aof string, dialer *SourceDialer) (KV, error) {
+	if _, ok := redisCompats[name]; !ok {
+		return nil, fmt.Errorf("unknown redis-compatible server: %s", name)
+	}
+	r, err := newRedisKV(name, conn, aof, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,201 @@
+package kv
+
+import (
+	"context"
+	"hash/crc32"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisHashes is how many hashes redisHashKV spreads keys over unless
+// -redis-hashes says otherwise.
+const DefaultRedisHashes = 1024
+
+// redisHashKV stores each key as a field of one of n hashes, picked by the
+// key's checksum. Small hashes use Redis's compact listpack encoding, which
+// takes much less memory per key than top-level strings; the cost is that
+// everything keyed on the hash, like WATCH and SCAN, now covers many keys.
+//
+// Per-field TTLs need HPEXPIRE, which Redis added in 7.4.
+type redisHashKV struct {
+	*redisKV
+	hashes []string
+}
+
+// NewRedisHashKV connects like NewRedisKV and spreads keys over n hashes.
+func NewRedisHashKV(conn RedisConn, aof string, dialer *SourceDialer, n int) (KV, error) {
+	kv, err := NewRedisKV(conn, aof, dialer)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		n = DefaultRedisHashes
+	}
+
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = "kvh:" + strconv.Itoa(i)
+	}
+	return &redisHashKV{redisKV: kv.(*redisKV), hashes: hashes}, nil
+}
+
+func (r *redisHashKV) hash(key string) string {
+	return r.hashes[crc32.ChecksumIEEE([]byte(key))%uint32(len(r.hashes))]
+}
+
+func (r *redisHashKV) Name() string {
+	return "redis-hash"
+}
+
+func (r *redisHashKV) Set(ctx context.Context, key, value string) error {
+	return r.client.HSet(ctx, r.hash(key), key, value).Err()
+}
+
+func (r *redisHashKV) Get(ctx context.Context, key string) (string, error) {
+	return notFound(r.client.HGet(ctx, r.hash(key), key).Result())
+}
+
+func (r *redisHashKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return r.client.HSet(ctx, r.hash(key), key, value).Err()
+}
+
+func (r *redisHashKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return notFound(r.client.HGet(ctx, r.hash(key), key).Bytes())
+}
+
+// SetTTL sets the field and its expiry in one MULTI/EXEC block.
+func (r *redisHashKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	h := r.hash(key)
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, h, key, value)
+		p.Do(ctx, "hpexpire", h, ttl.Milliseconds(), "fields", 1, key)
+		return nil
+	})
+	return err
+}
+
+func (r *redisHashKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return r.client.HSetNX(ctx, r.hash(key), key, value).Result()
+}
+
+// hashCompareAndSwap is a script rather than WATCH, which would abort on a
+// write to any field of the hash.
+var hashCompareAndSwap = redis.NewScript(`
+if redis.call("hget", KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call("hset", KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+func (r *redisHashKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	n, err := hashCompareAndSwap.Run(ctx, r.client, []string{r.hash(key)}, key, old, new).Int()
+	return n == 1, err
+}
+
+func (r *redisHashKV) Incr(ctx context.Context, key string) (int64, error) {
+	return r.client.HIncrBy(ctx, r.hash(key), key, 1).Result()
+}
+
+// Scan has to HSCAN every hash, since keys sharing a prefix are spread over
+// all of them.
+func (r *redisHashKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	match := globEscaper.Replace(prefix) + "*"
+	seen := make(map[string]struct{})
+	var keys []string
+	for _, h := range r.hashes {
+		var cursor uint64
+		for {
+			page, next, err := r.client.HScan(ctx, h, cursor, match, int64(limit)).Result()
+			if err != nil {
+				return nil, err
+			}
+			// HSCAN returns fields and values interleaved
+			for i := 0; i < len(page); i += 2 {
+				k := page[i]
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+				if len(keys) == limit {
+					return keys, nil
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (r *redisHashKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range kvs {
+			p.HSet(ctx, r.hash(k), k, v)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisHashKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range kvs {
+			p.HSet(ctx, r.hash(k), k, v)
+		}
+		return nil
+	})
+	return err
+}
+
+// Setup deletes the hashes too, since unlike separate keys, stale fields
+// would keep a hash above the size Redis encodes compactly.
+func (r *redisHashKV) Setup(ctx context.Context) error {
+	err := r.redisKV.Setup(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(r.hashes); i += 512 {
+		err := r.client.Del(ctx, r.hashes[i:min(i+512, len(r.hashes))]...).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats counts keys as the fields of all hashes, since DBSIZE counts the
+// hashes.
+func (r *redisHashKV) Stats(ctx context.Context) (Footprint, error) {
+	f, err := r.redisKV.Stats(ctx)
+	if err != nil {
+		return Footprint{}, err
+	}
+	cmds, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, h := range r.hashes {
+			p.HLen(ctx, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return Footprint{}, err
+	}
+	f.Keys = 0
+	for _, c := range cmds {
+		f.Keys += c.(*redis.IntCmd).Val()
+	}
+	return f, nil
+}
+
+func (r *redisHashKV) Reconnect() (KV, bool) {
+	return nil, false
+}
+
+func (r *redisHashKV) ReconnectTLS(resume bool) (KV, bool) {
+	return nil, false
+}
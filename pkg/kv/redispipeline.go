@@ -0,0 +1,221 @@
+package kv
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultPipelineDepth is how many commands redisPipelineKV sends per round
+// trip unless -pipeline says otherwise.
+const DefaultPipelineDepth = 16
+
+// redisPipelineKV queues the single-command operations of all workers and
+// sends them in batches of up to depth commands per round trip, the way
+// auto-pipelining clients do. A batch is sent as soon as a flusher is free
+// rather than waiting for it to fill, so batches only reach depth once there
+// are more concurrent workers than flushers can drain one at a time.
+//
+// Operations that take several round trips by nature (CompareAndSwap, Scan,
+// TxnSet) bypass the queue.
+type redisPipelineKV struct {
+	*redisKV
+	depth int
+	calls chan *pipelineCall
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+type pipelineCall struct {
+	queue func(p redis.Pipeliner) redis.Cmder
+	cmd   redis.Cmder
+	done  chan struct{}
+}
+
+// NewRedisPipelineKV connects like NewRedisKV and pipelines up to depth
+// commands per round trip.
+func NewRedisPipelineKV(conn RedisConn, aof string, dialer *SourceDialer, depth int) (KV, error) {
+	kv, err := NewRedisKV(conn, aof, dialer)
+	if err != nil {
+		return nil, err
+	}
+	if depth <= 0 {
+		depth = DefaultPipelineDepth
+	}
+
+	r := &redisPipelineKV{
+		redisKV: kv.(*redisKV),
+		depth:   depth,
+		calls:   make(chan *pipelineCall, depth),
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		r.wg.Add(1)
+		go r.flush()
+	}
+	return r, nil
+}
+
+func (r *redisPipelineKV) flush() {
+	defer r.wg.Done()
+	batch := make([]*pipelineCall, 0, r.depth)
+	for {
+		select {
+		case c := <-r.calls:
+			batch = append(batch, c)
+		case <-r.stop:
+			return
+		}
+	fill:
+		for len(batch) < r.depth {
+			select {
+			case c := <-r.calls:
+				batch = append(batch, c)
+			default:
+				break fill
+			}
+		}
+
+		// The batch mixes the calls of many workers, so it runs on its own
+		// context; a caller whose context ends stops waiting for its reply.
+		_, err := r.client.Pipelined(context.Background(), func(p redis.Pipeliner) error {
+			for _, c := range batch {
+				c.cmd = c.queue(p)
+			}
+			return nil
+		})
+		if err != nil {
+			setConnErr(batch, err)
+		}
+		for i, c := range batch {
+			close(c.done)
+			batch[i] = nil
+		}
+		batch = batch[:0]
+	}
+}
+
+// setConnErr fails the commands of a batch with err unless it's just the
+// first command error that Pipelined returns: a dial or read failure isn't
+// recorded on the commands that were never sent or read.
+func setConnErr(batch []*pipelineCall, err error) {
+	for _, c := range batch {
+		if c.cmd.Err() == err {
+			return
+		}
+	}
+	for _, c := range batch {
+		if c.cmd.Err() == nil {
+			c.cmd.SetErr(err)
+		}
+	}
+}
+
+// do queues a command and waits for its batch to come back.
+func (r *redisPipelineKV) do(ctx context.Context, queue func(p redis.Pipeliner) redis.Cmder) (redis.Cmder, error) {
+	c := &pipelineCall{queue: queue, done: make(chan struct{})}
+	select {
+	case r.calls <- c:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case <-c.done:
+		return c.cmd, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *redisPipelineKV) Name() string {
+	return "redis-pipeline"
+}
+
+func (r *redisPipelineKV) Close(ctx context.Context) error {
+	close(r.stop)
+	r.wg.Wait()
+	return r.redisKV.Close(ctx)
+}
+
+func (r *redisPipelineKV) Set(ctx context.Context, key, value string) error {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Set(ctx, key, value, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.Err()
+}
+
+func (r *redisPipelineKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Set(ctx, key, value, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.Err()
+}
+
+func (r *redisPipelineKV) Get(ctx context.Context, key string) (string, error) {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Get(ctx, key)
+	})
+	if err != nil {
+		return "", err
+	}
+	return notFound(cmd.(*redis.StringCmd).Result())
+}
+
+func (r *redisPipelineKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Set(ctx, key, value, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.Err()
+}
+
+func (r *redisPipelineKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Get(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notFound(cmd.(*redis.StringCmd).Bytes())
+}
+
+func (r *redisPipelineKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.SetNX(ctx, key, value, 0)
+	})
+	if err != nil {
+		return false, err
+	}
+	return cmd.(*redis.BoolCmd).Result()
+}
+
+func (r *redisPipelineKV) Incr(ctx context.Context, key string) (int64, error) {
+	cmd, err := r.do(ctx, func(p redis.Pipeliner) redis.Cmder {
+		return p.Incr(ctx, key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return cmd.(*redis.IntCmd).Result()
+}
+
+// Reconnect isn't supported: a new connection per operation leaves nothing
+// to pipeline.
+func (r *redisPipelineKV) Reconnect() (KV, bool) {
+	return nil, false
+}
+
+func (r *redisPipelineKV) ReconnectTLS(resume bool) (KV, bool) {
+	return nil, false
+}
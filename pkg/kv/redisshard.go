@@ -0,0 +1,319 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardPoints is how many points each shard takes on the hash ring; more
+// spread the keys more evenly.
+const shardPoints = 160
+
+// ShardStats is one shard's share of a sharded backend's work.
+type ShardStats struct {
+	Addr   string `json:"addr"`
+	Ops    uint64 `json:"ops"` // commands sent to the shard, failed ones included
+	Errors uint64 `json:"errors"`
+	Keys   int64  `json:"keys"` // keys stored, -1 if unknown
+}
+
+// ShardReporter is implemented by backends that spread keys across
+// servers, so an uneven spread shows as a hot shard.
+type ShardReporter interface {
+	ShardStats(ctx context.Context) ([]ShardStats, error)
+}
+
+// redisShardKV spreads keys across independent Redis servers with a
+// consistent hash ring, the way client libraries sharded Redis before
+// Redis Cluster: adding a server moves only the keys it takes over. Each key
+// lives on exactly one server, so the single-key operations behave as on
+// one; TxnSet is only atomic per shard, and Scan has to ask every shard.
+type redisShardKV struct {
+	shards []*redisKV
+	ops    []atomic.Uint64
+	errs   []atomic.Uint64
+
+	ring []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// NewRedisShardKV connects to every server in conns.
+func NewRedisShardKV(conns []RedisConn, dialer *SourceDialer) (KV, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("redis-sharded: no shards, set -redis-shards")
+	}
+	r := &redisShardKV{
+		ops:  make([]atomic.Uint64, len(conns)),
+		errs: make([]atomic.Uint64, len(conns)),
+	}
+	for i, conn := range conns {
+		s, err := newRedisKV("redis", conn, "", dialer)
+		if err != nil {
+			r.Close(context.Background())
+			return nil, err
+		}
+		r.shards = append(r.shards, s)
+		for p := 0; p < shardPoints; p++ {
+			r.ring = append(r.ring, ringPoint{ringHash(conn.Addr + "#" + strconv.Itoa(p)), i})
+		}
+	}
+	slices.SortFunc(r.ring, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+	return r, nil
+}
+
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// shard returns the index of the shard key lives on: the first point on
+// the ring at or after the key's hash.
+func (r *redisShardKV) shard(key string) int {
+	h := ringHash(key)
+	i, _ := slices.BinarySearchFunc(r.ring, h, func(p ringPoint, h uint64) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+	if i == len(r.ring) {
+		i = 0
+	}
+	return r.ring[i].shard
+}
+
+// on runs op on shard i, counting it.
+func on[T any](r *redisShardKV, i int, op func(s *redisKV) (T, error)) (T, error) {
+	r.ops[i].Add(1)
+	v, err := op(r.shards[i])
+	// a missing key is an answer, not a failure of the shard
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		r.errs[i].Add(1)
+	}
+	return v, err
+}
+
+// onKey runs op on the shard key lives on.
+func onKey[T any](r *redisShardKV, key string, op func(s *redisKV) (T, error)) (T, error) {
+	return on(r, r.shard(key), op)
+}
+
+// each runs op on every shard at once, with the keys of kvs that live on
+// it; shards without any are skipped unless kvs is nil.
+func (r *redisShardKV) each(kvs map[string]string, op func(s *redisKV, kvs map[string]string) error) error {
+	parts := make([]map[string]string, len(r.shards))
+	if kvs == nil {
+		for i := range parts {
+			parts[i] = map[string]string{}
+		}
+	}
+	for k, v := range kvs {
+		i := r.shard(k)
+		if parts[i] == nil {
+			parts[i] = make(map[string]string)
+		}
+		parts[i][k] = v
+	}
+
+	errs := make([]error, len(r.shards))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		if part == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, part map[string]string) {
+			defer wg.Done()
+			_, errs[i] = on(r, i, func(s *redisKV) (struct{}, error) {
+				return struct{}{}, op(s, part)
+			})
+		}(i, part)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *redisShardKV) Name() string {
+	return "redis-sharded"
+}
+
+func (r *redisShardKV) Setup(ctx context.Context) error {
+	return r.each(nil, func(s *redisKV, _ map[string]string) error {
+		return s.Setup(ctx)
+	})
+}
+
+func (r *redisShardKV) Close(ctx context.Context) error {
+	var errs []error
+	for _, s := range r.shards {
+		errs = append(errs, s.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// ShardStats counts the commands each shard has been sent, and reads its
+// key count with DBSIZE.
+func (r *redisShardKV) ShardStats(ctx context.Context) ([]ShardStats, error) {
+	stats := make([]ShardStats, len(r.shards))
+	for i, s := range r.shards {
+		n, err := s.client.DBSize(ctx).Result()
+		if err != nil {
+			return nil, err
+		}
+		stats[i] = ShardStats{
+			Addr:   s.opts.Addr,
+			Ops:    r.ops[i].Load(),
+			Errors: r.errs[i].Load(),
+			Keys:   n,
+		}
+	}
+	return stats, nil
+}
+
+// Stats sums the shards' footprints.
+func (r *redisShardKV) Stats(ctx context.Context) (Footprint, error) {
+	var f Footprint
+	for _, s := range r.shards {
+		sf, err := s.Stats(ctx)
+		if err != nil {
+			return Footprint{}, err
+		}
+		f.Memory += sf.Memory
+		f.Disk += sf.Disk
+		f.Keys += sf.Keys
+	}
+	return f, nil
+}
+
+func (r *redisShardKV) Set(ctx context.Context, key, value string) error {
+	_, err := onKey(r, key, func(s *redisKV) (struct{}, error) {
+		return struct{}{}, s.Set(ctx, key, value)
+	})
+	return err
+}
+
+func (r *redisShardKV) Get(ctx context.Context, key string) (string, error) {
+	return onKey(r, key, func(s *redisKV) (string, error) {
+		return s.Get(ctx, key)
+	})
+}
+
+func (r *redisShardKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := onKey(r, key, func(s *redisKV) (struct{}, error) {
+		return struct{}{}, s.SetBytes(ctx, key, value)
+	})
+	return err
+}
+
+func (r *redisShardKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return onKey(r, key, func(s *redisKV) ([]byte, error) {
+		return s.GetBytes(ctx, key)
+	})
+}
+
+func (r *redisShardKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := onKey(r, key, func(s *redisKV) (struct{}, error) {
+		return struct{}{}, s.SetTTL(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (r *redisShardKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return onKey(r, key, func(s *redisKV) (bool, error) {
+		return s.SetNX(ctx, key, value)
+	})
+}
+
+func (r *redisShardKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return onKey(r, key, func(s *redisKV) (bool, error) {
+		return s.CompareAndSwap(ctx, key, old, new)
+	})
+}
+
+func (r *redisShardKV) Incr(ctx context.Context, key string) (int64, error) {
+	return onKey(r, key, func(s *redisKV) (int64, error) {
+		return s.Incr(ctx, key)
+	})
+}
+
+func (r *redisShardKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return onKey(r, key, func(s *redisKV) (int64, error) {
+		return s.IncrWindow(ctx, key, ttl)
+	})
+}
+
+// Push and Pop keep each queue on the shard its name hashes to.
+func (r *redisShardKV) Push(ctx context.Context, queue, value string) error {
+	_, err := onKey(r, queue, func(s *redisKV) (struct{}, error) {
+		return struct{}{}, s.Push(ctx, queue, value)
+	})
+	return err
+}
+
+func (r *redisShardKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	var ok bool
+	v, err := onKey(r, queue, func(s *redisKV) (string, error) {
+		var (
+			v   string
+			err error
+		)
+		v, ok, err = s.Pop(ctx, queue, timeout)
+		return v, err
+	})
+	return v, ok, err
+}
+
+// Scan scans the shards one after another until it has limit keys.
+func (r *redisShardKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var keys []string
+	for i := range r.shards {
+		page, err := on(r, i, func(s *redisKV) ([]string, error) {
+			return s.Scan(ctx, prefix, limit-len(keys))
+		})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// TxnSet runs a MULTI/EXEC block on each shard the keys live on: readers
+// of one shard see all of its writes or none, but the shards commit
+// independently.
+func (r *redisShardKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return r.each(kvs, func(s *redisKV, kvs map[string]string) error {
+		return s.TxnSet(ctx, kvs)
+	})
+}
+
+func (r *redisShardKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return r.each(kvs, func(s *redisKV, kvs map[string]string) error {
+		return s.BulkLoad(ctx, kvs)
+	})
+}
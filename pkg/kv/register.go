@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"fmt"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// Factory opens a backend added with Register. Settings of its own come
+// from cfg.Options; cfg.SourceAddrs applies as to the built-in backends,
+// through NewSourceDialer.
+type Factory func(cfg BackendConfig) (KV, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a backend available to New under name, so programs and
+// plugins can add backends without changing this package. A registered
+// backend replaces a built-in one of the same name. Register panics if
+// name is already registered or f is nil, like database/sql.Register; it
+// is meant to be called from an init function.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if f == nil {
+		panic("kv: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("kv: Register called twice for backend " + name)
+	}
+	registry[name] = f
+}
+
+// Registered returns the names of the backends added with Register, sorted.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func registered(name string) Factory {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[name]
+}
+
+// LoadPlugins opens the Go plugins at paths. A plugin adds its backends by
+// calling Register from an init function, so opening it is enough. Plugins
+// must be built with -buildmode=plugin against the same version of this
+// module and its dependencies as the binary loading them.
+func LoadPlugins(paths []string) error {
+	for _, path := range paths {
+		_, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("load plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
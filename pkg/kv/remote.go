@@ -0,0 +1,352 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// remoteKV drives a user-provided KV service, so storage services of one's
+// own can be loaded with the same workloads as the built-in backends. The
+// service speaks one of two contracts:
+//
+// Over HTTP, with base the URL given:
+//
+//	GET    base/<key>                  200 with the value, 404 if missing
+//	PUT    base/<key>[?ttl_ms=N]       store the body as the value
+//	PUT    with If-None-Match: *       store only if missing, else 412
+//	GET    base/?prefix=P&limit=N      up to N keys starting with P, one per line
+//	DELETE base/                       remove every key (optional)
+//
+// Over gRPC, the kvperf.RemoteKV service with methods Get, Put, Scan and
+// Reset taking the Remote*Request types below, encoded as JSON (the "json"
+// content-subtype), as the coordinator and agents talk.
+//
+// Keys are path-escaped in URLs. CompareAndSwap, Incr and TxnSet aren't part
+// of either contract and fail; BulkLoad is a Put per key.
+type remoteKV struct {
+	svc remoteService
+}
+
+type remoteService interface {
+	get(ctx context.Context, key string) ([]byte, bool, error)
+	put(ctx context.Context, req *RemotePutRequest) (bool, error)
+	scan(ctx context.Context, prefix string, limit int) ([]string, error)
+	reset(ctx context.Context) error
+	close() error
+}
+
+// RemoteGetRequest and the other Remote* types are the messages of the
+// kvperf.RemoteKV gRPC service.
+type RemoteGetRequest struct {
+	Key string `json:"key"`
+}
+
+type RemoteGetResponse struct {
+	Value []byte `json:"value"`
+	Found bool   `json:"found"`
+}
+
+type RemotePutRequest struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	TTLMs int64  `json:"ttl_ms,omitempty"` // expire after this many milliseconds, 0 for never
+
+	// IfAbsent stores the value only if the key doesn't exist yet.
+	IfAbsent bool `json:"if_absent,omitempty"`
+}
+
+type RemotePutResponse struct {
+	Stored bool `json:"stored"`
+}
+
+type RemoteScanRequest struct {
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit"`
+}
+
+type RemoteScanResponse struct {
+	Keys []string `json:"keys"`
+}
+
+type RemoteResetRequest struct{}
+
+type RemoteResetResponse struct{}
+
+// NewRemoteKV connects to the service at addr: an http:// or https:// base
+// URL, or grpc://host:port for the gRPC contract without TLS.
+func NewRemoteKV(addr string, dialer *SourceDialer) (KV, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConnsPerHost = 100
+		if dialer != nil {
+			t.DialContext = dialer.DialContext
+		}
+		return &remoteKV{svc: &remoteHTTP{base: strings.TrimSuffix(addr, "/") + "/", client: &http.Client{Transport: t}}}, nil
+	case "grpc":
+		opts := []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(remoteCodec{})),
+		}
+		if dialer != nil {
+			opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp", addr)
+			}))
+		}
+		conn, err := grpc.Dial(u.Host, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteKV{svc: &remoteGRPC{conn: conn}}, nil
+	default:
+		return nil, fmt.Errorf("remote kv: unsupported address %q, want http://, https:// or grpc://", addr)
+	}
+}
+
+func (r *remoteKV) Name() string {
+	return "remote"
+}
+
+func (r *remoteKV) Setup(ctx context.Context) error {
+	return r.svc.reset(ctx)
+}
+
+func (r *remoteKV) Close(ctx context.Context) error {
+	return closeContext(ctx, r.svc.close)
+}
+
+func (r *remoteKV) Set(ctx context.Context, key, value string) error {
+	return r.SetBytes(ctx, key, []byte(value))
+}
+
+func (r *remoteKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := r.GetBytes(ctx, key)
+	return string(v), err
+}
+
+func (r *remoteKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	_, err := r.svc.put(ctx, &RemotePutRequest{Key: key, Value: value})
+	return err
+}
+
+func (r *remoteKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, found, err := r.svc.get(ctx, key)
+	if err == nil && !found {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (r *remoteKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := r.svc.put(ctx, &RemotePutRequest{Key: key, Value: []byte(value), TTLMs: max(ttl.Milliseconds(), 1)})
+	return err
+}
+
+func (r *remoteKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return r.svc.put(ctx, &RemotePutRequest{Key: key, Value: []byte(value), IfAbsent: true})
+}
+
+func (r *remoteKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	return false, errRemoteContract("CompareAndSwap")
+}
+
+func (r *remoteKV) Incr(ctx context.Context, key string) (int64, error) {
+	return 0, errRemoteContract("Incr")
+}
+
+func (r *remoteKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return r.svc.scan(ctx, prefix, limit)
+}
+
+func (r *remoteKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return errRemoteContract("TxnSet")
+}
+
+func (r *remoteKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	for k, v := range kvs {
+		err := r.Set(ctx, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func errRemoteContract(op string) error {
+	return fmt.Errorf("remote kv: %s isn't part of the service contract", op)
+}
+
+type remoteHTTP struct {
+	base   string // ends in a slash
+	client *http.Client
+}
+
+func (h *remoteHTTP) do(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.base+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return h.client.Do(req)
+}
+
+// drain reads the rest of resp's body so its connection can be reused.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func remoteStatus(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("remote kv: %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+func (h *remoteHTTP) get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := h.do(ctx, http.MethodGet, url.PathEscape(key), nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		v, err := io.ReadAll(resp.Body)
+		return v, true, err
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, remoteStatus(resp)
+	}
+}
+
+func (h *remoteHTTP) put(ctx context.Context, req *RemotePutRequest) (bool, error) {
+	path := url.PathEscape(req.Key)
+	if req.TTLMs > 0 {
+		path += "?ttl_ms=" + strconv.FormatInt(req.TTLMs, 10)
+	}
+	var header http.Header
+	if req.IfAbsent {
+		header = http.Header{"If-None-Match": {"*"}}
+	}
+	resp, err := h.do(ctx, http.MethodPut, path, req.Value, header)
+	if err != nil {
+		return false, err
+	}
+	defer drain(resp)
+	switch {
+	case resp.StatusCode/100 == 2:
+		return true, nil
+	case resp.StatusCode == http.StatusPreconditionFailed && req.IfAbsent:
+		return false, nil
+	default:
+		return false, remoteStatus(resp)
+	}
+}
+
+func (h *remoteHTTP) scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	q := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	resp, err := h.do(ctx, http.MethodGet, "?"+q.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, remoteStatus(resp)
+	}
+	var keys []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() && len(keys) < limit {
+		if sc.Text() != "" {
+			keys = append(keys, sc.Text())
+		}
+	}
+	return keys, sc.Err()
+}
+
+// reset deletes every key; a service without DELETE is left as it is.
+func (h *remoteHTTP) reset(ctx context.Context) error {
+	resp, err := h.do(ctx, http.MethodDelete, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	switch {
+	case resp.StatusCode/100 == 2, resp.StatusCode == http.StatusMethodNotAllowed, resp.StatusCode == http.StatusNotImplemented:
+		return nil
+	default:
+		return remoteStatus(resp)
+	}
+}
+
+func (h *remoteHTTP) close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+type remoteCodec struct{}
+
+func (remoteCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (remoteCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (remoteCodec) Name() string                       { return "json" }
+
+type remoteGRPC struct {
+	conn *grpc.ClientConn
+}
+
+func (g *remoteGRPC) get(ctx context.Context, key string) ([]byte, bool, error) {
+	var resp RemoteGetResponse
+	err := g.conn.Invoke(ctx, "/kvperf.RemoteKV/Get", &RemoteGetRequest{Key: key}, &resp)
+	return resp.Value, resp.Found, err
+}
+
+func (g *remoteGRPC) put(ctx context.Context, req *RemotePutRequest) (bool, error) {
+	var resp RemotePutResponse
+	err := g.conn.Invoke(ctx, "/kvperf.RemoteKV/Put", req, &resp)
+	return resp.Stored, err
+}
+
+func (g *remoteGRPC) scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var resp RemoteScanResponse
+	err := g.conn.Invoke(ctx, "/kvperf.RemoteKV/Scan", &RemoteScanRequest{Prefix: prefix, Limit: limit}, &resp)
+	if len(resp.Keys) > limit {
+		resp.Keys = resp.Keys[:limit]
+	}
+	return resp.Keys, err
+}
+
+// reset deletes every key; a service without Reset is left as it is.
+func (g *remoteGRPC) reset(ctx context.Context) error {
+	err := g.conn.Invoke(ctx, "/kvperf.RemoteKV/Reset", &RemoteResetRequest{}, &RemoteResetResponse{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	return err
+}
+
+func (g *remoteGRPC) close() error {
+	return g.conn.Close()
+}
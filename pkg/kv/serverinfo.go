@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+)
+
+// ServerInfo is what a backend ran against: its server's version and the
+// settings that bear on performance, and the client library driving it, so
+// saved results can still be read after everything has been upgraded.
+type ServerInfo struct {
+	Version string `json:"version,omitempty"`
+	Config  string `json:"config,omitempty"` // space-separated name=value pairs
+	Driver  string `json:"driver,omitempty"` // client module and version
+}
+
+// serverReporter is implemented by backends that can describe their server
+// and client library.
+type serverReporter interface {
+	ServerInfo(ctx context.Context) (ServerInfo, error)
+}
+
+// DescribeServer returns kv's server info, or nil if it doesn't report it.
+// Whatever was read before an error is kept.
+func DescribeServer(ctx context.Context, kv KV) *ServerInfo {
+	r, ok := kv.(serverReporter)
+	if !ok {
+		return nil
+	}
+	info, err := r.ServerInfo(ctx)
+	if err != nil {
+		slog.Warn("reading server info failed", "backend", kv.Name(), "err", err)
+	}
+	if info == (ServerInfo{}) {
+		return nil
+	}
+	return &info
+}
+
+// driverVersion returns module's path and version as linked into the
+// binary, or just its path if the binary has no build info.
+func driverVersion(module string) string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return module
+	}
+	for _, m := range bi.Deps {
+		if m.Path != module {
+			continue
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		return m.Path + " " + m.Version
+	}
+	return module
+}
+
+// settings formats name=value pairs in the order of names, leaving out
+// the ones missing from values.
+func settings(values map[string]string, names ...string) string {
+	var b strings.Builder
+	for _, name := range names {
+		v, ok := values[name]
+		if !ok {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(name + "=" + v)
+	}
+	return b.String()
+}
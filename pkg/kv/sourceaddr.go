@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxSourceAddrs bounds how many addresses a CIDR range expands to.
+const maxSourceAddrs = 4096
+
+// SourceDialer binds each outgoing connection to the next of several local
+// addresses, so a high connection count looks like many distinct clients and
+// every address gets its own ephemeral port range and conntrack entries.
+type SourceDialer struct {
+	addrs []net.Addr
+	next  atomic.Uint32
+}
+
+// NewSourceDialer parses local IPs and CIDR ranges, e.g. "10.0.0.2" or
+// "127.0.0.0/24". It returns nil, meaning the default dialer, for an empty
+// list.
+func NewSourceDialer(specs []string) (*SourceDialer, error) {
+	var d SourceDialer
+	for _, s := range specs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source address: %s", s)
+			}
+			d.addrs = append(d.addrs, &net.TCPAddr{IP: ip})
+			continue
+		}
+
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); ip = nextIP(ip) {
+			if len(d.addrs) == maxSourceAddrs {
+				return nil, fmt.Errorf("source addresses: more than %d", maxSourceAddrs)
+			}
+			d.addrs = append(d.addrs, &net.TCPAddr{IP: ip})
+		}
+	}
+	if len(d.addrs) == 0 {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// dialer binds to the next source address, except for Unix sockets, which
+// have no source address to bind.
+func (d *SourceDialer) dialer(network string, timeout time.Duration) *net.Dialer {
+	nd := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if network != "unix" {
+		i := d.next.Add(1) - 1
+		nd.LocalAddr = d.addrs[int(i)%len(d.addrs)]
+	}
+	return nd
+}
+
+func (d *SourceDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dialer(network, 0).DialContext(ctx, network, address)
+}
+
+func (d *SourceDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dialer(network, 0).Dial(network, address)
+}
+
+func (d *SourceDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return d.dialer(network, timeout).Dial(network, address)
+}
@@ -0,0 +1,844 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type sqlKV struct {
+	db     *sql.DB
+	bin    *sql.DB // for SetBytes and GetBytes
+	uri    string
+	opts   SQLOptions
+	dialer *SourceDialer
+
+	timings *sqlTimings // shared with Reconnect's clients
+}
+
+// SQLOptions sets how Setup creates the kv table.
+type SQLOptions struct {
+	// Dialect is the database the server is: "postgres" (the default),
+	// "cockroachdb" or "yugabytedb". See sqlDialects.
+	Dialect string
+
+	Table      string // "logged" or "unlogged"; empty means unlogged where supported
+	Partitions int    // hash partitions on the key, 0 for a plain table
+
+	// Index is "btree" (the default), a primary key, or "hash", a hash
+	// index enforcing uniqueness through an exclusion constraint. Prefix
+	// scans can't use a hash index.
+	Index string
+
+	FillFactor int // table fillfactor in percent, 0 for the server default
+
+	MaxConns int // open connections per pool, 0 for no limit
+}
+
+// NewSQLKV connects to uri, dialing through dialer if it isn't nil.
+func NewSQLKV(uri string, opts SQLOptions, dialer *SourceDialer) (KV, error) {
+	if opts.Dialect == "" {
+		opts.Dialect = "postgres"
+	}
+	d, ok := sqlDialects[opts.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown sql dialect: %s", opts.Dialect)
+	}
+	switch opts.Table {
+	case "":
+		opts.Table = "logged"
+		if d.unlogged {
+			opts.Table = "unlogged"
+		}
+	case "logged":
+	case "unlogged":
+		if !d.unlogged {
+			return nil, fmt.Errorf("%s: no unlogged tables", d.name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid postgres table type: %s", opts.Table)
+	}
+	if opts.Partitions < 0 {
+		return nil, fmt.Errorf("invalid postgres partition count: %d", opts.Partitions)
+	}
+	if opts.Partitions > 0 && !d.partitions {
+		return nil, fmt.Errorf("%s: no hash-partitioned tables", d.name)
+	}
+	switch opts.Index {
+	case "":
+		opts.Index = "btree"
+	case "btree":
+	case "hash":
+		if !d.exclusion {
+			return nil, fmt.Errorf("%s: no exclusion constraints for a hash index", d.name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid postgres index type: %s", opts.Index)
+	}
+	if opts.FillFactor != 0 && (opts.FillFactor < 10 || opts.FillFactor > 100) {
+		return nil, fmt.Errorf("invalid postgres fillfactor %d, want 10-100", opts.FillFactor)
+	}
+	if opts.FillFactor != 0 && !d.fillFactor {
+		return nil, fmt.Errorf("%s: no table fillfactor", d.name)
+	}
+	if opts.MaxConns < 0 {
+		return nil, fmt.Errorf("invalid postgres max connections: %d", opts.MaxConns)
+	}
+
+	s := &sqlKV{uri: uri, opts: opts, dialer: dialer, timings: new(sqlTimings)}
+	err := s.open(30)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqlDialect describes how far a Postgres-compatible database follows
+// Postgres, so the same queries can benchmark it.
+type sqlDialect struct {
+	name string // the backend's Name
+
+	unlogged   bool // unlogged tables
+	partitions bool // declarative hash partitioning
+	exclusion  bool // exclusion constraints, for the hash index
+	fillFactor bool
+	pgStats    bool // Postgres settings and statistics views, such as pg_stat_bgwriter
+
+	// retrySerialization retries operations failing with a serialization
+	// error (40001). Distributed databases run every statement as a
+	// serializable transaction and abort it on conflict, expecting the
+	// client to retry; a statement that fails this way didn't commit, so
+	// any operation is safe to retry.
+	retrySerialization bool
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	"postgres": {
+		name:     "postgresql",
+		unlogged: true, partitions: true, exclusion: true, fillFactor: true, pgStats: true,
+	},
+	// CockroachDB replicates every range with Raft, so it has no unlogged
+	// tables, and partitions by key range on its own.
+	"cockroachdb": {
+		name:               "cockroachdb",
+		retrySerialization: true,
+	},
+	// YugabyteDB runs Postgres's query layer on a Raft-replicated store,
+	// which keeps declarative partitioning but ignores storage settings.
+	"yugabytedb": {
+		name:       "yugabytedb",
+		partitions: true, retrySerialization: true,
+	},
+}
+
+func (s *sqlKV) dialect() *sqlDialect {
+	return sqlDialects[s.opts.Dialect]
+}
+
+// open creates the connection pools. Binary values get a pool of their own
+// with binary_parameters on, so they are sent as raw bytes instead of the
+// hex text lib/pq otherwise sends bytea parameters as; it also makes every
+// query a single round trip, which is why the main pool doesn't use it.
+func (s *sqlKV) open(maxIdle int) error {
+	db, err := openSQL(s.uri, s.dialer, s.timings)
+	if err != nil {
+		return err
+	}
+	binURI, err := withConnParam(s.uri, "binary_parameters", "yes")
+	if err != nil {
+		return err
+	}
+	bin, err := openSQL(binURI, s.dialer, s.timings)
+	if err != nil {
+		return err
+	}
+	db.SetMaxIdleConns(maxIdle)
+	bin.SetMaxIdleConns(maxIdle)
+	db.SetMaxOpenConns(s.opts.MaxConns)
+	bin.SetMaxOpenConns(s.opts.MaxConns)
+	s.db, s.bin = db, bin
+	return nil
+}
+
+// withConnParam adds a parameter to a postgres:// URL or key=value
+// connection string, replacing one already in a URL; lib/pq sends
+// parameters it doesn't know itself to the server at connection startup as
+// settings. An empty value leaves uri unchanged.
+func withConnParam(uri, key, value string) (string, error) {
+	if value == "" {
+		return uri, nil
+	}
+	if !strings.HasPrefix(uri, "postgres://") && !strings.HasPrefix(uri, "postgresql://") {
+		// a later key=value wins over an earlier one
+		return uri + " " + key + "='" + connValueQuoter.Replace(value) + "'", nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// connValueQuoter escapes a quoted key=value connection string value.
+var connValueQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func openSQL(uri string, dialer *SourceDialer, t *sqlTimings) (*sql.DB, error) {
+	c, err := pq.NewConnector(uri)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		c.Dialer(dialer)
+	}
+	return sql.OpenDB(timedConnector{Connector: c, t: t}), nil
+}
+
+// Reconnect returns a client that keeps no idle connections, so every
+// operation dials and authenticates a new one.
+func (s *sqlKV) Reconnect() (KV, bool) {
+	r := &sqlKV{uri: s.uri, opts: s.opts, dialer: s.dialer, timings: s.timings}
+	err := r.open(0)
+	if err != nil {
+		return nil, false
+	}
+	return r, true
+}
+
+func (s *sqlKV) Close(ctx context.Context) error {
+	return closeContext(ctx, func() error {
+		return errors.Join(s.db.Close(), s.bin.Close())
+	})
+}
+
+// textValues marks the kv table's varchar values, which must be valid text.
+func (s *sqlKV) textValues() {}
+
+func (s *sqlKV) Name() string {
+	return s.dialect().name
+}
+
+func (s *sqlKV) Setup(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.schema())
+	return err
+}
+
+// schema returns the statements that recreate the kv table, kv_bytes for
+// binary values and kv_queue for Push and Pop. A partitioned table can't be
+// unlogged itself, nor have storage parameters: its partitions take them.
+func (s *sqlKV) schema() string {
+	create := "create table"
+	if s.opts.Table == "unlogged" {
+		create = "create unlogged table"
+	}
+	var with string
+	if s.opts.FillFactor > 0 {
+		with = fmt.Sprintf(" with (fillfactor = %d)", s.opts.FillFactor)
+	}
+
+	var b strings.Builder
+	for _, t := range []struct{ name, value string }{{"kv", "varchar"}, {"kv_bytes", "bytea"}} {
+		columns := fmt.Sprintf(`(k varchar collate "C" primary key, v %s, expires_at timestamptz)`, t.value)
+		if s.opts.Index == "hash" {
+			columns = fmt.Sprintf(`(k varchar collate "C" not null, v %s, expires_at timestamptz, exclude using hash (k with =))`, t.value)
+		}
+
+		fmt.Fprintf(&b, "drop table if exists %s;\n", t.name)
+		if s.opts.Partitions == 0 {
+			fmt.Fprintf(&b, "%s %s%s%s;\n", create, t.name, columns, with)
+			continue
+		}
+		fmt.Fprintf(&b, "create table %s%s partition by hash (k);\n", t.name, columns)
+		for i := 0; i < s.opts.Partitions; i++ {
+			fmt.Fprintf(&b, "%s %s_%d partition of %s for values with (modulus %d, remainder %d)%s;\n", create, t.name, i, t.name, s.opts.Partitions, i, with)
+		}
+	}
+	fmt.Fprintf(&b, "drop table if exists kv_queue;\n")
+	fmt.Fprintf(&b, "%s kv_queue(id bigserial primary key, queue varchar not null, v varchar not null);\n", create)
+	fmt.Fprintf(&b, "create index on kv_queue(queue, id);\n")
+	return b.String()
+}
+
+// sqlQueries are the writes that differ by index type: ON CONFLICT DO
+// UPDATE needs a unique index, which a hash index can't be, so with one
+// the upserts update the key and insert it if the update found nothing.
+type sqlQueries struct {
+	set, setTTL, setNX, incr string
+	incrWindow, setBytes     string
+}
+
+var (
+	btreeQueries = sqlQueries{
+		set:      `insert into kv(k, v) values($1, $2) on conflict (k) do update set v = excluded.v, expires_at = null`,
+		setBytes: `insert into kv_bytes(k, v) values($1, $2) on conflict (k) do update set v = excluded.v`,
+		setTTL: `
+			insert into kv(k, v, expires_at) values($1, $2, now() + $3 * interval '1 microsecond')
+			on conflict (k) do update set v = excluded.v, expires_at = excluded.expires_at
+		`,
+		setNX: `
+			insert into kv(k, v) values($1, $2)
+			on conflict (k) do update set v = excluded.v, expires_at = null where kv.expires_at <= now()
+			returning true
+		`,
+		incr: `
+			insert into kv(k, v) values($1, '1')
+			on conflict (k) do update set
+				v = case when kv.expires_at <= now() then '1' else (kv.v::bigint + 1)::varchar end,
+				expires_at = case when kv.expires_at <= now() then null else kv.expires_at end
+			returning v::bigint
+		`,
+		incrWindow: `
+			insert into kv(k, v, expires_at) values($1, '1', now() + $2 * interval '1 microsecond')
+			on conflict (k) do update set
+				v = case when kv.expires_at <= now() then '1' else (kv.v::bigint + 1)::varchar end,
+				expires_at = case when kv.expires_at <= now() then excluded.expires_at else kv.expires_at end
+			returning v::bigint
+		`,
+	}
+
+	// Each statement sees the table as of its start, so two of them can
+	// both miss a new key and insert it; the exclusion constraint fails
+	// the second, which retry retries.
+	hashQueries = sqlQueries{
+		set: `
+			with u as (update kv set v = $2, expires_at = null where k = $1 returning k)
+			insert into kv(k, v) select $1, $2 where not exists (select from u)
+		`,
+		setBytes: `
+			with u as (update kv_bytes set v = $2 where k = $1 returning k)
+			insert into kv_bytes(k, v) select $1, $2 where not exists (select from u)
+		`,
+		setTTL: `
+			with u as (update kv set v = $2, expires_at = now() + $3 * interval '1 microsecond' where k = $1 returning k)
+			insert into kv(k, v, expires_at) select $1, $2, now() + $3 * interval '1 microsecond' where not exists (select from u)
+		`,
+		setNX: `
+			with u as (update kv set v = $2, expires_at = null where k = $1 and expires_at <= now() returning true),
+			i as (insert into kv(k, v) select $1, $2 where not exists (select from kv where k = $1) returning true)
+			select * from u union all select * from i
+		`,
+		incr: `
+			with u as (
+				update kv set
+					v = case when expires_at <= now() then '1' else (v::bigint + 1)::varchar end,
+					expires_at = case when expires_at <= now() then null else expires_at end
+				where k = $1 returning v::bigint
+			),
+			i as (insert into kv(k, v) select $1, '1' where not exists (select from u) returning 1::bigint)
+			select * from u union all select * from i
+		`,
+		incrWindow: `
+			with u as (
+				update kv set
+					v = case when expires_at <= now() then '1' else (v::bigint + 1)::varchar end,
+					expires_at = case when expires_at <= now() then now() + $2 * interval '1 microsecond' else expires_at end
+				where k = $1 returning v::bigint
+			),
+			i as (insert into kv(k, v, expires_at) select $1, '1', now() + $2 * interval '1 microsecond' where not exists (select from u) returning 1::bigint)
+			select * from u union all select * from i
+		`,
+	}
+)
+
+func (s *sqlKV) queries() *sqlQueries {
+	if s.opts.Index == "hash" {
+		return &hashQueries
+	}
+	return &btreeQueries
+}
+
+// retry runs op again when it lost a race to insert the same key into a
+// table with a hash index, and, if the dialect asks for it, when it failed
+// with a serialization error. A btree primary key never fails the first
+// way: ON CONFLICT waits for the other insert instead.
+func (s *sqlKV) retry(ctx context.Context, op func() error) error {
+	backoff := time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := op()
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) {
+			return err
+		}
+		switch {
+		case pqErr.Code == "23P01" && attempt < 2: // exclusion_violation
+			continue
+		case pqErr.Code == "40001" && s.dialect().retrySerialization && attempt < 10: // serialization_failure
+		default:
+			return err
+		}
+
+		// back off with jitter so the conflicting transactions don't
+		// collide again
+		t := time.NewTimer(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		if backoff < 100*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// Durability reports the table type along with the server settings that
+// decide whether a committed write survives a crash. Distributed dialects
+// have no such settings: a commit is always replicated to a quorum.
+func (s *sqlKV) Durability(ctx context.Context) (string, error) {
+	if !s.dialect().pgStats {
+		return "", nil
+	}
+	var syncCommit, fsync string
+	err := s.db.QueryRowContext(ctx, `select current_setting('synchronous_commit'), current_setting('fsync')`).Scan(&syncCommit, &fsync)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("table=%s synchronous_commit=%s fsync=%s", s.opts.Table, syncCommit, fsync), nil
+}
+
+// pgSettings are the Postgres settings ServerInfo reports: memory, WAL and
+// connection limits.
+var pgSettings = []string{"shared_buffers", "effective_cache_size", "work_mem", "max_connections", "synchronous_commit", "fsync", "wal_level", "max_wal_size", "checkpoint_timeout"}
+
+// ServerInfo reads the server's version string and, on Postgres, the
+// settings in pgSettings as the server shows them.
+func (s *sqlKV) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	info := ServerInfo{Driver: driverVersion("github.com/lib/pq")}
+	err := s.db.QueryRowContext(ctx, `select version()`).Scan(&info.Version)
+	if err != nil || !s.dialect().pgStats {
+		return info, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `select name, current_setting(name) from pg_settings where name = any($1)`, pq.Array(pgSettings))
+	if err != nil {
+		return info, err
+	}
+	defer rows.Close()
+	values := make(map[string]string, len(pgSettings))
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return info, err
+		}
+		values[name] = value
+	}
+	info.Config = settings(values, pgSettings...)
+	return info, rows.Err()
+}
+
+func (s *sqlKV) Set(ctx context.Context, key, value string) error {
+	return s.retry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, s.queries().set, key, value)
+		return err
+	})
+}
+
+// SetTTL stores an expiry time that Get filters on, the way applications
+// emulate TTLs on Postgres; expired rows are left for a cleanup job.
+func (s *sqlKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.retry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, s.queries().setTTL, key, value, ttl.Microseconds())
+		return err
+	})
+}
+
+// SetNX's query returns a row only if it set the key.
+func (s *sqlKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	var ok bool
+	err := s.retry(ctx, func() error {
+		return s.db.QueryRowContext(ctx, s.queries().setNX, key, value).Scan(&ok)
+	})
+	var pqErr *pq.Error
+	if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &pqErr) && pqErr.Code == "23P01") {
+		// the key exists, or a concurrent SetNX inserted it first
+		return false, nil
+	}
+	return ok, err
+}
+
+// CompareAndSwap treats an expired row as absent, as SetNX does, so it
+// never swaps one.
+func (s *sqlKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	var n int64
+	err := s.retry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, `update kv set v = $3 where k = $1 and v = $2 and (expires_at is null or expires_at > now())`, key, old, new)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n == 1, err
+}
+
+// Incr restarts an expired counter at 1 with no expiry, as if the row had
+// been deleted when it expired.
+func (s *sqlKV) Incr(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := s.retry(ctx, func() error {
+		return s.db.QueryRowContext(ctx, s.queries().incr, key).Scan(&n)
+	})
+	return n, err
+}
+
+// IncrWindow restarts an expired counter in the same upsert, since expired
+// rows are only filtered out by reads, not deleted.
+func (s *sqlKV) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var n int64
+	err := s.retry(ctx, func() error {
+		return s.db.QueryRowContext(ctx, s.queries().incrWindow, key, ttl.Microseconds()).Scan(&n)
+	})
+	return n, err
+}
+
+func (s *sqlKV) Push(ctx context.Context, queue, value string) error {
+	_, err := s.db.ExecContext(ctx, `insert into kv_queue(queue, v) values($1, $2)`, queue, value)
+	return err
+}
+
+// Pop deletes the oldest row no other Pop has locked, so concurrent
+// consumers skip past each other instead of queueing on one row. Postgres
+// can't block until a row arrives, so an empty queue is polled with
+// backoff until timeout.
+func (s *sqlKV) Pop(ctx context.Context, queue string, timeout time.Duration) (string, bool, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		var v string
+		err := s.retry(ctx, func() error {
+			return s.db.QueryRowContext(ctx, `
+				delete from kv_queue where id = (
+					select id from kv_queue where queue = $1 order by id limit 1 for update skip locked
+				) returning v
+			`, queue).Scan(&v)
+		})
+		if err == nil {
+			return v, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", false, err
+		}
+
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return "", false, nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return "", false, ctx.Err()
+		}
+		backoff = min(2*backoff, 50*time.Millisecond)
+	}
+}
+
+// Scan uses a prefix LIKE, which the "C" collation lets the primary key
+// index serve as a range scan.
+func (s *sqlKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `select k from kv where k like $1 limit $2`, likeEscaper.Replace(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var k string
+		err := rows.Scan(&k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// TxnSet upserts the keys one statement at a time in a transaction, in key
+// order so concurrent transactions on overlapping keys can't deadlock.
+func (s *sqlKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return s.retry(ctx, func() error {
+		return s.txnSet(ctx, keys, kvs)
+	})
+}
+
+func (s *sqlKV) txnSet(ctx context.Context, keys []string, kvs map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, k := range keys {
+		_, err := tx.ExecContext(ctx, s.queries().set, k, kvs[k])
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// BulkLoad streams the rows in with COPY, which can't upsert: an existing
+// key fails the whole batch.
+func (s *sqlKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	return s.retry(ctx, func() error {
+		return s.bulkLoad(ctx, kvs)
+	})
+}
+
+func (s *sqlKV) bulkLoad(ctx context.Context, kvs map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("kv", "k", "v"))
+	if err != nil {
+		return err
+	}
+	for k, v := range kvs {
+		_, err := stmt.ExecContext(ctx, k, v)
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+	err = stmt.Close()
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlKV) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `select v from kv where k = $1 and (expires_at is null or expires_at > now())`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return value, err
+}
+
+// getTTL returns key's value and how long it has left, 0 if it doesn't
+// expire.
+func (s *sqlKV) getTTL(ctx context.Context, key string) (string, time.Duration, error) {
+	var (
+		value string
+		left  sql.NullInt64
+	)
+	err := s.db.QueryRowContext(ctx, `
+		select v, ceil(extract(epoch from expires_at - now()) * 1000000)::bigint
+		from kv where k = $1 and (expires_at is null or expires_at > now())
+	`, key).Scan(&value, &left)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return value, time.Duration(left.Int64) * time.Microsecond, err
+}
+
+// SetBytes stores binary values in kv_bytes, a keyspace of their own.
+func (s *sqlKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return s.retry(ctx, func() error {
+		_, err := s.bin.ExecContext(ctx, s.queries().setBytes, key, value)
+		return err
+	})
+}
+
+func (s *sqlKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.bin.QueryRowContext(ctx, `select v from kv_bytes where k = $1`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return value, err
+}
+
+// Stats reports the on-disk size of the kv table with its indexes and
+// TOAST, and the live row count estimated by the statistics collector.
+// Distributed dialects report neither.
+func (s *sqlKV) Stats(ctx context.Context) (Footprint, error) {
+	t, err := s.TableStats(ctx)
+	if err != nil || t == nil {
+		return Footprint{Keys: -1}, err
+	}
+	return Footprint{Disk: t.Bytes, Keys: t.LiveTuples}, nil
+}
+
+// kvRelations selects the kv table and, if it is partitioned, its
+// partitions, whose rows and statistics belong to the partitions.
+const kvRelations = `select 'kv'::regclass::oid as relid
+	union all select inhrelid from pg_inherits where inhparent = 'kv'::regclass`
+
+// TableStats sums pg_stat_user_tables and the relation sizes over the kv
+// table's partitions. The statistics lag the writes by up to a second or
+// so, until the backends flush them. With the pgstattuple extension
+// installed, it also scans the table for its reusable free space, which
+// takes a while on a big table.
+func (s *sqlKV) TableStats(ctx context.Context) (*TableStats, error) {
+	if !s.dialect().pgStats {
+		return nil, nil
+	}
+	var t TableStats
+	err := s.db.QueryRowContext(ctx, `
+		with r as (`+kvRelations+`)
+		select
+			coalesce(sum(pg_total_relation_size(r.relid)), 0),
+			coalesce(sum(t.n_live_tup), 0),
+			coalesce(sum(t.n_dead_tup), 0),
+			coalesce(sum(t.autovacuum_count), 0),
+			coalesce(sum(t.autoanalyze_count), 0)
+		from r left join pg_stat_user_tables t on t.relid = r.relid`).
+		Scan(&t.Bytes, &t.LiveTuples, &t.DeadTuples, &t.Autovacuums, &t.Autoanalyzes)
+	if err != nil {
+		return nil, err
+	}
+
+	var pgstattuple bool
+	err = s.db.QueryRowContext(ctx, `select exists (select from pg_extension where extname = 'pgstattuple')`).Scan(&pgstattuple)
+	if err != nil {
+		return nil, err
+	}
+	t.FreeBytes = -1
+	if pgstattuple {
+		// a partitioned parent has no storage of its own to scan
+		err = s.db.QueryRowContext(ctx, `
+			with r as (`+kvRelations+`)
+			select coalesce(sum((pgstattuple(r.relid)).free_space), 0)
+			from r join pg_class c on c.oid = r.relid
+			where c.relkind = 'r'`).Scan(&t.FreeBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// checkpointStats are the cumulative checkpoint counters of the server.
+type checkpointStats struct {
+	timed     int64
+	requested int64
+	done      int64   // completed checkpoints, -1 before PostgreSQL 17
+	writeTime float64 // ms
+	syncTime  float64 // ms
+
+	// of the kv table, alongside the checkpoints
+	autovacuums int64 // completed autovacuums
+	vacuuming   int64 // vacuums running now
+}
+
+// NewEventProbe reports checkpoints from pg_stat_checkpointer, or
+// pg_stat_bgwriter before PostgreSQL 17, since a checkpoint flushing dirty
+// pages competes with the benchmark's writes, and so do vacuums of the kv
+// table. Distributed dialects have none of these views, so they get no
+// probe.
+func (s *sqlKV) NewEventProbe() EventProbe {
+	if !s.dialect().pgStats {
+		return nil
+	}
+	var version int
+	var prev *checkpointStats
+	return func(ctx context.Context) ([]string, error) {
+		if version == 0 {
+			err := s.db.QueryRowContext(ctx, `select current_setting('server_version_num')::int`).Scan(&version)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var cur checkpointStats
+		var err error
+		if version >= 170000 {
+			err = s.db.QueryRowContext(ctx, `select num_timed, num_requested, num_done, write_time, sync_time from pg_stat_checkpointer`).
+				Scan(&cur.timed, &cur.requested, &cur.done, &cur.writeTime, &cur.syncTime)
+		} else {
+			cur.done = -1
+			err = s.db.QueryRowContext(ctx, `select checkpoints_timed, checkpoints_req, checkpoint_write_time, checkpoint_sync_time from pg_stat_bgwriter`).
+				Scan(&cur.timed, &cur.requested, &cur.writeTime, &cur.syncTime)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// vacuum progress isn't in the cumulative statistics, so a running
+		// autovacuum shows up as a row of pg_stat_progress_vacuum
+		err = s.db.QueryRowContext(ctx, `
+			with r as (`+kvRelations+`)
+			select
+				(select coalesce(sum(autovacuum_count), 0) from pg_stat_user_tables where relid in (select relid from r)),
+				(select count(*) from pg_stat_progress_vacuum where relid in (select relid from r))`).
+			Scan(&cur.autovacuums, &cur.vacuuming)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil {
+			prev = &cur
+			return nil, nil
+		}
+		events := append(checkpointEvents(*prev, cur), vacuumEvents(*prev, cur)...)
+		prev = &cur
+		return events, nil
+	}
+}
+
+// vacuumEvents reports vacuums of the kv table starting, and autovacuums
+// completing.
+func vacuumEvents(prev, cur checkpointStats) []string {
+	var events []string
+	if cur.vacuuming > prev.vacuuming {
+		events = append(events, "vacuum started")
+	}
+	if n := cur.autovacuums - prev.autovacuums; n > 0 {
+		events = append(events, fmt.Sprintf("autovacuum finished (%d)", n))
+	}
+	return events
+}
+
+// checkpointEvents compares two readings. The timed and requested counters
+// go up when a checkpoint starts; the write and sync times (and num_done on
+// 17+) when it finishes.
+func checkpointEvents(prev, cur checkpointStats) []string {
+	var events []string
+	if cur.timed > prev.timed {
+		events = append(events, "checkpoint started (timed)")
+	}
+	if cur.requested > prev.requested {
+		events = append(events, "checkpoint started (requested)")
+	}
+	finished := cur.writeTime != prev.writeTime || cur.syncTime != prev.syncTime
+	if cur.done >= 0 {
+		finished = cur.done > prev.done
+	}
+	if finished {
+		ms := func(v float64) time.Duration {
+			return time.Duration(v * float64(time.Millisecond)).Round(time.Millisecond)
+		}
+		events = append(events, fmt.Sprintf("checkpoint finished (write %s, sync %s)", ms(cur.writeTime-prev.writeTime), ms(cur.syncTime-prev.syncTime)))
+	}
+	return events
+}
@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// sqlTimings accumulates where the sql backend's operations spend their
+// time below database/sql: dialing new connections, waiting on the server
+// for a statement's response, and reading result rows. Waiting for a pooled
+// connection is read from the pools' own statistics.
+type sqlTimings struct {
+	connects atomic.Uint64
+	connect  atomic.Int64
+	queries  atomic.Uint64
+	query    atomic.Int64
+	fetch    atomic.Int64
+}
+
+func (t *sqlTimings) since(d *atomic.Int64, start time.Time) {
+	d.Add(int64(time.Since(start)))
+}
+
+// Timings reads the time spent so far in each stage, summed over both
+// connection pools.
+func (s *sqlKV) Timings() Timings {
+	t := Timings{
+		Connects: s.timings.connects.Load(),
+		Connect:  time.Duration(s.timings.connect.Load()),
+		Queries:  s.timings.queries.Load(),
+		Query:    time.Duration(s.timings.query.Load()),
+		Fetch:    time.Duration(s.timings.fetch.Load()),
+	}
+	for _, db := range []*sql.DB{s.db, s.bin} {
+		st := db.Stats()
+		t.PoolWaits += uint64(st.WaitCount)
+		t.PoolWait += st.WaitDuration
+	}
+	return t
+}
+
+// timedConnector times the connections it opens, and everything they run.
+type timedConnector struct {
+	driver.Connector
+	t *sqlTimings
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	start := time.Now()
+	conn, err := c.Connector.Connect(ctx)
+	c.t.connects.Add(1)
+	c.t.since(&c.t.connect, start)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn: conn, t: c.t}, nil
+}
+
+// timedConn passes through the driver interfaces lib/pq's conn implements.
+// A statement is timed until its response arrives; for a query that is the
+// row description, and the rows are fetched as they are read.
+type timedConn struct {
+	conn driver.Conn
+	t    *sqlTimings
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *timedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *timedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	tx, err := c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	c.t.queries.Add(1)
+	c.t.since(&c.t.query, start)
+	if err != nil {
+		return nil, err
+	}
+	return timedTx{tx: tx, t: c.t}, nil
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	res, err := c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	c.t.queries.Add(1)
+	c.t.since(&c.t.query, start)
+	return res, err
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	c.t.queries.Add(1)
+	c.t.since(&c.t.query, start)
+	if err != nil {
+		return nil, err
+	}
+	return timedRows{Rows: rows, t: c.t}, nil
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
+
+type timedTx struct {
+	tx driver.Tx
+	t  *sqlTimings
+}
+
+func (x timedTx) Commit() error {
+	start := time.Now()
+	err := x.tx.Commit()
+	x.t.queries.Add(1)
+	x.t.since(&x.t.query, start)
+	return err
+}
+
+func (x timedTx) Rollback() error {
+	return x.tx.Rollback()
+}
+
+type timedRows struct {
+	driver.Rows
+	t *sqlTimings
+}
+
+func (r timedRows) Next(dest []driver.Value) error {
+	start := time.Now()
+	err := r.Rows.Next(dest)
+	r.t.since(&r.t.fetch, start)
+	return err
+}
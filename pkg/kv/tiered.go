@@ -0,0 +1,227 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Invalidation strategies of the tiered backend: how a write to the source
+// of truth reaches the cache.
+const (
+	// TieredWriteThrough writes the new value to the cache too.
+	TieredWriteThrough = "write-through"
+
+	// TieredInvalidate deletes the cached value, so the next read fetches
+	// it from the source.
+	TieredInvalidate = "invalidate"
+)
+
+// DefaultTieredTTL is how long the tiered backend caches a value unless
+// -tiered-ttl says otherwise.
+const DefaultTieredTTL = 5 * time.Minute
+
+// tieredKV is Redis caching Postgres, the common "cache in front of the
+// source of truth" setup. Reads try the cache and fall through to the
+// source on a miss, caching what they find for ttl; writes go to the source
+// first, then update or invalidate the cache. Scans only go to the source,
+// which has every key.
+type tieredKV struct {
+	cache        *redisKV
+	source       tieredSource
+	ttl          time.Duration // 0 caches values until they are evicted
+	invalidation string
+}
+
+// tieredSource is a source of truth that can say how long a key has left,
+// so a value read into the cache expires with its key.
+type tieredSource interface {
+	KV
+	getTTL(ctx context.Context, key string) (string, time.Duration, error)
+}
+
+// NewTieredKV caches source in the Redis server at conn, keeping entries
+// for ttl.
+func NewTieredKV(conn RedisConn, dialer *SourceDialer, source KV, ttl time.Duration, invalidation string) (KV, error) {
+	src, ok := source.(tieredSource)
+	if !ok {
+		return nil, fmt.Errorf("tiered: %s can't report key expiry, so it can't be cached", source.Name())
+	}
+	switch invalidation {
+	case "":
+		invalidation = TieredWriteThrough
+	case TieredWriteThrough, TieredInvalidate:
+	default:
+		return nil, fmt.Errorf("unknown tiered invalidation: %s", invalidation)
+	}
+	cache, err := NewRedisKV(conn, "", dialer)
+	if err != nil {
+		return nil, err
+	}
+	return &tieredKV{cache: cache.(*redisKV), source: src, ttl: ttl, invalidation: invalidation}, nil
+}
+
+func (t *tieredKV) Name() string {
+	return "tiered"
+}
+
+func (t *tieredKV) Setup(ctx context.Context) error {
+	err := t.source.Setup(ctx)
+	if err != nil {
+		return err
+	}
+	return t.cache.Setup(ctx)
+}
+
+func (t *tieredKV) Close(ctx context.Context) error {
+	return errors.Join(t.cache.Close(ctx), t.source.Close(ctx))
+}
+
+// Durability is the source's: the cache holds nothing the source doesn't.
+func (t *tieredKV) Durability(ctx context.Context) (string, error) {
+	d, ok := t.source.(durabilityReporter)
+	if !ok {
+		return "", nil
+	}
+	return d.Durability(ctx)
+}
+
+// cached returns the cache's TTL for a value written with ttl, 0 for
+// none: an entry mustn't outlive the key it caches.
+func (t *tieredKV) cached(ttl time.Duration) time.Duration {
+	if ttl > 0 && (t.ttl == 0 || ttl < t.ttl) {
+		return ttl
+	}
+	return t.ttl
+}
+
+// written brings the cache up to date after keys were written to the
+// source with ttl, which is 0 for keys that don't expire.
+func (t *tieredKV) written(ctx context.Context, kvs map[string]any, ttl time.Duration) error {
+	if t.invalidation == TieredInvalidate {
+		keys := make([]string, 0, len(kvs))
+		for k := range kvs {
+			keys = append(keys, k)
+		}
+		return t.cache.client.Del(ctx, keys...).Err()
+	}
+	if len(kvs) == 1 {
+		for k, v := range kvs {
+			return t.cache.client.Set(ctx, k, v, t.cached(ttl)).Err()
+		}
+	}
+	_, err := t.cache.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range kvs {
+			p.Set(ctx, k, v, t.cached(ttl))
+		}
+		return nil
+	})
+	return err
+}
+
+func (t *tieredKV) Set(ctx context.Context, key, value string) error {
+	err := t.source.Set(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	return t.written(ctx, map[string]any{key: value}, 0)
+}
+
+func (t *tieredKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := t.cache.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return v, err
+	}
+	v, left, err := t.source.getTTL(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return v, t.cache.client.Set(ctx, key, v, t.cached(left)).Err()
+}
+
+func (t *tieredKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	err := t.source.SetBytes(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	return t.written(ctx, map[string]any{key: value}, 0)
+}
+
+func (t *tieredKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, err := t.cache.GetBytes(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return v, err
+	}
+	// binary values can't be written with an expiry, so t.ttl is theirs
+	v, err = t.source.GetBytes(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return v, t.cache.client.Set(ctx, key, v, t.ttl).Err()
+}
+
+func (t *tieredKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	err := t.source.SetTTL(ctx, key, value, ttl)
+	if err != nil {
+		return err
+	}
+	return t.written(ctx, map[string]any{key: value}, ttl)
+}
+
+func (t *tieredKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	ok, err := t.source.SetNX(ctx, key, value)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return ok, t.written(ctx, map[string]any{key: value}, 0)
+}
+
+// CompareAndSwap compares against the source, since the cached value may
+// be stale.
+func (t *tieredKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	ok, err := t.source.CompareAndSwap(ctx, key, old, new)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return ok, t.written(ctx, map[string]any{key: new}, 0)
+}
+
+func (t *tieredKV) Incr(ctx context.Context, key string) (int64, error) {
+	n, err := t.source.Incr(ctx, key)
+	if err != nil {
+		return n, err
+	}
+	return n, t.written(ctx, map[string]any{key: strconv.FormatInt(n, 10)}, 0)
+}
+
+func (t *tieredKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return t.source.Scan(ctx, prefix, limit)
+}
+
+func (t *tieredKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	err := t.source.TxnSet(ctx, kvs)
+	if err != nil {
+		return err
+	}
+	return t.written(ctx, anyValues(kvs), 0)
+}
+
+func (t *tieredKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	err := t.source.BulkLoad(ctx, kvs)
+	if err != nil {
+		return err
+	}
+	return t.written(ctx, anyValues(kvs), 0)
+}
+
+func anyValues(kvs map[string]string) map[string]any {
+	m := make(map[string]any, len(kvs))
+	for k, v := range kvs {
+		m[k] = v
+	}
+	return m
+}
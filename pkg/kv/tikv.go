@@ -0,0 +1,209 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/tikv/client-go/v2/rawkv"
+	pd "github.com/tikv/pd/client"
+	"google.golang.org/grpc"
+)
+
+// tikvPrefix is the key prefix the benchmark writes under, so Setup can
+// clear its range without touching anything else in the cluster.
+const tikvPrefix = "kvperf/"
+
+var errTiKVTxn = errors.New("tikv raw kv: no multi-key transactions")
+
+// tikvKV stores keys with TiKV's raw KV API, which skips the transaction
+// layer: a write is one Raft proposal to the region owning the key, fsynced
+// on a quorum of its replicas before it returns.
+//
+// The client runs in atomic mode, which CompareAndSwap needs and which plain
+// puts must then use too to stay linearizable with it; it costs every write a
+// latch on the key. SetTTL needs TiKV's storage.enable-ttl. Raw mode has no
+// transactions, so TxnSet fails.
+type tikvKV struct {
+	pdAddrs []string
+	dialer  *SourceDialer
+	client  *rawkv.Client
+}
+
+// NewTiKVKV prepares a client for the cluster whose PD (placement driver)
+// endpoints are pdAddrs, dialing through dialer if it isn't nil. It connects
+// in Setup, since the client fails right away when PD isn't up yet.
+func NewTiKVKV(pdAddrs []string, dialer *SourceDialer) (KV, error) {
+	if len(pdAddrs) == 0 {
+		return nil, errors.New("tikv: no PD addresses")
+	}
+	return &tikvKV{pdAddrs: pdAddrs, dialer: dialer}, nil
+}
+
+func (t *tikvKV) Name() string {
+	return "tikv"
+}
+
+func (t *tikvKV) connect(ctx context.Context) error {
+	var dial []grpc.DialOption
+	if t.dialer != nil {
+		dial = append(dial, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return t.dialer.DialContext(ctx, "tcp", addr)
+		}))
+	}
+	client, err := rawkv.NewClientWithOpts(ctx, t.pdAddrs,
+		rawkv.WithPDOptions(pd.WithGRPCDialOptions(dial...)),
+		rawkv.WithGRPCDialOptions(dial...),
+	)
+	if err != nil {
+		return err
+	}
+	t.client = client.SetAtomicForCAS(true)
+	return nil
+}
+
+// Setup connects on its first call, then deletes the benchmark's key range.
+func (t *tikvKV) Setup(ctx context.Context) error {
+	if t.client == nil {
+		err := t.connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return t.client.DeleteRange(ctx, []byte(tikvPrefix), prefixEnd([]byte(tikvPrefix)))
+}
+
+func (t *tikvKV) Close(ctx context.Context) error {
+	if t.client == nil {
+		return nil
+	}
+	return closeContext(ctx, t.client.Close)
+}
+
+// prefixEnd returns the first key after every key starting with prefix, or
+// nil, meaning no end, if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func tikvKey(key string) []byte {
+	return []byte(tikvPrefix + key)
+}
+
+func (t *tikvKV) Set(ctx context.Context, key, value string) error {
+	return t.client.Put(ctx, tikvKey(key), []byte(value))
+}
+
+func (t *tikvKV) Get(ctx context.Context, key string) (string, error) {
+	v, err := t.GetBytes(ctx, key)
+	return string(v), err
+}
+
+func (t *tikvKV) SetBytes(ctx context.Context, key string, value []byte) error {
+	return t.client.Put(ctx, tikvKey(key), value)
+}
+
+// GetBytes reads key; the raw client returns a nil value for a missing one.
+func (t *tikvKV) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	v, err := t.client.Get(ctx, tikvKey(key))
+	if err == nil && v == nil {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+// SetTTL rounds ttl up to whole seconds, TiKV's TTL resolution.
+func (t *tikvKV) SetTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	secs := uint64((ttl + time.Second - 1) / time.Second)
+	return t.client.PutWithTTL(ctx, tikvKey(key), []byte(value), secs)
+}
+
+// SetNX is a compare-and-swap against a missing key.
+func (t *tikvKV) SetNX(ctx context.Context, key, value string) (bool, error) {
+	_, ok, err := t.client.CompareAndSwap(ctx, tikvKey(key), nil, []byte(value))
+	return ok, err
+}
+
+func (t *tikvKV) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	_, ok, err := t.client.CompareAndSwap(ctx, tikvKey(key), []byte(old), []byte(new))
+	return ok, err
+}
+
+// Incr retries a compare-and-swap from the value it read until no other
+// writer gets in between, so contended counters take several round trips
+// per increment.
+func (t *tikvKV) Incr(ctx context.Context, key string) (int64, error) {
+	k := tikvKey(key)
+	prev, err := t.client.Get(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var v int64
+		if prev != nil {
+			v, err = strconv.ParseInt(string(prev), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		v++
+		// a failed swap returns the current value to retry from
+		cur, ok, err := t.client.CompareAndSwap(ctx, k, prev, []byte(strconv.FormatInt(v, 10)))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return v, nil
+		}
+		prev = cur
+	}
+}
+
+// Scan reads the prefix's key range in order, without the values.
+func (t *tikvKV) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	start := tikvKey(prefix)
+	end := prefixEnd(start)
+	var keys []string
+	for len(keys) < limit {
+		n := min(limit-len(keys), rawkv.MaxRawKVScanLimit)
+		page, _, err := t.client.Scan(ctx, start, end, n, rawkv.ScanKeyOnly())
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range page {
+			keys = append(keys, string(k[len(tikvPrefix):]))
+		}
+		if len(page) < n {
+			break
+		}
+		// continue just past the last key
+		start = append(page[len(page)-1], 0)
+	}
+	return keys, nil
+}
+
+func (t *tikvKV) TxnSet(ctx context.Context, kvs map[string]string) error {
+	return errTiKVTxn
+}
+
+// BulkLoad sends the keys with BatchPut, which groups them by region and
+// writes the regions in parallel.
+func (t *tikvKV) BulkLoad(ctx context.Context, kvs map[string]string) error {
+	keys := make([][]byte, 0, len(kvs))
+	values := make([][]byte, 0, len(kvs))
+	for k, v := range kvs {
+		keys = append(keys, tikvKey(k))
+		values = append(values, []byte(v))
+	}
+	return t.client.BatchPut(ctx, keys, values)
+}
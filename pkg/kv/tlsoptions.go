@@ -0,0 +1,96 @@
+package kv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions configures TLS to a backend, as managed services require. The
+// files are read where the client runs, so agents need them at the same
+// paths.
+type TLSOptions struct {
+	CA         string // PEM file of the CAs to verify the server with, instead of the system's
+	Cert       string // PEM client certificate, for mutual TLS
+	Key        string // PEM key of Cert
+	SkipVerify bool   // don't verify the server's certificate
+}
+
+// Enabled reports whether any option is set, which turns TLS on.
+func (o TLSOptions) Enabled() bool {
+	return o != TLSOptions{}
+}
+
+func (o TLSOptions) validate() error {
+	if (o.Cert == "") != (o.Key == "") {
+		return errors.New("tls: a client certificate needs both a cert and a key file")
+	}
+	return nil
+}
+
+// Config builds a client config for a server named serverName, starting
+// from base if it isn't nil.
+func (o TLSOptions) Config(base *tls.Config, serverName string) (*tls.Config, error) {
+	err := o.validate()
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{}
+	if base != nil {
+		c = base.Clone()
+	}
+	if c.ServerName == "" {
+		c.ServerName = serverName
+	}
+	c.InsecureSkipVerify = o.SkipVerify
+	if o.CA != "" {
+		pem, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", o.CA)
+		}
+	}
+	if o.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// PostgresURI sets lib/pq's sslmode, sslrootcert, sslcert and sslkey
+// parameters of uri from o. The server is verified against its host name
+// unless SkipVerify is set.
+func (o TLSOptions) PostgresURI(uri string) (string, error) {
+	if !o.Enabled() {
+		return uri, nil
+	}
+	err := o.validate()
+	if err != nil {
+		return "", err
+	}
+	mode := "verify-full"
+	if o.SkipVerify {
+		// lib/pq verifies against sslrootcert even in require mode
+		mode, o.CA = "require", ""
+	}
+	for _, p := range [][2]string{
+		{"sslmode", mode},
+		{"sslrootcert", o.CA},
+		{"sslcert", o.Cert},
+		{"sslkey", o.Key},
+	} {
+		uri, err = withConnParam(uri, p[0], p[1])
+		if err != nil {
+			return "", err
+		}
+	}
+	return uri, nil
+}